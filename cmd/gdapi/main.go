@@ -9,10 +9,10 @@ import (
 	"syscall"
 
 	"github.com/sagerenn/mdict/internal/config"
-	"github.com/sagerenn/mdict/internal/dict/loader"
 	"github.com/sagerenn/mdict/internal/dict/registry"
 	"github.com/sagerenn/mdict/internal/httpx"
 	"github.com/sagerenn/mdict/internal/observability"
+	"github.com/sagerenn/mdict/internal/reload"
 	"github.com/sagerenn/mdict/internal/service"
 )
 
@@ -26,20 +26,27 @@ func main() {
 	}
 
 	log := observability.New(cfg.Log.Level)
-	loadRes := loader.LoadAll(cfg)
-	if len(loadRes.Errs) > 0 {
-		for _, e := range loadRes.Errs {
-			log.Error("dictionary load error", "error", e)
-		}
-	}
-
 	reg := registry.New()
-	if err := reg.MustAddAll(loadRes.Dicts); err != nil {
-		fatal("registry", err)
-	}
+	reg.SetCloseDelay(cfg.WriteTimeout)
+	svc := service.NewWithOptions(reg, service.Options{
+		Concurrency: cfg.Query.Concurrency,
+		DictTimeout: cfg.Query.DictTimeout,
+	})
+	reloader := reload.New(*cfgPath, reg, svc, log)
+	reloader.Apply(cfg)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go reloader.Run(ctx, cfg.ReloadInterval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloader.Trigger()
+		}
+	}()
 
-	svc := service.New(reg)
-	h := httpx.NewRouter(svc, log, cfg.URLBasePath)
+	h := httpx.NewRouterWithAdmin(svc, log, cfg.URLBasePath, reloader, cfg.Admin.Token)
 
 	srv := &http.Server{
 		Addr:         cfg.Listen,
@@ -60,9 +67,9 @@ func main() {
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 	<-shutdown
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	_ = srv.Shutdown(ctx)
+	_ = srv.Shutdown(shutdownCtx)
 	log.Info("server stopped")
 }
 
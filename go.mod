@@ -3,21 +3,16 @@ module github.com/sagerenn/mdict
 go 1.24.0
 
 require (
-	github.com/ChaosNyaruko/ondict v0.4.0
+	github.com/C0MM4ND/go-ripemd v0.0.0-20200326052756-bd1759ad7d10
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/ianlewis/go-dictzip v0.2.0
 	github.com/ianlewis/go-stardict v0.2.0
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
 	golang.org/x/text v0.33.0
 )
 
 require (
-	github.com/C0MM4ND/go-ripemd v0.0.0-20200326052756-bd1759ad7d10 // indirect
 	github.com/gobwas/glob v0.2.3 // indirect
-	github.com/ianlewis/go-dictzip v0.2.0 // indirect
 	github.com/k3a/html2text v1.2.1 // indirect
-	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
-	github.com/rivo/uniseg v0.4.7 // indirect
-	github.com/schollz/progressbar/v3 v3.14.6 // indirect
-	github.com/sirupsen/logrus v1.9.3 // indirect
-	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/term v0.31.0 // indirect
 )
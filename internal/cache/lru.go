@@ -76,3 +76,11 @@ func expiry(ttl time.Duration) time.Time {
 	}
 	return time.Now().Add(ttl)
 }
+
+// Clear removes all entries.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.data = make(map[string]*list.Element)
+}
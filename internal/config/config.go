@@ -1,9 +1,13 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -15,8 +19,47 @@ type Config struct {
 	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
 	Log             LogConfig     `json:"log"`
 	Dictionaries    []DictConfig  `json:"dictionaries"`
+	// DictionaryDirs are scanned recursively for dictionary files, which
+	// are loaded after Dictionaries.
+	DictionaryDirs []DirConfig `json:"dictionary_dirs,omitempty"`
+	// ReloadInterval, when set, polls the config file, dictionary files
+	// and dictionary_dirs for changes and reloads them. SIGHUP always
+	// triggers a reload.
+	ReloadInterval time.Duration `json:"reload_interval,omitempty"`
+	Admin          AdminConfig   `json:"admin"`
+	// Groups are named, ordered sets of dictionaries selected with the
+	// group query parameter.
+	Groups []GroupConfig `json:"groups,omitempty"`
+	Query  QueryConfig   `json:"query"`
 }
 
+// QueryConfig bounds how lookups fan out over dictionaries: at most
+// Concurrency dictionaries are queried at once and each gets DictTimeout,
+// waiting for a free slot included; dictionaries that time out are
+// reported and left out of the results.
+type QueryConfig struct {
+	Concurrency int           `json:"concurrency"`
+	DictTimeout time.Duration `json:"dict_timeout"`
+}
+
+type GroupConfig struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Dictionaries []string `json:"dictionaries"`
+}
+
+// AdminConfig enables the /admin API when a token is set; requests must
+// send it as a bearer token. The token is read from the GDAPI_ADMIN_TOKEN
+// environment variable, then from TokenFile, then from Token, so it need
+// not be kept in the config file.
+type AdminConfig struct {
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// AdminTokenEnv overrides the admin token of the config file.
+const AdminTokenEnv = "GDAPI_ADMIN_TOKEN"
+
 type LogConfig struct {
 	Level string `json:"level"`
 }
@@ -28,6 +71,45 @@ type DictConfig struct {
 	Path      string `json:"path"`
 	Delimiter string `json:"delimiter"`
 	CaseFold  bool   `json:"case_fold"`
+	// RegCode and the Email or DeviceID it was issued for unlock MDX
+	// dictionaries with an encrypted keyword index.
+	RegCode  string `json:"regcode,omitempty"`
+	Email    string `json:"email,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	// Schema maps the columns of tsv/csv files to entry fields.
+	Schema *SchemaConfig `json:"schema,omitempty"`
+}
+
+// DirConfig is a directory of dictionaries. In JSON it may also be given
+// as a plain path string.
+type DirConfig struct {
+	Path     string `json:"path"`
+	CaseFold bool   `json:"case_fold"`
+}
+
+func (d *DirConfig) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*d = DirConfig{Path: path}
+		return nil
+	}
+	type plain DirConfig
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// SchemaConfig describes a delimited file: columns are named by header
+// (when Header is set) or by 1-based position, and Template renders them
+// into the definition HTML.
+type SchemaConfig struct {
+	Header         bool   `json:"header"`
+	Headword       string `json:"headword"`
+	Aliases        string `json:"aliases,omitempty"`
+	AliasSeparator string `json:"alias_separator,omitempty"`
+	PartOfSpeech   string `json:"pos,omitempty"`
+	Pronunciation  string `json:"pronunciation,omitempty"`
+	Definition     string `json:"definition,omitempty"`
+	Example        string `json:"example,omitempty"`
+	Template       string `json:"template,omitempty"`
 }
 
 func Default() Config {
@@ -40,6 +122,10 @@ func Default() Config {
 			Level: "info",
 		},
 		Dictionaries: nil,
+		Query: QueryConfig{
+			Concurrency: 8,
+			DictTimeout: 10 * time.Second,
+		},
 	}
 }
 
@@ -67,5 +153,136 @@ func Load(path string) (Config, error) {
 	if cfg.ShutdownTimeout == 0 {
 		cfg.ShutdownTimeout = 10 * time.Second
 	}
+	if cfg.Query.Concurrency <= 0 {
+		cfg.Query.Concurrency = 8
+	}
+	if err := resolveAdminToken(&cfg.Admin, filepath.Dir(path)); err != nil {
+		return cfg, err
+	}
 	return cfg, nil
 }
+
+// resolveAdminToken sets Token from the environment or TokenFile, which is
+// relative to dir.
+func resolveAdminToken(a *AdminConfig, dir string) error {
+	if t := strings.TrimSpace(os.Getenv(AdminTokenEnv)); t != "" {
+		a.Token = t
+		return nil
+	}
+	if a.TokenFile == "" {
+		return nil
+	}
+	p := a.TokenFile
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("read admin token: %w", err)
+	}
+	a.Token = strings.TrimSpace(string(data))
+	return nil
+}
+
+// SaveDictionaries replaces the dictionaries list of the config file at
+// path, atomically. Other fields are kept as written and in their order;
+// defaults and the admin token from the environment or token_file are not
+// added.
+func SaveDictionaries(path string, dicts []DictConfig) error {
+	fields, err := readFields(path)
+	if err != nil {
+		return err
+	}
+	if dicts == nil {
+		dicts = []DictConfig{}
+	}
+	value, err := json.Marshal(dicts)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range fields {
+		if fields[i].key == "dictionaries" {
+			fields[i].value = value
+			replaced = true
+		}
+	}
+	if !replaced {
+		fields = append(fields, field{key: "dictionaries", value: value})
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(f.value)
+	}
+	b.WriteByte('}')
+	var out bytes.Buffer
+	if err := json.Indent(&out, b.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	return writeFile(path, out.Bytes())
+}
+
+// field is a top-level member of the config file.
+type field struct {
+	key   string
+	value json.RawMessage
+}
+
+// readFields reads the top-level members of the JSON object at path in
+// file order. A missing file has none.
+func readFields(path string) ([]field, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("%s: not a JSON object", path)
+	}
+	var fields []field
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var f field
+		f.key, _ = tok.(string)
+		if err := dec.Decode(&f.value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// writeFile replaces path atomically, keeping its permissions.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		_ = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gdapi.json")
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"admin": {"token_file": "token"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(AdminTokenEnv, "")
+	cfg, err := Load(path)
+	if err != nil || cfg.Admin.Token != "from-file" {
+		t.Fatalf("token = %q, %v", cfg.Admin.Token, err)
+	}
+	t.Setenv(AdminTokenEnv, "from-env")
+	if cfg, err = Load(path); err != nil || cfg.Admin.Token != "from-env" {
+		t.Fatalf("token = %q, %v", cfg.Admin.Token, err)
+	}
+
+	t.Setenv(AdminTokenEnv, "")
+	if err := os.WriteFile(path, []byte(`{"admin": {"token_file": "missing"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("missing token file was not reported")
+	}
+}
+
+func TestSaveDictionaries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gdapi.json")
+	orig := `{
+	"listen": ":9090",
+	"admin": {"token": "secret"},
+	"dictionaries": [{"id": "old", "path": "old.tsv"}],
+	"groups": [{"id": "g", "dictionaries": ["old"]}]
+}`
+	if err := os.WriteFile(path, []byte(orig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveDictionaries(path, []DictConfig{{ID: "new", Type: "tsv", Path: "new.tsv"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(data)
+	order := []string{`"listen"`, `"admin"`, `"dictionaries"`, `"groups"`}
+	for i := 1; i < len(order); i++ {
+		if strings.Index(s, order[i-1]) > strings.Index(s, order[i]) {
+			t.Fatalf("fields reordered:\n%s", s)
+		}
+	}
+	if strings.Contains(s, `"old.tsv"`) || strings.Contains(s, "read_timeout") || strings.Contains(s, "query") {
+		t.Fatalf("saved file:\n%s", s)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v, %v", info.Mode(), err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Dictionaries) != 1 || cfg.Dictionaries[0].ID != "new" || cfg.Listen != ":9090" || cfg.Admin.Token != "secret" || len(cfg.Groups) != 1 {
+		t.Fatalf("reloaded config = %+v", cfg)
+	}
+
+	// A missing file gets just the dictionaries.
+	path = filepath.Join(t.TempDir(), "new.json")
+	if err := SaveDictionaries(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{\n  \"dictionaries\": []\n}\n" {
+		t.Fatalf("new file = %q", data)
+	}
+}
@@ -0,0 +1,232 @@
+package bgl
+
+import (
+	"context"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+type Dictionary struct {
+	id        string
+	name      string
+	caseFold  bool
+	info      map[string]string
+	articles  []article
+	resources map[string][]byte
+	keys      []key
+	normIndex map[string][]int
+	sortedN   []string
+	sortedW   []string
+}
+
+func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
+	if cached, ok, err := loadCache(path, caseFold); err == nil && ok {
+		return &Dictionary{
+			id:        id,
+			name:      dictName(name, cached.Info, id),
+			caseFold:  caseFold,
+			info:      cached.Info,
+			articles:  cached.Articles,
+			resources: cached.Resources,
+			keys:      cached.Keys,
+			normIndex: cached.NormToKeys,
+			sortedN:   cached.SortedNorm,
+			sortedW:   cached.SortedWord,
+		}, nil
+	}
+
+	src, err := readSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]key, 0, len(src.articles))
+	normIndex := make(map[string][]int)
+	type item struct {
+		norm string
+		word string
+	}
+	items := make([]item, 0, len(src.articles))
+	add := func(word string, art int, alt bool) {
+		i := len(keys)
+		keys = append(keys, key{Word: word, Article: art, Alternate: alt})
+		norm := normalize(word, caseFold)
+		normIndex[norm] = append(normIndex[norm], i)
+		items = append(items, item{norm: norm, word: word})
+	}
+	for i, a := range src.articles {
+		add(a.Headword, i, false)
+		for _, alt := range a.Alternates {
+			add(alt, i, true)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].norm == items[j].norm {
+			return items[i].word < items[j].word
+		}
+		return items[i].norm < items[j].norm
+	})
+	sortedN := make([]string, 0, len(items))
+	sortedW := make([]string, 0, len(items))
+	for _, it := range items {
+		sortedN = append(sortedN, it.norm)
+		sortedW = append(sortedW, it.word)
+	}
+
+	_ = saveCache(path, &cacheIndex{
+		CaseFold:   caseFold,
+		Info:       src.info,
+		Articles:   src.articles,
+		Resources:  src.resources,
+		Keys:       keys,
+		NormToKeys: normIndex,
+		SortedNorm: sortedN,
+		SortedWord: sortedW,
+	})
+
+	return &Dictionary{
+		id:        id,
+		name:      dictName(name, src.info, id),
+		caseFold:  caseFold,
+		info:      src.info,
+		articles:  src.articles,
+		resources: src.resources,
+		keys:      keys,
+		normIndex: normIndex,
+		sortedN:   sortedN,
+		sortedW:   sortedW,
+	}, nil
+}
+
+// dictName prefers the configured name, then the glossary title, then the id.
+func dictName(name string, info map[string]string, id string) string {
+	if name != "" {
+		return name
+	}
+	if t := info["title"]; t != "" {
+		return t
+	}
+	return id
+}
+
+func (d *Dictionary) ID() string {
+	return d.id
+}
+
+func (d *Dictionary) Name() string {
+	return d.name
+}
+
+// Metadata returns the glossary properties (title, author, email, copyright,
+// description) and the source/target charsets.
+func (d *Dictionary) Metadata() map[string]string {
+	out := make(map[string]string, len(d.info))
+	for k, v := range d.info {
+		out[k] = v
+	}
+	return out
+}
+
+func (d *Dictionary) Lookup(word string) []dict.Entry {
+	idxs := d.normIndex[normalize(word, d.caseFold)]
+	if len(idxs) == 0 {
+		return nil
+	}
+	out := make([]dict.Entry, 0, len(idxs))
+	seen := make(map[int]bool, len(idxs))
+	// Headword hits first; alternates resolve to their article and carry
+	// the matched form.
+	for _, alt := range []bool{false, true} {
+		for _, i := range idxs {
+			k := d.keys[i]
+			if k.Alternate != alt || seen[k.Article] {
+				continue
+			}
+			seen[k.Article] = true
+			a := d.articles[k.Article]
+			e := dict.Entry{Word: a.Headword, Definition: d.render(a)}
+			if alt {
+				e.Synonym = k.Word
+			}
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
+	if limit <= 0 {
+		limit = 20
+	}
+	pfx := normalize(prefix, d.caseFold)
+	idx := sort.Search(len(d.sortedN), func(i int) bool {
+		return d.sortedN[i] >= pfx
+	})
+	if idx == len(d.sortedN) {
+		return nil
+	}
+	out := make([]dict.Entry, 0, limit)
+	for i := idx; i < len(d.sortedN) && len(out) < limit; i++ {
+		if !strings.HasPrefix(d.sortedN[i], pfx) {
+			break
+		}
+		out = append(out, dict.Entry{Word: d.sortedW[i]})
+	}
+	return out
+}
+
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
+	return out
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
+}
+
+// Resource serves images and sounds embedded in the glossary.
+func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
+	clean := dict.CleanResourceName(name)
+	if clean == "" {
+		return nil, "", false
+	}
+	data, ok := d.resources[strings.ToLower(clean)]
+	if !ok {
+		// Embedded resources are stored flat.
+		data, ok = d.resources[strings.ToLower(filepath.Base(clean))]
+	}
+	if !ok {
+		return nil, "", false
+	}
+	return data, mime.TypeByExtension(filepath.Ext(clean)), true
+}
+
+func (d *Dictionary) render(a article) string {
+	def := dict.RewriteResourceLinks(a.Definition, d.id)
+	return `<div id="gdarticlefrom-` + dict.ScopeID(d.id) + `" class="bgl">` + def + `</div>`
+}
+
+func normalize(s string, caseFold bool) string {
+	if caseFold {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,107 @@
+package bgl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bglBlock encodes a block with the short (length in the high nibble) or
+// the long (width in the high nibble) header.
+func bglBlock(typ int, data []byte) []byte {
+	if len(data) < 12 {
+		return append([]byte{byte((len(data)+4)<<4 | typ)}, data...)
+	}
+	out := []byte{byte(1<<4 | typ)}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)))
+	return append(out, data...)
+}
+
+func writeBGL(t *testing.T, path string) {
+	t.Helper()
+	var blocks []byte
+	add := func(typ int, parts ...[]byte) {
+		blocks = append(blocks, bglBlock(typ, bytes.Join(parts, nil))...)
+	}
+	b := func(v ...byte) []byte { return v }
+	str := func(s string) []byte { return []byte(s) }
+
+	add(blockInfo, b(8, 0, 0x44))                             // default charset: windows-1251
+	add(blockProperty, b(0, propTitle), str("Test Glossary")) // title
+	add(blockProperty, b(0, propSourceCharset, 0x42))         // source: windows-1252
+	add(blockResource, b(7), str("pic.png"), str("PNGDATA"))
+	add(blockResource, b(12), str("8eaf66fd.bmp"), str("x"))
+
+	def := append([]byte("a fruit\n"), 0xef, 0xf0, 0xe8, 0x14, 0x02, 0x30)
+	entry := append(b(8), str("apple$1$")...)
+	entry = binary.BigEndian.AppendUint16(entry, uint16(len(def)))
+	entry = append(entry, def...)
+	entry = append(entry, 6)
+	entry = append(entry, str("apples")...)
+	add(blockEntry, entry)
+
+	long := b(0)
+	long = binary.BigEndian.AppendUint32(long, 6)
+	long = append(long, str("banana")...)
+	long = binary.BigEndian.AppendUint32(long, 1)
+	long = binary.BigEndian.AppendUint32(long, 7)
+	long = append(long, str("bananas")...)
+	long = binary.BigEndian.AppendUint32(long, 15)
+	long = append(long, str(`<img src="pic.png">`)[:15]...)
+	add(blockEntryLong, long)
+	blocks = append(blocks, blockEnd)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0x12, 0x34, 0x00, 0x01, 0x00, 0x06})
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(blocks)
+	_ = zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.bgl")
+	writeBGL(t, path)
+
+	// The second load reads the index cache.
+	for _, pass := range []string{"build", "cached"} {
+		d, err := Load("t", "", path, true)
+		if err != nil {
+			t.Fatalf("%s: Load: %v", pass, err)
+		}
+		if d.Name() != "Test Glossary" || d.Metadata()["source_charset"] != "windows-1252" {
+			t.Fatalf("%s: name %q, metadata %v", pass, d.Name(), d.Metadata())
+		}
+		got := d.Lookup("Apple")
+		if len(got) != 1 || got[0].Word != "apple" || got[0].Synonym != "" {
+			t.Fatalf("%s: Lookup(Apple) = %+v", pass, got)
+		}
+		for _, want := range []string{`<span class="bgl_pos">n.</span>`, "a fruit<br>при"} {
+			if !strings.Contains(got[0].Definition, want) {
+				t.Fatalf("%s: definition %q lacks %q", pass, got[0].Definition, want)
+			}
+		}
+		if got := d.Lookup("bananas"); len(got) != 1 || got[0].Word != "banana" || got[0].Synonym != "bananas" {
+			t.Fatalf("%s: Lookup(bananas) = %+v", pass, got)
+		}
+		var words []string
+		for _, e := range d.Prefix("a", 10) {
+			words = append(words, e.Word)
+		}
+		if strings.Join(words, ",") != "apple,apples" {
+			t.Fatalf("%s: Prefix(a) = %v", pass, words)
+		}
+		if data, typ, ok := d.Resource("img/pic.png"); !ok || string(data) != "PNGDATA" || typ != "image/png" {
+			t.Fatalf("%s: Resource(pic.png) = %q, %q, %v", pass, data, typ, ok)
+		}
+		if _, _, ok := d.Resource("8eaf66fd.bmp"); ok {
+			t.Fatalf("%s: internal resource served", pass)
+		}
+	}
+}
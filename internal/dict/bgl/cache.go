@@ -0,0 +1,110 @@
+package bgl
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const cacheVersion = 1
+
+// cacheIndex holds the fully parsed glossary. BGL data is a single gzip
+// stream without random access, so articles and resources are cached
+// rather than offsets.
+type cacheIndex struct {
+	Version     int
+	CaseFold    bool
+	SourcePath  string
+	SourceSize  int64
+	SourceMtime int64
+	Info        map[string]string
+	Articles    []article
+	Resources   map[string][]byte
+	Keys        []key
+	NormToKeys  map[string][]int
+	SortedNorm  []string
+	SortedWord  []string
+}
+
+type article struct {
+	Headword   string
+	Alternates []string
+	// Definition is HTML with resource links still relative.
+	Definition string
+}
+
+// key is an indexed form of an article: its headword or an alternate.
+type key struct {
+	Word      string
+	Article   int
+	Alternate bool
+}
+
+func cachePath(path string) string {
+	return path + ".gdapi.bgl.idx"
+}
+
+func loadCache(path string, caseFold bool) (*cacheIndex, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := os.Open(cachePath(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var idx cacheIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, false, err
+	}
+	if idx.Version != cacheVersion || idx.CaseFold != caseFold {
+		return nil, false, nil
+	}
+	if filepath.Clean(idx.SourcePath) != filepath.Clean(path) || idx.SourceSize != info.Size() || idx.SourceMtime != info.ModTime().UnixNano() {
+		return nil, false, nil
+	}
+	return &idx, true, nil
+}
+
+func saveCache(path string, idx *cacheIndex) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	idx.Version = cacheVersion
+	idx.SourcePath = filepath.Clean(path)
+	idx.SourceSize = info.Size()
+	idx.SourceMtime = info.ModTime().UnixNano()
+
+	idxPath := cachePath(path)
+	tmp, err := os.CreateTemp(filepath.Dir(idxPath), filepath.Base(idxPath)+".tmp.*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, idxPath); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
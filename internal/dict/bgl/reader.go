@@ -0,0 +1,406 @@
+package bgl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Block types of the decompressed BGL stream.
+const (
+	blockInfo      = 0
+	blockEntry     = 1
+	blockResource  = 2
+	blockProperty  = 3
+	blockEnd       = 4
+	blockEntry7    = 7
+	blockEntry10   = 10
+	blockEntryLong = 11
+)
+
+// Header property ids (second byte of a type 3 block).
+const (
+	propTitle          = 0x01
+	propAuthor         = 0x02
+	propEmail          = 0x03
+	propCopyright      = 0x04
+	propDescription    = 0x09
+	propFlags          = 0x11
+	propSourceCharset  = 0x1a
+	propTargetCharset  = 0x1b
+	flagUTF8           = 0x8000
+	firstCharsetCode   = 0x41
+	defaultCharsetName = "windows-1252"
+)
+
+// charsets lists the code pages selected by charset codes 0x41, 0x42, ...
+var charsets = []string{
+	"windows-1252", // default
+	"windows-1252", // Latin
+	"windows-1250", // Eastern European
+	"windows-1251", // Cyrillic
+	"shift_jis",    // Japanese
+	"big5",         // Traditional Chinese
+	"gbk",          // Simplified Chinese
+	"windows-1257", // Baltic
+	"windows-1253", // Greek
+	"euc-kr",       // Korean
+	"windows-1254", // Turkish
+	"windows-1255", // Hebrew
+	"windows-1256", // Arabic
+	"windows-874",  // Thai
+}
+
+var partsOfSpeech = []string{"n.", "adj.", "v.", "adv.", "interj.", "pron.", "prep.", "conj.", "suff.", "pref.", "art."}
+
+// Resources Babylon uses internally that are never referenced by articles.
+var internalResources = map[string]bool{"8eaf66fd.bmp": true, "c2eef3f6.html": true}
+
+var indexSuffixRe = regexp.MustCompile(`\$\d+\$$`)
+
+// rawEntry is an article as stored in the file, before charset decoding.
+type rawEntry struct {
+	headword   []byte
+	definition []byte
+	alternates [][]byte
+}
+
+// source is the parsed content of a BGL file.
+type source struct {
+	info      map[string]string
+	articles  []article
+	resources map[string][]byte
+}
+
+// readSource parses a BGL file: a short signature, the offset of a gzip
+// stream, and inside it a sequence of typed blocks.
+func readSource(path string) (*source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sig [6]byte
+	if _, err := io.ReadFull(f, sig[:]); err != nil {
+		return nil, err
+	}
+	if sig[0] != 0x12 || sig[1] != 0x34 || sig[2] != 0x00 || (sig[3] != 0x01 && sig[3] != 0x02) {
+		return nil, errors.New("bgl: not a Babylon glossary")
+	}
+	gzOffset := int64(binary.BigEndian.Uint16(sig[4:]))
+	if gzOffset < int64(len(sig)) {
+		return nil, errors.New("bgl: invalid gzip offset")
+	}
+	if _, err := f.Seek(gzOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("bgl: %w", err)
+	}
+	defer zr.Close()
+
+	var (
+		br        = bufio.NewReader(zr)
+		raw       []rawEntry
+		infoRaw   = make(map[int][]byte)
+		defaultCS = defaultCharsetName
+		sourceCS  string
+		targetCS  string
+		utf8Flag  bool
+		resources = make(map[string][]byte)
+	)
+	for {
+		typ, data, err := readBlock(br)
+		if err != nil {
+			// Many glossaries end with a truncated gzip member; keep what
+			// was read so far.
+			if !errors.Is(err, io.EOF) {
+				log.Printf("bgl: %s: stopped reading: %v", path, err)
+			}
+			break
+		}
+		if typ == blockEnd {
+			break
+		}
+		switch typ {
+		case blockInfo:
+			if len(data) > 2 && data[0] == 8 {
+				defaultCS = charsetName(data[2], defaultCS)
+			}
+		case blockProperty:
+			if len(data) < 2 {
+				continue
+			}
+			value := data[2:]
+			switch int(data[1]) {
+			case propSourceCharset:
+				if len(value) > 0 {
+					sourceCS = charsetName(value[0], "")
+				}
+			case propTargetCharset:
+				if len(value) > 0 {
+					targetCS = charsetName(value[0], "")
+				}
+			case propFlags:
+				var flags uint64
+				for _, b := range value {
+					flags = flags<<8 | uint64(b)
+				}
+				utf8Flag = flags&flagUTF8 != 0
+			default:
+				infoRaw[int(data[1])] = value
+			}
+		case blockResource:
+			if len(data) < 1 || len(data) < 1+int(data[0]) {
+				continue
+			}
+			lower := strings.ToLower(string(data[1 : 1+data[0]]))
+			if internalResources[lower] {
+				continue
+			}
+			if _, ok := resources[lower]; !ok {
+				resources[lower] = data[1+data[0]:]
+			}
+		case blockEntry, blockEntry7, blockEntry10, blockEntryLong:
+			e, ok := parseEntry(typ, data)
+			if ok {
+				raw = append(raw, e)
+			}
+		}
+	}
+
+	if sourceCS == "" {
+		sourceCS = defaultCS
+	}
+	if targetCS == "" {
+		targetCS = defaultCS
+	}
+	srcDec := newDecoder(sourceCS, utf8Flag)
+	dstDec := newDecoder(targetCS, utf8Flag)
+
+	src := &source{
+		info:      make(map[string]string),
+		articles:  make([]article, 0, len(raw)),
+		resources: resources,
+	}
+	for id, key := range map[int]string{
+		propTitle:       "title",
+		propAuthor:      "author",
+		propEmail:       "email",
+		propCopyright:   "copyright",
+		propDescription: "description",
+	} {
+		if v := strings.TrimSpace(strings.Trim(dstDec(infoRaw[id]), "\x00")); v != "" {
+			src.info[key] = v
+		}
+	}
+	src.info["source_charset"] = sourceCS
+	src.info["target_charset"] = targetCS
+	if utf8Flag {
+		src.info["source_charset"] = "utf-8"
+		src.info["target_charset"] = "utf-8"
+	}
+
+	for _, e := range raw {
+		headword := cleanHeadword(srcDec(e.headword))
+		if headword == "" {
+			continue
+		}
+		a := article{
+			Headword:   headword,
+			Definition: definitionToHTML(e.definition, dstDec),
+		}
+		for _, alt := range e.alternates {
+			if s := cleanHeadword(srcDec(alt)); s != "" && s != headword {
+				a.Alternates = append(a.Alternates, s)
+			}
+		}
+		src.articles = append(src.articles, a)
+	}
+	return src, nil
+}
+
+// readBlock reads one block header and payload. The low nibble of the first
+// byte is the block type; the high nibble is either the payload length plus
+// four or the width of a following big-endian length.
+func readBlock(r *bufio.Reader) (int, []byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := int(first & 0x0f)
+	if typ == blockEnd {
+		return typ, nil, nil
+	}
+	n := int(first >> 4)
+	if n < 4 {
+		buf := make([]byte, n+1)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, nil, err
+		}
+		n = 0
+		for _, b := range buf {
+			n = n<<8 | int(b)
+		}
+	} else {
+		n -= 4
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+// parseEntry splits an article block into headword, definition and
+// alternate forms. Type 11 blocks use 4 byte lengths and an explicit
+// alternate count; the others use 1 byte lengths (2 for the definition).
+func parseEntry(typ int, data []byte) (rawEntry, bool) {
+	var e rawEntry
+	p := 0
+	take := func(width int) (int, bool) {
+		if p+width > len(data) {
+			return 0, false
+		}
+		n := 0
+		for _, b := range data[p : p+width] {
+			n = n<<8 | int(b)
+		}
+		p += width
+		return n, true
+	}
+	field := func(n int) ([]byte, bool) {
+		if n < 0 || p+n > len(data) {
+			return nil, false
+		}
+		b := data[p : p+n]
+		p += n
+		return b, true
+	}
+
+	var ok bool
+	if typ == blockEntryLong {
+		p = 1
+		n, ok1 := take(4)
+		if e.headword, ok = field(n); !ok1 || !ok {
+			return e, false
+		}
+		count, ok := take(4)
+		if !ok {
+			return e, false
+		}
+		for i := 0; i < count; i++ {
+			n, ok1 := take(4)
+			alt, ok2 := field(n)
+			if !ok1 || !ok2 {
+				return e, false
+			}
+			e.alternates = append(e.alternates, alt)
+		}
+		n, ok1 = take(4)
+		if e.definition, ok = field(n); !ok1 || !ok {
+			return e, false
+		}
+		return e, true
+	}
+
+	n, ok1 := take(1)
+	if e.headword, ok = field(n); !ok1 || !ok {
+		return e, false
+	}
+	n, ok1 = take(2)
+	if e.definition, ok = field(n); !ok1 || !ok {
+		return e, false
+	}
+	for p < len(data) {
+		n, ok1 := take(1)
+		alt, ok2 := field(n)
+		if !ok1 || !ok2 {
+			break
+		}
+		e.alternates = append(e.alternates, alt)
+	}
+	return e, true
+}
+
+// definitionToHTML decodes a definition: newlines become <br>, a 0x14 0x02
+// sequence names the part of speech, and any other 0x14 starts trailing
+// fields (transcription, display title, ...) that are not rendered.
+func definitionToHTML(b []byte, decode func([]byte) string) string {
+	var (
+		body []byte
+		pos  string
+	)
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == 0x0a:
+			body = append(body, "<br>"...)
+		case c == 0x14 && i+2 < len(b) && b[i+1] == 0x02:
+			if idx := int(b[i+2]) - 0x30; idx >= 0 && idx < len(partsOfSpeech) {
+				pos = partsOfSpeech[idx]
+			}
+			i += 2
+		case c == 0x14:
+			i = len(b)
+		case c < 0x20:
+			// Other control codes carry formatting hints we ignore.
+		default:
+			body = append(body, c)
+		}
+	}
+	def := strings.TrimSpace(decode(body))
+	if pos != "" {
+		def = `<span class="bgl_pos">` + pos + `</span> ` + def
+	}
+	return def
+}
+
+// cleanHeadword drops the "$12$" disambiguation suffix Babylon appends to
+// duplicate headwords.
+func cleanHeadword(s string) string {
+	s = strings.TrimSpace(strings.Trim(s, "\x00"))
+	return strings.TrimSpace(indexSuffixRe.ReplaceAllString(s, ""))
+}
+
+func charsetName(code byte, fallback string) string {
+	if i := int(code) - firstCharsetCode; i >= 0 && i < len(charsets) {
+		return charsets[i]
+	}
+	return fallback
+}
+
+// newDecoder returns a text decoder for a charset. Text that is already
+// valid UTF-8 is kept as is, since many converted glossaries are UTF-8
+// without setting the header flag.
+func newDecoder(name string, utf8Flag bool) func([]byte) string {
+	var enc encoding.Encoding
+	if !utf8Flag {
+		if e, err := htmlindex.Get(name); err == nil {
+			enc = e
+		}
+	}
+	return func(b []byte) string {
+		if enc == nil || utf8.Valid(b) {
+			return string(b)
+		}
+		out, err := enc.NewDecoder().Bytes(b)
+		if err != nil {
+			return string(b)
+		}
+		return string(out)
+	}
+}
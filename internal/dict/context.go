@@ -0,0 +1,125 @@
+package dict
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ContextDictionary is a Dictionary whose queries can be cancelled and can
+// fail. WithContext adapts any Dictionary to it.
+type ContextDictionary interface {
+	Dictionary
+	LookupContext(ctx context.Context, word string) ([]Entry, error)
+	PrefixContext(ctx context.Context, prefix string, limit int) ([]Entry, error)
+	SearchContext(ctx context.Context, query string, limit int) ([]Entry, error)
+}
+
+// ContextSearcher is implemented by backends whose Search scans every
+// headword and can stop early when ctx is done.
+type ContextSearcher interface {
+	SearchContext(ctx context.Context, query string, limit int) ([]Entry, error)
+}
+
+// WithContext returns d if it is a ContextDictionary. Otherwise the
+// adapter uses d's SearchContext when it has one, and runs the other calls
+// in their own goroutine, returning ctx.Err() as soon as ctx is done; the
+// abandoned call finishes in the background, tracked by TrackDetached.
+// Panics become errors.
+func WithContext(d Dictionary) ContextDictionary {
+	if cd, ok := d.(ContextDictionary); ok {
+		return cd
+	}
+	return contextAdapter{d}
+}
+
+type contextAdapter struct {
+	Dictionary
+}
+
+func (a contextAdapter) LookupContext(ctx context.Context, word string) ([]Entry, error) {
+	return detach(ctx, func() []Entry { return a.Lookup(word) })
+}
+
+func (a contextAdapter) PrefixContext(ctx context.Context, prefix string, limit int) ([]Entry, error) {
+	return detach(ctx, func() []Entry { return a.Prefix(prefix, limit) })
+}
+
+func (a contextAdapter) SearchContext(ctx context.Context, query string, limit int) (entries []Entry, err error) {
+	cs, ok := a.Dictionary.(ContextSearcher)
+	if !ok {
+		return detach(ctx, func() []Entry { return a.Search(query, limit) })
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			entries, err = nil, fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return cs.SearchContext(ctx, query, limit)
+}
+
+type detachedKey struct{}
+
+// TrackDetached returns a context under which the goroutines of the
+// WithContext adapter are counted in wg until their call returns, also
+// after they were abandoned, so callers can keep bounding how many run.
+func TrackDetached(ctx context.Context, wg *sync.WaitGroup) context.Context {
+	return context.WithValue(ctx, detachedKey{}, wg)
+}
+
+// detach runs fn in a goroutine and waits for it or for ctx.
+func detach(ctx context.Context, fn func() []Entry) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		entries []Entry
+		err     error
+	}
+	ch := make(chan result, 1)
+	wg, _ := ctx.Value(detachedKey{}).(*sync.WaitGroup)
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				ch <- result{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		ch <- result{entries: fn()}
+	}()
+	select {
+	case r := <-ch:
+		return r.entries, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SearchSorted returns up to limit words of sortedW whose normalized form
+// in sortedN contains q. It checks ctx every few thousand headwords.
+func SearchSorted(ctx context.Context, sortedN, sortedW []string, q string, limit int) ([]Entry, error) {
+	out := make([]Entry, 0, limit)
+	for i, n := range sortedN {
+		if i%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return out, err
+			}
+		}
+		if strings.Contains(n, q) {
+			out = append(out, Entry{Word: sortedW[i]})
+			if len(out) >= limit {
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -3,6 +3,9 @@ package dict
 type Entry struct {
 	Word       string `json:"word"`
 	Definition string `json:"definition"`
+	// Synonym is the alternative form that matched the query when the entry
+	// was found through a synonym rather than its headword.
+	Synonym string `json:"synonym,omitempty"`
 }
 
 type Dictionary interface {
@@ -18,3 +21,9 @@ type Dictionary interface {
 type ResourceProvider interface {
 	Resource(name string) (data []byte, contentType string, ok bool)
 }
+
+// MetadataProvider exposes descriptive information read from the dictionary
+// file itself (title, description, languages, ...). Keys are lower-case.
+type MetadataProvider interface {
+	Metadata() map[string]string
+}
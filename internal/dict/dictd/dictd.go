@@ -0,0 +1,333 @@
+package dictd
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/dict/dictzip"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// refRe matches the {headword} cross references used by GCIDE, WordNet and
+// FreeDict databases.
+var refRe = regexp.MustCompile(`\{([^{}\n]+)\}`)
+
+type Dictionary struct {
+	id        string
+	name      string
+	caseFold  bool
+	data      *dictzip.Reader
+	info      map[string]string
+	utf8      bool
+	articles  []article
+	keys      []key
+	normIndex map[string][]int
+	sortedN   []string
+	sortedW   []string
+}
+
+// Load opens a dictd database. path may name the .index file or the .dict
+// (.dict.dz) file; the other one is found next to it.
+func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
+	indexPath, dataPath, err := resolveFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dictzip.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dictionary{
+		id:       id,
+		caseFold: caseFold,
+		data:     data,
+	}
+
+	if cached, ok, err := loadCache(indexPath, caseFold); err == nil && ok {
+		d.info = cached.Info
+		d.utf8 = cached.UTF8
+		d.articles = cached.Articles
+		d.keys = cached.Keys
+		d.normIndex = cached.NormToKeys
+		d.sortedN = cached.SortedNorm
+		d.sortedW = cached.SortedWord
+		d.name = dictName(name, d.info, id)
+		return d, nil
+	}
+
+	entries, err := readIndex(indexPath)
+	if err != nil {
+		_ = data.Close()
+		return nil, err
+	}
+
+	info := make(map[string]string)
+	isUTF8 := false
+	articles := make([]article, 0, len(entries))
+	articleIdx := make(map[article]int, len(entries))
+	keys := make([]key, 0, len(entries))
+	normIndex := make(map[string][]int)
+	type item struct {
+		norm string
+		word string
+	}
+	items := make([]item, 0, len(entries))
+	for _, e := range entries {
+		a := article{Offset: e.offset, Size: e.size}
+		if meta, ok := metaName(e.word); ok {
+			body, err := data.Bytes(a.Offset, a.Size)
+			if err != nil {
+				log.Printf("dictd: failed to read %s in %q: %v", e.word, id, err)
+				continue
+			}
+			if meta == "utf8" {
+				isUTF8 = true
+			}
+			if v := metaValue(e.word, string(body)); v != "" {
+				info[meta] = v
+			}
+			continue
+		}
+		ai, ok := articleIdx[a]
+		if !ok {
+			ai = len(articles)
+			articles = append(articles, a)
+			articleIdx[a] = ai
+		}
+		word := e.word
+		if e.orig != "" {
+			word = e.orig
+		}
+		ki := len(keys)
+		keys = append(keys, key{Word: word, Article: ai})
+		norm := normalize(word, caseFold)
+		normIndex[norm] = append(normIndex[norm], ki)
+		items = append(items, item{norm: norm, word: word})
+		// The search form dictfmt wrote may differ from the original
+		// spelling (punctuation removed); index it as well.
+		if alt := normalize(e.word, caseFold); alt != norm {
+			normIndex[alt] = append(normIndex[alt], ki)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].norm == items[j].norm {
+			return items[i].word < items[j].word
+		}
+		return items[i].norm < items[j].norm
+	})
+	sortedN := make([]string, 0, len(items))
+	sortedW := make([]string, 0, len(items))
+	for _, it := range items {
+		sortedN = append(sortedN, it.norm)
+		sortedW = append(sortedW, it.word)
+	}
+
+	_ = saveCache(indexPath, &cacheIndex{
+		CaseFold:   caseFold,
+		Info:       info,
+		UTF8:       isUTF8,
+		Articles:   articles,
+		Keys:       keys,
+		NormToKeys: normIndex,
+		SortedNorm: sortedN,
+		SortedWord: sortedW,
+	})
+
+	d.info = info
+	d.utf8 = isUTF8
+	d.articles = articles
+	d.keys = keys
+	d.normIndex = normIndex
+	d.sortedN = sortedN
+	d.sortedW = sortedW
+	d.name = dictName(name, info, id)
+	return d, nil
+}
+
+// SourceFiles returns the .index and data file of the database at path, or
+// just path if they cannot be resolved.
+func SourceFiles(path string) []string {
+	indexPath, dataPath, err := resolveFiles(path)
+	if err != nil {
+		return []string{path}
+	}
+	return []string{indexPath, dataPath}
+}
+
+// resolveFiles returns the .index and data file of a database.
+func resolveFiles(path string) (string, string, error) {
+	lower := strings.ToLower(path)
+	var base string
+	switch {
+	case strings.HasSuffix(lower, ".index"):
+		base = path[:len(path)-len(".index")]
+	case strings.HasSuffix(lower, ".dict.dz"):
+		return path[:len(path)-len(".dict.dz")] + ".index", path, nil
+	case strings.HasSuffix(lower, ".dict"):
+		return path[:len(path)-len(".dict")] + ".index", path, nil
+	default:
+		return "", "", fmt.Errorf("dictd: %s: expected a .index, .dict or .dict.dz file", path)
+	}
+	for _, ext := range []string{".dict.dz", ".dict"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return path, base + ext, nil
+		}
+	}
+	return "", "", fmt.Errorf("dictd: no .dict or .dict.dz file for %s", path)
+}
+
+// dictName prefers the configured name, then 00-database-short, then the id.
+func dictName(name string, info map[string]string, id string) string {
+	if name != "" {
+		return name
+	}
+	if s := info["short"]; s != "" {
+		return s
+	}
+	return id
+}
+
+func (d *Dictionary) ID() string {
+	return d.id
+}
+
+func (d *Dictionary) Name() string {
+	return d.name
+}
+
+// Metadata returns the 00-database-* entries keyed by their suffix (short,
+// info, url, ...).
+func (d *Dictionary) Metadata() map[string]string {
+	out := make(map[string]string, len(d.info))
+	for k, v := range d.info {
+		out[k] = v
+	}
+	return out
+}
+
+// Close releases the .dict (.dict.dz) file.
+func (d *Dictionary) Close() error {
+	return d.data.Close()
+}
+
+func (d *Dictionary) Lookup(word string) []dict.Entry {
+	idxs := d.normIndex[normalize(word, d.caseFold)]
+	if len(idxs) == 0 {
+		return nil
+	}
+	out := make([]dict.Entry, 0, len(idxs))
+	seen := make(map[int]bool, len(idxs))
+	for _, i := range idxs {
+		k := d.keys[i]
+		if seen[k.Article] {
+			continue
+		}
+		seen[k.Article] = true
+		def, ok := d.readArticle(d.articles[k.Article])
+		if !ok {
+			continue
+		}
+		out = append(out, dict.Entry{Word: k.Word, Definition: def})
+	}
+	return out
+}
+
+func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
+	if limit <= 0 {
+		limit = 20
+	}
+	pfx := normalize(prefix, d.caseFold)
+	idx := sort.Search(len(d.sortedN), func(i int) bool {
+		return d.sortedN[i] >= pfx
+	})
+	if idx == len(d.sortedN) {
+		return nil
+	}
+	out := make([]dict.Entry, 0, limit)
+	for i := idx; i < len(d.sortedN) && len(out) < limit; i++ {
+		if !strings.HasPrefix(d.sortedN[i], pfx) {
+			break
+		}
+		out = append(out, dict.Entry{Word: d.sortedW[i]})
+	}
+	return out
+}
+
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
+	return out
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
+}
+
+func (d *Dictionary) readArticle(a article) (string, bool) {
+	raw, err := d.data.Bytes(a.Offset, a.Size)
+	if err != nil {
+		log.Printf("dictd: failed to read article in %q: %v", d.id, err)
+		return "", false
+	}
+	text := string(raw)
+	if !d.utf8 && !utf8.Valid(raw) {
+		// Databases without 00-database-utf8 are Latin-1.
+		if b, err := charmap.ISO8859_1.NewDecoder().Bytes(raw); err == nil {
+			text = string(b)
+		}
+	}
+	return `<div id="gdarticlefrom-` + dict.ScopeID(d.id) + `" class="dictd">` + d.toHTML(text) + `</div>`, true
+}
+
+// toHTML escapes the plain text article, keeps its line layout and turns
+// {headword} references into entry links.
+func (d *Dictionary) toHTML(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimRight(text, "\n")
+	var b strings.Builder
+	b.Grow(len(text) + len(text)/4)
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteString("<br>")
+		}
+		trimmed := strings.TrimLeft(line, " ")
+		b.WriteString(strings.Repeat("&nbsp;", len(line)-len(trimmed)))
+		last := 0
+		for _, m := range refRe.FindAllStringSubmatchIndex(trimmed, -1) {
+			b.WriteString(html.EscapeString(trimmed[last:m[0]]))
+			target := strings.Join(strings.Fields(trimmed[m[2]:m[3]]), " ")
+			b.WriteString(`<a href="` + html.EscapeString(dict.EntryURL(d.id, target)) + `">` + html.EscapeString(trimmed[m[2]:m[3]]) + `</a>`)
+			last = m[1]
+		}
+		b.WriteString(html.EscapeString(trimmed[last:]))
+	}
+	return b.String()
+}
+
+func normalize(s string, caseFold bool) string {
+	if caseFold {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	return strings.TrimSpace(s)
+}
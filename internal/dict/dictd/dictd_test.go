@@ -0,0 +1,119 @@
+package dictd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encodeB64(n int) string {
+	if n == 0 {
+		return "A"
+	}
+	var out []byte
+	for ; n > 0; n >>= 6 {
+		out = append([]byte{b64Alphabet[n&63]}, out...)
+	}
+	return string(out)
+}
+
+func TestDecodeB64(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"A", 0, true},
+		{"B", 1, true},
+		{"BA", 64, true},
+		{"Gx", 6*64 + 49, true},
+		{"//", 4095, true},
+		{"", 0, false},
+		{"a-b", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := decodeB64(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("decodeB64(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	if name, ok := metaName("00-database-short"); !ok || name != "short" {
+		t.Fatalf("metaName(00-database-short) = %q, %v", name, ok)
+	}
+	if name, ok := metaName("00databaseinfo"); !ok || name != "info" {
+		t.Fatalf("metaName(00databaseinfo) = %q, %v", name, ok)
+	}
+	if _, ok := metaName("00-database-"); ok {
+		t.Fatal("metaName(00-database-) matched")
+	}
+	if got := metaValue("00-database-short", "00-database-short\n     Test Dictionary\n"); got != "Test Dictionary" {
+		t.Fatalf("metaValue = %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	articles := []struct{ word, orig, body string }{
+		{"00-database-short", "", "00-database-short\n     Test Dictionary\n"},
+		{"00-database-url", "", "00-database-url\n     https://example.com/\n"},
+		{"apple", "", "apple\n  a fruit, see {banana}\n"},
+		{"banana", "", "banana\n  a long \xe9 fruit\n"},
+		{"nato", "N.A.T.O.", "NATO\n  an alliance\n"},
+	}
+	var data, index strings.Builder
+	for _, a := range articles {
+		line := a.word + "\t" + encodeB64(data.Len()) + "\t" + encodeB64(len(a.body))
+		if a.orig != "" {
+			line += "\t" + a.orig
+		}
+		index.WriteString(line + "\n")
+		data.WriteString(a.body)
+	}
+	indexPath := filepath.Join(dir, "test.index")
+	if err := os.WriteFile(indexPath, []byte(index.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test.dict"), []byte(data.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading through the .dict file finds the index; the second load
+	// reads the index cache.
+	for _, path := range []string{filepath.Join(dir, "test.dict"), indexPath} {
+		d, err := Load("t", "", path, true)
+		if err != nil {
+			t.Fatalf("Load(%s): %v", path, err)
+		}
+		if d.Name() != "Test Dictionary" || d.Metadata()["url"] != "https://example.com/" {
+			t.Fatalf("name %q, metadata %v", d.Name(), d.Metadata())
+		}
+		if got := d.Lookup("00-database-short"); len(got) != 0 {
+			t.Fatalf("metadata entry indexed as a word: %+v", got)
+		}
+		got := d.Lookup("Apple")
+		if len(got) != 1 || !strings.Contains(got[0].Definition, `&nbsp;&nbsp;a fruit, see <a href="/entry?dict=t&amp;q=banana">banana</a>`) {
+			t.Fatalf("Lookup(Apple) = %+v", got)
+		}
+		if got := d.Lookup("banana"); len(got) != 1 || !strings.Contains(got[0].Definition, "a long é fruit") {
+			t.Fatalf("Lookup(banana) = %+v", got)
+		}
+		for _, q := range []string{"nato", "N.A.T.O."} {
+			if got := d.Lookup(q); len(got) != 1 || got[0].Word != "N.A.T.O." || !strings.Contains(got[0].Definition, "alliance") {
+				t.Fatalf("Lookup(%s) = %+v", q, got)
+			}
+		}
+		var words []string
+		for _, e := range d.Prefix("", 10) {
+			words = append(words, e.Word)
+		}
+		if strings.Join(words, ",") != "apple,banana,N.A.T.O." {
+			t.Fatalf("Prefix() = %v", words)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package dictd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const b64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// indexEntry is one line of a .index file: the search headword, the article
+// location and, for dictfmt --headword-separator/--index-keep-orig output,
+// the original spelling of the headword.
+type indexEntry struct {
+	word   string
+	orig   string
+	offset int64
+	size   int
+}
+
+// readIndex parses a dictd .index file. Offsets and sizes are numbers in
+// dictd's base64 alphabet, most significant digit first.
+func readIndex(path string) ([]indexEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []indexEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimRight(sc.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("dictd: %s:%d: expected headword, offset and size", path, line)
+		}
+		offset, ok1 := decodeB64(fields[1])
+		size, ok2 := decodeB64(fields[2])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("dictd: %s:%d: invalid offset or size", path, line)
+		}
+		e := indexEntry{word: fields[0], offset: offset, size: int(size)}
+		if len(fields) > 3 {
+			e.orig = fields[3]
+		}
+		out = append(out, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func decodeB64(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	var n int64
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(b64Alphabet, s[i])
+		if v < 0 {
+			return 0, false
+		}
+		n = n<<6 | int64(v)
+	}
+	return n, true
+}
+
+// metaName returns the metadata key of a 00-database-* pseudo entry
+// ("00-database-short" -> "short"); old files spell it "00databaseshort".
+func metaName(word string) (string, bool) {
+	for _, p := range []string{"00-database-", "00database"} {
+		if strings.HasPrefix(word, p) && len(word) > len(p) {
+			return word[len(p):], true
+		}
+	}
+	return "", false
+}
+
+// metaValue strips the headword line dictfmt puts in front of the body of
+// a pseudo entry.
+func metaValue(word, body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if first, rest, ok := strings.Cut(body, "\n"); ok && strings.TrimSpace(first) == word {
+		body = rest
+	} else if strings.TrimSpace(body) == word {
+		body = ""
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return strings.Join(lines, "\n")
+}
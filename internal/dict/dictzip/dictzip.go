@@ -0,0 +1,105 @@
+// Package dictzip gives random access to dictionary data files that may be
+// dictzip compressed (dictd .dict.dz, DSL .dsl.dz, StarDict res.rdic.dz).
+package dictzip
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	dz "github.com/ianlewis/go-dictzip"
+)
+
+// Reader reads ranges of the uncompressed data. Plain files are read
+// directly, .dz files through their dictzip chunk table; gzip files without
+// one are inflated into memory.
+type Reader struct {
+	mu   sync.Mutex
+	file *os.File
+	dz   *dz.Reader
+	mem  []byte
+}
+
+// IsCompressed reports whether path names a dictzip/gzip file.
+func IsCompressed(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".dz")
+}
+
+// Open opens path for random access.
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsCompressed(path) {
+		return &Reader{file: file}, nil
+	}
+	if zr, err := dz.NewReader(file); err == nil {
+		return &Reader{file: file, dz: zr}, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("dictzip: %s: %w", path, err)
+	}
+	mem, err := io.ReadAll(zr)
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("dictzip: %s: %w", path, err)
+	}
+	return &Reader{mem: mem}, nil
+}
+
+// Bytes returns size bytes of uncompressed data starting at off. A range
+// past the end of the data is io.ErrUnexpectedEOF.
+func (r *Reader) Bytes(off int64, size int) ([]byte, error) {
+	if off < 0 || size < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if r.mem != nil {
+		if off+int64(size) > int64(len(r.mem)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return r.mem[off : off+int64(size)], nil
+	}
+	buf := make([]byte, size)
+	var (
+		n   int
+		err error
+	)
+	if r.dz != nil {
+		// dictzip.Reader seeks the shared file handle.
+		r.mu.Lock()
+		n, err = r.dz.ReadAt(buf, off)
+		r.mu.Unlock()
+	} else {
+		n, err = r.file.ReadAt(buf, off)
+	}
+	if n == size {
+		return buf, nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// Close releases the file.
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	if r.dz != nil {
+		_ = r.dz.Close()
+	}
+	return r.file.Close()
+}
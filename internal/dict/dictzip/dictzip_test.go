@@ -0,0 +1,64 @@
+package dictzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dz "github.com/ianlewis/go-dictzip"
+)
+
+func TestReader(t *testing.T) {
+	text := strings.Repeat("0123456789", 10000)
+	var dzData, gzData bytes.Buffer
+	zw, err := dz.NewWriterLevel(&dzData, gzip.DefaultCompression, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(&gzData)
+	for _, w := range []io.WriteCloser{zw, gw} {
+		if _, err := io.WriteString(w, text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string][]byte{
+		"plain.dict":      []byte(text),
+		"chunked.dict.dz": dzData.Bytes(),
+		"gzip.dict.DZ":    gzData.Bytes(),
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Open(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name == "chunked.dict.dz" && r.dz == nil {
+			t.Fatalf("%s: chunk table not used", name)
+		}
+		// The range crosses a chunk boundary.
+		got, err := r.Bytes(4090, 12)
+		if err != nil || string(got) != text[4090:4102] {
+			t.Fatalf("%s: Bytes(4090, 12) = %q, %v", name, got, err)
+		}
+		for _, rng := range [][2]int64{{int64(len(text)) - 2, 4}, {int64(len(text)) + 10, 1}, {-1, 2}} {
+			if got, err := r.Bytes(rng[0], int(rng[1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("%s: Bytes(%d, %d) = %q, %v", name, rng[0], rng[1], got, err)
+			}
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
@@ -2,112 +2,309 @@ package dsl
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/sagerenn/mdict/internal/dict"
-	"github.com/sagerenn/mdict/internal/indexcache"
+	"github.com/sagerenn/mdict/internal/dict/dictzip"
+
+	"golang.org/x/text/encoding"
 )
 
+// maxLineLen bounds a single DSL line; long example blocks exceed bufio's default.
+const maxLineLen = 4 * 1024 * 1024
+
 type Dictionary struct {
-	id       string
-	name     string
-	caseFold bool
-	index    map[string][]string
-	words    []string
-	original map[string]string
+	id        string
+	name      string
+	caseFold  bool
+	header    map[string]string
+	abbrev    map[string]string
+	enc       encoding.Encoding
+	reader    *dictzip.Reader
+	resources *resourceStore
+	cards     []card
+	normIndex map[string][]int
+	sortedN   []string
+	sortedW   []string
+}
+
+// source is a DSL file split into its header and cards.
+type source struct {
+	enc       encoding.Encoding
+	header    map[string]string
+	cards     []card
+	headwords [][]headword
+	// bodies holds card bodies when requested by readSource.
+	bodies []string
 }
 
 func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
-	if id == "" || name == "" {
-		return nil, errors.New("id and name are required")
+	if id == "" {
+		return nil, errors.New("id is required")
 	}
-	if idx, ok, err := indexcache.Load(path, caseFold); err == nil && ok {
-		return &Dictionary{
-			id:       id,
-			name:     name,
-			caseFold: caseFold,
-			index:    idx.Entries,
-			words:    idx.Words,
-			original: idx.Original,
-		}, nil
+	reader, err := dictzip.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	abbrev := loadAbbreviations(path, caseFold)
+	resources := openResources(path)
 
-	file, err := os.Open(path)
+	if cached, ok, err := loadCache(path, caseFold); err == nil && ok {
+		enc, _, err := sniffEncoding(path)
+		if err == nil {
+			return &Dictionary{
+				id:        id,
+				name:      dictName(name, id, cached.Header),
+				caseFold:  caseFold,
+				header:    cached.Header,
+				abbrev:    abbrev,
+				enc:       enc,
+				reader:    reader,
+				resources: resources,
+				cards:     cached.Cards,
+				normIndex: cached.NormToCards,
+				sortedN:   cached.SortedNorm,
+				sortedW:   cached.SortedWord,
+			}, nil
+		}
+	}
+
+	src, err := readSource(path, false)
 	if err != nil {
+		_ = reader.Close()
+		_ = resources.Close()
 		return nil, err
 	}
-	defer file.Close()
 
-	idx := make(map[string][]string)
-	orig := make(map[string]string)
-	scanner := bufio.NewScanner(file)
+	normIndex := make(map[string][]int)
+	type item struct {
+		norm string
+		word string
+	}
+	var items []item
+	for cardIdx, hws := range src.headwords {
+		for _, h := range hws {
+			for _, v := range h.variants {
+				norm := normalize(v, caseFold)
+				if idxs := normIndex[norm]; len(idxs) > 0 && idxs[len(idxs)-1] == cardIdx {
+					continue
+				}
+				normIndex[norm] = append(normIndex[norm], cardIdx)
+				items = append(items, item{norm: norm, word: v})
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].norm == items[j].norm {
+			return items[i].word < items[j].word
+		}
+		return items[i].norm < items[j].norm
+	})
+	sortedN := make([]string, 0, len(items))
+	sortedW := make([]string, 0, len(items))
+	for i, it := range items {
+		if i > 0 && it.norm == items[i-1].norm && it.word == items[i-1].word {
+			continue
+		}
+		sortedN = append(sortedN, it.norm)
+		sortedW = append(sortedW, it.word)
+	}
+
+	_ = saveCache(path, &cacheIndex{
+		CaseFold:    caseFold,
+		Header:      src.header,
+		Cards:       src.cards,
+		NormToCards: normIndex,
+		SortedNorm:  sortedN,
+		SortedWord:  sortedW,
+	})
+
+	return &Dictionary{
+		id:        id,
+		name:      dictName(name, id, src.header),
+		caseFold:  caseFold,
+		header:    src.header,
+		abbrev:    abbrev,
+		enc:       src.enc,
+		reader:    reader,
+		resources: resources,
+		cards:     src.cards,
+		normIndex: normIndex,
+		sortedN:   sortedN,
+		sortedW:   sortedW,
+	}, nil
+}
 
-	var currentWord string
-	var defLines []string
+// readSource decodes a DSL file and splits it into header and cards, recording
+// the raw byte range of every card body.
+func readSource(path string, withBodies bool) (*source, error) {
+	stream, err := openStream(path)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	br := bufio.NewReader(stream)
+	head, _ := br.Peek(sniffLen)
+	enc, bom := detectEncoding(head)
+	if _, err := br.Discard(bom); err != nil {
+		return nil, err
+	}
+
+	src := &source{enc: enc, header: make(map[string]string)}
+	pos := int64(bom)
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLen)
+	scanner.Split(lineSplitter(enc, &pos))
+	dec := enc.NewDecoder()
+
+	var headwords []headword
+	var bodyLines []string
+	var bodyStart, bodyEnd int64
 	flush := func() {
-		if currentWord == "" {
-			defLines = nil
+		defer func() {
+			headwords = nil
+			bodyLines = nil
+		}()
+		if len(headwords) == 0 {
 			return
 		}
-		def := strings.TrimSpace(strings.Join(defLines, "\n"))
-		if def == "" {
-			defLines = nil
+		body := strings.TrimSpace(strings.Join(bodyLines, "\n"))
+		if body == "" {
 			return
 		}
-		key := normalize(currentWord, caseFold)
-		idx[key] = append(idx[key], def)
-		if _, ok := orig[key]; !ok {
-			orig[key] = currentWord
+		src.cards = append(src.cards, card{
+			Headword: headwords[0].display,
+			Full:     headwords[0].full,
+			Offset:   bodyStart,
+			Size:     int(bodyEnd - bodyStart),
+		})
+		src.headwords = append(src.headwords, headwords)
+		if withBodies {
+			src.bodies = append(src.bodies, body)
 		}
-		defLines = nil
 	}
 
+	lineStart := pos
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
+		raw := scanner.Bytes()
+		start, end := lineStart, lineStart+int64(len(raw))
+		lineStart = pos
+		decoded, err := dec.Bytes(raw)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.TrimRight(strings.TrimPrefix(string(decoded), "\ufeff"), "\r\n")
+		if strings.HasPrefix(line, "#") && len(src.cards) == 0 && len(headwords) == 0 {
+			// Header lines only appear before the first card.
+			if name, value := splitHeader(line); name != "" && value != "" {
+				src.header[strings.ToLower(name)] = value
+			}
 			continue
 		}
-		trimmed := strings.TrimRight(line, "\r\n")
-		if trimmed == "" {
-			flush()
-			currentWord = ""
+		if strings.TrimSpace(line) == "" {
+			if len(bodyLines) > 0 {
+				flush()
+			}
 			continue
 		}
-		if len(trimmed) > 0 && (trimmed[0] == ' ' || trimmed[0] == '\t') {
-			if currentWord == "" {
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(headwords) == 0 {
 				continue
 			}
-			defLines = append(defLines, strings.TrimSpace(trimmed))
+			if len(bodyLines) == 0 {
+				bodyStart = start
+			}
+			bodyEnd = end
+			bodyLines = append(bodyLines, strings.TrimSpace(line))
 			continue
 		}
-		// New headword
-		flush()
-		currentWord = strings.TrimSpace(trimmed)
+		// A headword line after a body starts a new card; consecutive
+		// headword lines share the card that follows them.
+		if len(bodyLines) > 0 {
+			flush()
+		}
+		headwords = append(headwords, parseHeadword(line))
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 	flush()
+	return src, nil
+}
+
+// loadAbbreviations reads the companion <name>_abrv.dsl file, if any, mapping
+// each abbreviation to the plain text of its expansion.
+func loadAbbreviations(path string, caseFold bool) map[string]string {
+	p := abbreviationPath(path)
+	if p == "" {
+		return nil
+	}
+	src, err := readSource(p, true)
+	if err != nil {
+		log.Printf("dsl: failed to read abbreviations %q: %v", p, err)
+		return nil
+	}
+	out := make(map[string]string)
+	for i, c := range src.cards {
+		text := cardToText(src.bodies[i], c.Full)
+		if text == "" {
+			continue
+		}
+		for _, h := range src.headwords[i] {
+			for _, v := range h.variants {
+				key := normalize(v, caseFold)
+				if _, ok := out[key]; !ok {
+					out[key] = text
+				}
+			}
+		}
+	}
+	return out
+}
 
-	words := make([]string, 0, len(idx))
-	for k := range idx {
-		words = append(words, k)
+// SourceFiles returns the .dsl(.dz) file, its abbreviation file and its
+// resource archives. Files in the .files directory are read on demand and
+// not listed.
+func SourceFiles(path string) []string {
+	paths := []string{path}
+	if p := abbreviationPath(path); p != "" {
+		paths = append(paths, p)
 	}
-	sort.Strings(words)
+	return append(paths, archivePaths(path)...)
+}
 
-	_ = indexcache.Save(path, caseFold, words, idx, orig)
+// abbreviationPath finds name_abrv.dsl(.dz) next to name.dsl(.dz).
+func abbreviationPath(path string) string {
+	base := trimDZ(path)
+	ext := filepath.Ext(base)
+	if !strings.EqualFold(ext, ".dsl") {
+		return ""
+	}
+	base = strings.TrimSuffix(base, ext) + "_abrv" + ext
+	for _, p := range []string{base, base + ".dz"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
 
-	return &Dictionary{
-		id:       id,
-		name:     name,
-		caseFold: caseFold,
-		index:    idx,
-		words:    words,
-		original: orig,
-	}, nil
+func dictName(name, id string, header map[string]string) string {
+	if name != "" {
+		return name
+	}
+	if h := header["name"]; h != "" {
+		return h
+	}
+	return id
 }
 
 func (d *Dictionary) ID() string {
@@ -118,56 +315,122 @@ func (d *Dictionary) Name() string {
 	return d.name
 }
 
+// Metadata returns the DSL header fields (name, index_language,
+// contents_language, source_code_page, ...).
+func (d *Dictionary) Metadata() map[string]string {
+	out := make(map[string]string, len(d.header))
+	for k, v := range d.header {
+		out[k] = v
+	}
+	return out
+}
+
+// Close releases the DSL file and its resource archives.
+func (d *Dictionary) Close() error {
+	err := d.reader.Close()
+	if d.resources != nil {
+		if cerr := d.resources.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (d *Dictionary) Lookup(word string) []dict.Entry {
-	key := normalize(word, d.caseFold)
-	defs := d.index[key]
-	if len(defs) == 0 {
+	idxs := d.normIndex[normalize(word, d.caseFold)]
+	if len(idxs) == 0 {
 		return nil
 	}
-	entries := make([]dict.Entry, 0, len(defs))
-	for _, def := range defs {
-		entries = append(entries, dict.Entry{Word: d.original[key], Definition: def})
+	entries := make([]dict.Entry, 0, len(idxs))
+	for _, i := range idxs {
+		c := d.cards[i]
+		body, err := d.readBody(c)
+		if err != nil {
+			log.Printf("dsl: failed to read card %q in %s: %v", c.Headword, d.id, err)
+			continue
+		}
+		html := cardToHTML(body, c.Full, d.id, d.abbrev, d.caseFold)
+		if html == "" {
+			continue
+		}
+		html = `<div id="gdarticlefrom-` + dict.ScopeID(d.id) + `" class="dsl">` + html + `</div>`
+		entries = append(entries, dict.Entry{Word: c.Headword, Definition: html})
 	}
 	return entries
 }
 
+func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
+	if d.resources == nil {
+		return nil, "", false
+	}
+	return d.resources.read(name, d.id)
+}
+
+// readBody reads a card body from the source file and strips its indentation.
+func (d *Dictionary) readBody(c card) (string, error) {
+	raw, err := d.reader.Bytes(c.Offset, c.Size)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := d.enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(string(decoded), "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
 func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
 	if limit <= 0 {
 		limit = 20
 	}
 	pfx := normalize(prefix, d.caseFold)
-	idx := sort.Search(len(d.words), func(i int) bool {
-		return d.words[i] >= pfx
+	idx := sort.Search(len(d.sortedN), func(i int) bool {
+		return d.sortedN[i] >= pfx
 	})
-	if idx == len(d.words) {
+	if idx == len(d.sortedN) {
 		return nil
 	}
 	res := make([]dict.Entry, 0, limit)
-	for i := idx; i < len(d.words) && len(res) < limit; i++ {
-		w := d.words[i]
-		if !strings.HasPrefix(w, pfx) {
+	for i := idx; i < len(d.sortedN) && len(res) < limit; i++ {
+		if !strings.HasPrefix(d.sortedN[i], pfx) {
 			break
 		}
-		res = append(res, dict.Entry{Word: d.original[w]})
+		// Repeated headwords and optional-part variants share a form.
+		if i > idx && d.sortedN[i] == d.sortedN[i-1] {
+			continue
+		}
+		res = append(res, dict.Entry{Word: d.sortedW[i]})
 	}
 	return res
 }
 
-func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
 	if limit <= 0 {
 		limit = 20
 	}
-	q := normalize(query, d.caseFold)
-	res := make([]dict.Entry, 0, limit)
-	for _, w := range d.words {
-		if strings.Contains(w, q) {
-			res = append(res, dict.Entry{Word: d.original[w]})
-			if len(res) >= limit {
-				break
-			}
-		}
+	return dict.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
+	return out
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
 	}
-	return res
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
 }
 
 func normalize(s string, caseFold bool) string {
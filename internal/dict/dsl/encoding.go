@@ -0,0 +1,149 @@
+package dsl
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// sniffLen is how much of the file is inspected to pick an encoding; DSL
+// headers always sit at the very top of the file.
+const sniffLen = 4096
+
+// detectEncoding picks the text encoding of a DSL file from its first bytes.
+// It returns the decoder to use and how many leading BOM bytes to skip.
+func detectEncoding(head []byte) (encoding.Encoding, int) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return unicode.UTF8, 3
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), 2
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), 2
+	}
+	if enc := sniffUTF16(head); enc != nil {
+		return enc, 0
+	}
+	if label := headerCodepage(head); label != "" {
+		if enc := codepageEncoding(label); enc != nil {
+			return enc, 0
+		}
+	}
+	if utf8.Valid(trimPartialRune(head)) {
+		return unicode.UTF8, 0
+	}
+	// Lingvo itself defaults to the Western codepage for non-Unicode files.
+	return charmap.Windows1252, 0
+}
+
+// sniffUTF16 recognises BOM-less UTF-16 by the zero high bytes of ASCII text.
+func sniffUTF16(head []byte) encoding.Encoding {
+	n := len(head) &^ 1
+	if n < 4 {
+		return nil
+	}
+	var evenZero, oddZero int
+	for i := 0; i < n; i += 2 {
+		if head[i] == 0 {
+			evenZero++
+		}
+		if head[i+1] == 0 {
+			oddZero++
+		}
+	}
+	pairs := n / 2
+	switch {
+	case oddZero*2 > pairs && evenZero*8 < pairs:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case evenZero*2 > pairs && oddZero*8 < pairs:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+	return nil
+}
+
+// headerCodepage returns the value of a #CODEPAGE or #SOURCE_CODE_PAGE header.
+func headerCodepage(head []byte) string {
+	for _, line := range strings.Split(string(head), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		name, value := splitHeader(line)
+		if name == "CODEPAGE" || name == "SOURCE_CODE_PAGE" {
+			return value
+		}
+	}
+	return ""
+}
+
+// splitHeader splits a `#NAME "value"` header line.
+func splitHeader(line string) (string, string) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "#")
+	name, value := line, ""
+	if sp := strings.IndexAny(line, " \t"); sp >= 0 {
+		name, value = line[:sp], strings.TrimSpace(line[sp+1:])
+	}
+	value = strings.TrimPrefix(value, `"`)
+	value = strings.TrimSuffix(value, `"`)
+	return strings.ToUpper(strings.TrimSpace(name)), strings.TrimSpace(value)
+}
+
+// codepageEncoding maps Lingvo codepage names ("Cyrillic", "Latin", ...) and
+// Windows codepage labels ("1251", "cp1251", "windows-1251") to an encoding.
+func codepageEncoding(label string) encoding.Encoding {
+	switch strings.ToLower(strings.ReplaceAll(label, " ", "")) {
+	case "latin", "western":
+		return charmap.Windows1252
+	case "cyrillic":
+		return charmap.Windows1251
+	case "easterneuropean", "centraleuropean":
+		return charmap.Windows1250
+	case "greek":
+		return charmap.Windows1253
+	case "turkish":
+		return charmap.Windows1254
+	case "hebrew":
+		return charmap.Windows1255
+	case "arabic":
+		return charmap.Windows1256
+	case "baltic":
+		return charmap.Windows1257
+	case "vietnamese":
+		return charmap.Windows1258
+	case "unicode", "utf-16", "utf16":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case "utf-8", "utf8":
+		return unicode.UTF8
+	}
+	l := strings.ToLower(strings.TrimSpace(label))
+	if enc, err := htmlindex.Get(l); err == nil {
+		return enc
+	}
+	if n := strings.TrimPrefix(l, "cp"); n != "" && n[0] >= '0' && n[0] <= '9' {
+		if enc, err := htmlindex.Get("windows-" + n); err == nil {
+			return enc
+		}
+	}
+	return nil
+}
+
+// trimPartialRune drops a UTF-8 sequence cut off by the sniff window.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+package dsl
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxVariants bounds the number of index forms produced by optional parts.
+const maxVariants = 32
+
+// headword is a parsed DSL headword line.
+//
+// Optional parts in parentheses yield both the short and the long form for
+// indexing ("colo(u)r" -> "color", "colour"). Unsorted parts in braces are
+// dropped from the index but kept for display ("{to }run" -> "run").
+type headword struct {
+	// display is shown to the user: braces removed, parentheses kept.
+	display string
+	// full is substituted for ~ in the card body: every optional part included.
+	full string
+	// variants are the forms registered in the index.
+	variants []string
+}
+
+func parseHeadword(raw string) headword {
+	raw = strings.TrimSpace(raw)
+	var display, full strings.Builder
+	variants := expandOptional(raw, &display, &full)
+
+	h := headword{
+		display: collapseSpaces(display.String()),
+		full:    collapseSpaces(full.String()),
+	}
+	seen := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		v = collapseSpaces(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		h.variants = append(h.variants, v)
+	}
+	return h
+}
+
+// expandOptional walks s and returns every index form. display and full,
+// when non-nil, receive the display and full forms of s.
+func expandOptional(s string, display, full *strings.Builder) []string {
+	variants := []string{""}
+	appendAll := func(text string) {
+		for i := range variants {
+			variants[i] += text
+		}
+	}
+	write := func(b *strings.Builder, text string) {
+		if b != nil {
+			b.WriteString(text)
+		}
+	}
+
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch ch {
+		case '\\':
+			if i+1 >= len(s) {
+				i++
+				continue
+			}
+			_, size := utf8.DecodeRuneInString(s[i+1:])
+			lit := s[i+1 : i+1+size]
+			appendAll(lit)
+			write(display, lit)
+			write(full, lit)
+			i += 1 + size
+		case '{':
+			end := matchingClose(s, i, '{', '}')
+			if end < 0 {
+				end = len(s)
+			}
+			write(display, stripTags(unescape(s[i+1:end])))
+			i = end + 1
+		case '(':
+			end := matchingClose(s, i, '(', ')')
+			if end < 0 {
+				// Unbalanced parenthesis: take it literally.
+				appendAll("(")
+				write(display, "(")
+				write(full, "(")
+				i++
+				continue
+			}
+			inner := s[i+1 : end]
+			var innerDisplay, innerFull strings.Builder
+			opts := expandOptional(inner, &innerDisplay, &innerFull)
+			write(display, "("+innerDisplay.String()+")")
+			write(full, innerFull.String())
+			next := make([]string, 0, len(variants)*(len(opts)+1))
+			for _, v := range variants {
+				if len(next) >= maxVariants {
+					break
+				}
+				next = append(next, v)
+				for _, o := range opts {
+					if len(next) >= maxVariants {
+						break
+					}
+					next = append(next, v+o)
+				}
+			}
+			variants = next
+			i = end + 1
+		default:
+			_, size := utf8.DecodeRuneInString(s[i:])
+			lit := s[i : i+size]
+			appendAll(lit)
+			write(display, lit)
+			write(full, lit)
+			i += size
+		}
+	}
+	return variants
+}
+
+// matchingClose returns the index of the bracket closing the one at start,
+// skipping escaped characters, or -1.
+func matchingClose(s string, start int, open, close byte) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// stripTags removes DSL [tag] markup that may appear inside unsorted parts.
+func stripTags(s string) string {
+	if !strings.Contains(s, "[") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(s, '[')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(s[open:], ']')
+		if end < 0 {
+			break
+		}
+		b.WriteString(s[:open])
+		s = s[open+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,57 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeadword(t *testing.T) {
+	tests := []struct {
+		raw      string
+		display  string
+		full     string
+		variants []string
+	}{
+		{raw: "colo(u)r", display: "colo(u)r", full: "colour", variants: []string{"color", "colour"}},
+		{raw: "{to }run", display: "to run", full: "run", variants: []string{"run"}},
+		{raw: `a\(b\) \{c\}`, display: "a(b) {c}", full: "a(b) {c}", variants: []string{"a(b) {c}"}},
+		{raw: "{[i]}x{[/i]}-ray", display: "x-ray", full: "x-ray", variants: []string{"x-ray"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.raw, func(t *testing.T) {
+			h := parseHeadword(tc.raw)
+			if h.display != tc.display || h.full != tc.full || !reflect.DeepEqual(h.variants, tc.variants) {
+				t.Fatalf("parseHeadword(%q) = %+v", tc.raw, h)
+			}
+		})
+	}
+}
+
+func TestParseHeadwordVariantLimit(t *testing.T) {
+	h := parseHeadword("a(b)(c)(d)(e)(f)(g)(h)")
+	if len(h.variants) != maxVariants {
+		t.Fatalf("got %d variants, want %d", len(h.variants), maxVariants)
+	}
+}
+
+func TestPrefixDeduplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.dsl")
+	data := "#NAME \"Test\"\n\ncolo(u)r\n\t[m1]first[/m]\ncolor\n\t[m1]second[/m]\nColour\n\t[m1]third[/m]\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load("t", "", path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var words []string
+	for _, e := range d.Prefix("col", 10) {
+		words = append(words, e.Word)
+	}
+	if len(words) != 2 {
+		t.Fatalf("Prefix(col) = %q, want one color and one colour", words)
+	}
+}
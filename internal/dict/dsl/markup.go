@@ -0,0 +1,424 @@
+package dsl
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+type dslNodeType int
+
+const (
+	dslElement dslNodeType = iota
+	dslText
+)
+
+type dslNode struct {
+	typ      dslNodeType
+	tag      string
+	attr     string
+	data     string
+	children []*dslNode
+}
+
+type dslContext struct {
+	dictID   string
+	headword string
+	abbrev   map[string]string
+	caseFold bool
+}
+
+// cardToHTML renders the body of a DSL card into HTML. Each card line becomes
+// its own block; [mN] lines carry their margin level. abbrev maps [p] labels
+// to their expansion from the companion abbreviation file.
+func cardToHTML(body, headword, dictID string, abbrev map[string]string, caseFold bool) string {
+	if body == "" {
+		return ""
+	}
+	ctx := dslContext{dictID: dictID, headword: headword, abbrev: abbrev, caseFold: caseFold}
+	body = stripComments(body)
+	var b strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "@") {
+			title := strings.TrimSpace(strings.TrimPrefix(line, "@"))
+			if title != "" {
+				b.WriteString(`<div class="dsl_subentry">`)
+				renderChildren(&b, parseLine(title, &ctx).children, &ctx)
+				b.WriteString(`</div>`)
+			}
+			continue
+		}
+		root := parseLine(line, &ctx)
+		if len(root.children) == 1 && isMarginTag(root.children[0].tag) {
+			renderNode(&b, root.children[0], &ctx)
+			continue
+		}
+		b.WriteString(`<div class="dsl_line">`)
+		renderChildren(&b, root.children, &ctx)
+		b.WriteString(`</div>`)
+	}
+	return b.String()
+}
+
+// cardToText renders the body of a DSL card as plain text, one line per
+// card line joined with "; ".
+func cardToText(body, headword string) string {
+	ctx := dslContext{headword: headword}
+	var lines []string
+	for _, line := range strings.Split(stripComments(body), "\n") {
+		text := collapseSpaces(textContent(parseLine(strings.TrimSpace(line), &ctx)))
+		if text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "; ")
+}
+
+// stripComments removes {{...}} comments, which may span several lines.
+func stripComments(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			b.WriteByte(s[i])
+			b.WriteByte(s[i+1])
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], "{{") {
+			end := strings.Index(s[i+2:], "}}")
+			if end < 0 {
+				break
+			}
+			i += end + 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func parseLine(line string, ctx *dslContext) *dslNode {
+	root := &dslNode{typ: dslElement}
+	stack := []*dslNode{root}
+	var text strings.Builder
+
+	flushText := func() {
+		if text.Len() == 0 {
+			return
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, &dslNode{typ: dslText, data: text.String()})
+		text.Reset()
+	}
+
+	for i := 0; i < len(line); {
+		ch := line[i]
+		switch {
+		case ch == '\\' && i+1 < len(line):
+			r, size := utf8.DecodeRuneInString(line[i+1:])
+			text.WriteRune(r)
+			i += 1 + size
+		case ch == '^' && i+1 < len(line) && line[i+1] == '~':
+			text.WriteString(swapFirstCase(ctx.headword))
+			i += 2
+		case ch == '~':
+			text.WriteString(ctx.headword)
+			i++
+		case strings.HasPrefix(line[i:], "<<"):
+			end := strings.Index(line[i+2:], ">>")
+			if end < 0 {
+				text.WriteString("<<")
+				i += 2
+				continue
+			}
+			flushText()
+			target := unescape(line[i+2 : i+2+end])
+			ref := &dslNode{typ: dslElement, tag: "ref"}
+			ref.children = append(ref.children, &dslNode{typ: dslText, data: target})
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, ref)
+			i += end + 4
+		case ch == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				text.WriteByte(ch)
+				i++
+				continue
+			}
+			raw := line[i+1 : i+1+end]
+			name, attr := splitTag(raw)
+			closing := strings.HasPrefix(name, "/")
+			name = strings.TrimPrefix(name, "/")
+			if !isKnownTag(name) {
+				text.WriteString(line[i : i+end+2])
+				i += end + 2
+				continue
+			}
+			flushText()
+			if closing {
+				for j := len(stack) - 1; j > 0; j-- {
+					if sameTag(stack[j].tag, name) {
+						stack = stack[:j]
+						break
+					}
+				}
+			} else {
+				node := &dslNode{typ: dslElement, tag: name, attr: attr}
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+				stack = append(stack, node)
+			}
+			i += end + 2
+		default:
+			text.WriteByte(ch)
+			i++
+		}
+	}
+	flushText()
+	return root
+}
+
+func splitTag(raw string) (string, string) {
+	raw = strings.TrimSpace(raw)
+	if sp := strings.IndexAny(raw, " \t"); sp >= 0 {
+		return raw[:sp], strings.TrimSpace(raw[sp+1:])
+	}
+	return raw, ""
+}
+
+func isKnownTag(name string) bool {
+	if isMarginTag(name) {
+		return true
+	}
+	switch name {
+	case "b", "i", "u", "c", "sup", "sub", "*", "'",
+		"trn", "!trn", "trs", "!trs", "ex", "com", "p", "t", "lang",
+		"ref", "url", "s", "video":
+		return true
+	default:
+		return false
+	}
+}
+
+func isMarginTag(name string) bool {
+	if name == "m" {
+		return true
+	}
+	return len(name) == 2 && name[0] == 'm' && name[1] >= '0' && name[1] <= '9'
+}
+
+func sameTag(open, closing string) bool {
+	if open == closing {
+		return true
+	}
+	// [/m] closes any [mN].
+	return closing == "m" && isMarginTag(open)
+}
+
+func renderChildren(b *strings.Builder, nodes []*dslNode, ctx *dslContext) {
+	for _, n := range nodes {
+		renderNode(b, n, ctx)
+	}
+}
+
+func renderNode(b *strings.Builder, n *dslNode, ctx *dslContext) {
+	if n.typ == dslText {
+		b.WriteString(html.EscapeString(n.data))
+		return
+	}
+	switch {
+	case isMarginTag(n.tag):
+		level := 0
+		if len(n.tag) == 2 {
+			level = int(n.tag[1] - '0')
+		}
+		b.WriteString(`<div class="dsl_m` + strconv.Itoa(level) + `" style="padding-left:` + strconv.Itoa(level) + `em">`)
+		renderChildren(b, n.children, ctx)
+		b.WriteString(`</div>`)
+	case n.tag == "b" || n.tag == "i" || n.tag == "u" || n.tag == "sup" || n.tag == "sub":
+		b.WriteString("<" + n.tag + ">")
+		renderChildren(b, n.children, ctx)
+		b.WriteString("</" + n.tag + ">")
+	case n.tag == "c":
+		color := strings.TrimSpace(n.attr)
+		if color == "" || !isSafeColor(color) {
+			color = "green"
+		}
+		b.WriteString(`<span class="dsl_c" style="color:` + color + `">`)
+		renderChildren(b, n.children, ctx)
+		b.WriteString(`</span>`)
+	case n.tag == "ref":
+		target := strings.TrimSpace(textContent(n))
+		if target == "" {
+			return
+		}
+		b.WriteString(`<a class="dsl_ref" href="` + html.EscapeString(dict.EntryURL(ctx.dictID, target)) + `">`)
+		renderChildren(b, n.children, ctx)
+		b.WriteString(`</a>`)
+	case n.tag == "url":
+		target := strings.TrimSpace(textContent(n))
+		if target == "" {
+			return
+		}
+		if !isSafeURL(target) {
+			renderChildren(b, n.children, ctx)
+			return
+		}
+		b.WriteString(`<a class="dsl_url" href="` + html.EscapeString(target) + `">`)
+		renderChildren(b, n.children, ctx)
+		b.WriteString(`</a>`)
+	case n.tag == "p":
+		b.WriteString(`<span class="dsl_p"`)
+		if title := ctx.abbreviation(textContent(n)); title != "" {
+			b.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		b.WriteString(`>`)
+		renderChildren(b, n.children, ctx)
+		b.WriteString(`</span>`)
+	case n.tag == "s" || n.tag == "video":
+		renderMedia(b, strings.TrimSpace(textContent(n)), n.tag == "video", ctx)
+	default:
+		b.WriteString(`<span class="` + spanClass(n.tag) + `">`)
+		renderChildren(b, n.children, ctx)
+		b.WriteString(`</span>`)
+	}
+}
+
+func (ctx *dslContext) abbreviation(label string) string {
+	if len(ctx.abbrev) == 0 {
+		return ""
+	}
+	label = strings.TrimSpace(label)
+	if ctx.caseFold {
+		label = strings.ToLower(label)
+	}
+	return ctx.abbrev[label]
+}
+
+func spanClass(tag string) string {
+	switch tag {
+	case "*":
+		return "dsl_opt"
+	case "'":
+		return "dsl_stress"
+	case "!trn", "!trs":
+		return "dsl_" + tag[1:]
+	default:
+		return "dsl_" + tag
+	}
+}
+
+func renderMedia(b *strings.Builder, name string, video bool, ctx *dslContext) {
+	if name == "" {
+		return
+	}
+	src := html.EscapeString(dict.ResourceURL(ctx.dictID, name))
+	switch {
+	case video || isVideoFile(name):
+		b.WriteString(`<video class="dsl_video" controls src="` + src + `"></video>`)
+	case isImageFile(name):
+		b.WriteString(`<img class="dsl_img" src="` + src + `" alt="` + html.EscapeString(name) + `"/>`)
+	case isSoundFile(name):
+		b.WriteString(`<audio class="dsl_s" controls src="` + src + `"></audio>`)
+	default:
+		b.WriteString(`<a class="dsl_s" href="` + src + `">` + html.EscapeString(name) + `</a>`)
+	}
+}
+
+func textContent(n *dslNode) string {
+	if n.typ == dslText {
+		return n.data
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func swapFirstCase(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	if unicode.IsUpper(r) {
+		r = unicode.ToLower(r)
+	} else {
+		r = unicode.ToUpper(r)
+	}
+	return string(r) + s[size:]
+}
+
+func isSafeColor(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// isSafeURL allows http, https and mailto links and scheme-less relative
+// links, so a dictionary cannot inject javascript: or data: URLs.
+func isSafeURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	case "":
+		return u.Opaque == ""
+	}
+	return false
+}
+
+func isImageFile(name string) bool {
+	return hasAnySuffix(strings.ToLower(name), ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".ico", ".webp", ".svg")
+}
+
+func isSoundFile(name string) bool {
+	return hasAnySuffix(strings.ToLower(name), ".wav", ".ogg", ".oga", ".mp3", ".m4a", ".aac", ".flac", ".opus", ".spx", ".wma")
+}
+
+func isVideoFile(name string) bool {
+	return hasAnySuffix(strings.ToLower(name), ".mp4", ".webm", ".ogv", ".avi", ".mkv", ".mov")
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suf := range suffixes {
+		if strings.HasSuffix(s, suf) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCardToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "margin and styles",
+			body: "[m1][b]noun[/b] [i]a word[/i][/m]",
+			want: []string{`<div class="dsl_m1" style="padding-left:1em"><b>noun</b> <i>a word</i></div>`},
+		},
+		{
+			name: "color and translation",
+			body: "[c red]warn[/c] [trn]tr[/trn]",
+			want: []string{`<span class="dsl_c" style="color:red">warn</span>`, `<span class="dsl_trn">tr</span>`},
+		},
+		{
+			name: "references",
+			body: "see [ref]other[/ref] and <<third>>",
+			want: []string{`href="/entry?dict=d1&amp;q=other"`, `href="/entry?dict=d1&amp;q=third"`},
+		},
+		{
+			name: "media",
+			body: "[s]a b.wav[/s] [s]pic.png[/s]",
+			want: []string{`<audio class="dsl_s" controls src="/resource/a%20b.wav?dict=d1">`, `<img class="dsl_img" src="/resource/pic.png?dict=d1"`},
+		},
+		{
+			name: "headword, escapes and comments",
+			body: "~ \\[x\\] {{hidden}}^~",
+			want: []string{`<div class="dsl_line">word [x] Word</div>`},
+		},
+		{
+			name: "abbreviation tooltip",
+			body: "[p]N[/p] [p]adj[/p]",
+			want: []string{`<span class="dsl_p" title="noun">N</span>`, `<span class="dsl_p">adj</span>`},
+		},
+		{
+			name: "links",
+			body: "[url]https://example.com/a?b=1&c=2[/url] [url]JavaScript:alert(1)[/url] [url] data:text/html,x[/url]",
+			want: []string{`<a class="dsl_url" href="https://example.com/a?b=1&amp;c=2">https://example.com/a?b=1&amp;c=2</a> JavaScript:alert(1)  data:text/html,x`},
+		},
+		{
+			name: "unknown tags stay literal",
+			body: "[zz]text[/zz] & <b>",
+			want: []string{`[zz]text[/zz] &amp; &lt;b&gt;`},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := cardToHTML(tc.body, "word", "d1", map[string]string{"n": "noun"}, true)
+			for _, w := range tc.want {
+				if !strings.Contains(got, w) {
+					t.Fatalf("cardToHTML() = %q, want substring %q", got, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package dsl
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+// resourceStore serves media referenced by [s] tags. Lingvo ships it either
+// unpacked in a name.dsl.files directory or packed in name.dsl.files.zip.
+// Nothing else next to the dictionary is served.
+type resourceStore struct {
+	dirs  []string
+	zips  []*zip.ReadCloser
+	files map[string]*zip.File
+}
+
+// archivePaths returns the resource archives of dslPath that exist.
+func archivePaths(dslPath string) []string {
+	base := trimDZ(dslPath)
+	candidates := []string{base + ".files.zip"}
+	if base != dslPath {
+		candidates = append(candidates, dslPath+".files.zip")
+	}
+	var out []string
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func openResources(dslPath string) *resourceStore {
+	base := trimDZ(dslPath)
+	rs := &resourceStore{
+		dirs:  []string{base + ".files"},
+		files: make(map[string]*zip.File),
+	}
+	for _, p := range archivePaths(dslPath) {
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			log.Printf("dsl: failed to open resource archive %q: %v", p, err)
+			continue
+		}
+		rs.zips = append(rs.zips, zr)
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			name := strings.ToLower(dict.CleanResourceName(f.Name))
+			if _, ok := rs.files[name]; !ok {
+				rs.files[name] = f
+			}
+			// Archives sometimes nest everything under a folder; allow
+			// lookups by bare file name too.
+			if bare := path.Base(name); bare != name {
+				if _, ok := rs.files[bare]; !ok {
+					rs.files[bare] = f
+				}
+			}
+		}
+	}
+	return rs
+}
+
+func (rs *resourceStore) read(name, dictID string) ([]byte, string, bool) {
+	clean := dict.CleanResourceName(name)
+	if clean == "" {
+		return nil, "", false
+	}
+	data, ok := rs.readFile(clean)
+	if !ok {
+		return nil, "", false
+	}
+	if strings.HasSuffix(strings.ToLower(clean), ".css") {
+		css := string(data)
+		css = dict.RewriteCSSLinks(css, dictID)
+		css = dict.IsolateCSS(css, dictID, "")
+		data = []byte(css)
+	}
+	return data, mime.TypeByExtension(filepath.Ext(clean)), true
+}
+
+func (rs *resourceStore) readFile(clean string) ([]byte, bool) {
+	for _, dir := range rs.dirs {
+		p := filepath.Join(dir, filepath.FromSlash(clean))
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			if data, err := os.ReadFile(p); err == nil {
+				return data, true
+			}
+		}
+	}
+	f, ok := rs.files[strings.ToLower(clean)]
+	if !ok {
+		return nil, false
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, false
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (rs *resourceStore) Close() error {
+	var first error
+	for _, zr := range rs.zips {
+		if err := zr.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
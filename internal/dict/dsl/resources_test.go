@@ -0,0 +1,39 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResourcesOnlyFromFilesDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.dsl")
+	files := map[string]string{
+		"test.dsl":             "#NAME \"Test\"\n\nword\n\t[s]a.wav[/s]\n",
+		"test.dsl.files/a.wav": "RIFF",
+		"other.txt":            "secret",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := Load("t", "", path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if data, _, ok := d.Resource("a.wav"); !ok || string(data) != "RIFF" {
+		t.Fatalf("Resource(a.wav) = %q, %v", data, ok)
+	}
+	for _, name := range []string{"other.txt", "test.dsl", "test.dsl.gdapi.dsl.idx", "../other.txt"} {
+		if _, _, ok := d.Resource(name); ok {
+			t.Fatalf("Resource(%q) served a file outside test.dsl.files", name)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sagerenn/mdict/internal/dict/dictzip"
+	"golang.org/x/text/encoding"
+)
+
+// trimDZ strips a trailing .dz extension: "name.dsl.dz" -> "name.dsl".
+func trimDZ(path string) string {
+	if dictzip.IsCompressed(path) {
+		return path[:len(path)-len(filepath.Ext(path))]
+	}
+	return path
+}
+
+// openStream opens the decompressed DSL text for a sequential read.
+func openStream(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !dictzip.IsCompressed(path) {
+		return file, nil
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &gzipStream{Reader: zr, file: file}, nil
+}
+
+type gzipStream struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (s *gzipStream) Close() error {
+	err := s.Reader.Close()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sniffEncoding reads the first bytes of the DSL text and detects its encoding.
+func sniffEncoding(path string) (encoding.Encoding, int, error) {
+	stream, err := openStream(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stream.Close()
+	head, err := io.ReadAll(io.LimitReader(stream, sniffLen))
+	if err != nil {
+		return nil, 0, err
+	}
+	enc, bom := detectEncoding(head)
+	return enc, bom, nil
+}
+
+// lineSplitter returns a bufio.SplitFunc that splits raw encoded text into
+// lines, honouring the code unit width of UTF-16 so offsets stay aligned.
+// Each consumed byte count is added to *pos.
+func lineSplitter(enc encoding.Encoding, pos *int64) bufio.SplitFunc {
+	nl, err := enc.NewEncoder().Bytes([]byte{'\n'})
+	if err != nil || len(nl) == 0 {
+		nl = []byte{'\n'}
+	}
+	width := len(nl)
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if width == 1 {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				*pos += int64(i + 1)
+				return i + 1, data[:i], nil
+			}
+		} else {
+			for i := 0; i+width <= len(data); i += width {
+				if bytes.Equal(data[i:i+width], nl) {
+					*pos += int64(i + width)
+					return i + width, data[:i], nil
+				}
+			}
+		}
+		if atEOF {
+			*pos += int64(len(data))
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
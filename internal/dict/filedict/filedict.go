@@ -2,8 +2,11 @@ package filedict
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,30 +17,28 @@ import (
 )
 
 type Dictionary struct {
-	id       string
-	name     string
-	caseFold bool
-	index    map[string][]string
-	words    []string
-	original map[string]string
+	id        string
+	name      string
+	caseFold  bool
+	index     map[string][]string
+	words     []string
+	original  map[string]string
+	headwords map[string][]string
 }
 
 func NewFromTSV(id, name, path, delimiter string, caseFold bool) (*Dictionary, error) {
-	if id == "" || name == "" {
-		return nil, errors.New("id and name are required")
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+	if name == "" {
+		name = id
 	}
 	if delimiter == "" {
 		delimiter = "\t"
 	}
-	if idx, ok, err := indexcache.Load(path, caseFold); err == nil && ok {
-		return &Dictionary{
-			id:       id,
-			name:     name,
-			caseFold: caseFold,
-			index:    idx.Entries,
-			words:    idx.Words,
-			original: idx.Original,
-		}, nil
+	options := "tsv:" + delimiter
+	if d, ok := fromCache(id, name, path, caseFold, options); ok {
+		return d, nil
 	}
 	file, err := os.Open(path)
 	if err != nil {
@@ -45,8 +46,7 @@ func NewFromTSV(id, name, path, delimiter string, caseFold bool) (*Dictionary, e
 	}
 	defer file.Close()
 
-	idx := make(map[string][]string)
-	orig := make(map[string]string)
+	b := newBuilder(caseFold)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -59,104 +59,197 @@ func NewFromTSV(id, name, path, delimiter string, caseFold bool) (*Dictionary, e
 		}
 		word := strings.TrimSpace(parts[0])
 		def := strings.TrimSpace(strings.Join(parts[1:], delimiter))
-		if word == "" || def == "" {
-			continue
-		}
-		key := normalize(word, caseFold)
-		idx[key] = append(idx[key], def)
-		if _, ok := orig[key]; !ok {
-			orig[key] = word
-		}
+		b.add(word, def, "")
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-
-	words := make([]string, 0, len(idx))
-	for k := range idx {
-		words = append(words, k)
-	}
-	sort.Strings(words)
-
-	_ = indexcache.Save(path, caseFold, words, idx, orig)
-
-	return &Dictionary{
-		id:       id,
-		name:     name,
-		caseFold: caseFold,
-		index:    idx,
-		words:    words,
-		original: orig,
-	}, nil
+	return b.finish(id, name, path, options), nil
 }
 
+// NewFromJSON reads a JSON array of entries or JSON Lines (one entry per
+// line), decoding one entry at a time.
 func NewFromJSON(id, name, path string, caseFold bool) (*Dictionary, error) {
-	if id == "" || name == "" {
-		return nil, errors.New("id and name are required")
-	}
-	if idx, ok, err := indexcache.Load(path, caseFold); err == nil && ok {
-		return &Dictionary{
-			id:       id,
-			name:     name,
-			caseFold: caseFold,
-			index:    idx.Entries,
-			words:    idx.Words,
-			original: idx.Original,
-		}, nil
-	}
-	data, err := os.ReadFile(path)
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+	if name == "" {
+		name = id
+	}
+	const options = "json:2"
+	if d, ok := fromCache(id, name, path, caseFold, options); ok {
+		return d, nil
+	}
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	var entries []dict.Entry
-	if err := json.Unmarshal(data, &entries); err != nil {
-		return nil, err
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	dec := json.NewDecoder(br)
+	array := false
+	if c, err := firstByte(br); err == nil && c == '[' {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		array = true
 	}
-	idx := make(map[string][]string)
-	orig := make(map[string]string)
-	for _, e := range entries {
+	b := newBuilder(caseFold)
+	for n := 1; ; n++ {
+		if array && !dec.More() {
+			break
+		}
+		var e jsonEntry
+		if err := dec.Decode(&e); err != nil {
+			if !array && errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("entry %d: %w", n, err)
+		}
 		word := strings.TrimSpace(e.Word)
-		def := strings.TrimSpace(e.Definition)
-		if word == "" || def == "" {
+		def := e.render()
+		b.add(word, def, "")
+		for _, alias := range e.aliases() {
+			if normalize(alias, caseFold) != normalize(word, caseFold) {
+				b.add(alias, def, word)
+			}
+		}
+	}
+	return b.finish(id, name, path, options), nil
+}
+
+// firstByte peeks at the first non-space byte of r, skipping a UTF-8 BOM.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case 0xEF:
+			// BOM; json.Decoder does not accept it.
+			if _, err := r.Discard(2); err != nil {
+				return 0, err
+			}
 			continue
 		}
-		key := normalize(word, caseFold)
-		idx[key] = append(idx[key], def)
-		if _, ok := orig[key]; !ok {
-			orig[key] = word
+		return c, r.UnreadByte()
+	}
+}
+
+// fromCache returns the dictionary from its index cache when the cache
+// matches the source file and options.
+func fromCache(id, name, path string, caseFold bool, options string) (*Dictionary, bool) {
+	idx, ok, err := indexcache.Load(path, caseFold, options)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return &Dictionary{
+		id:        id,
+		name:      name,
+		caseFold:  caseFold,
+		index:     idx.Entries,
+		words:     idx.Words,
+		original:  idx.Original,
+		headwords: idx.Headwords,
+	}, true
+}
+
+// builder collects entries while a source file is read.
+type builder struct {
+	caseFold  bool
+	index     map[string][]string
+	original  map[string]string
+	headwords map[string][]string
+}
+
+func newBuilder(caseFold bool) *builder {
+	return &builder{
+		caseFold:  caseFold,
+		index:     make(map[string][]string),
+		original:  make(map[string]string),
+		headwords: make(map[string][]string),
+	}
+}
+
+// add indexes def under word. headword is set when word is an alias.
+func (b *builder) add(word, def, headword string) {
+	if word == "" || def == "" {
+		return
+	}
+	key := normalize(word, b.caseFold)
+	b.index[key] = append(b.index[key], def)
+	if _, ok := b.original[key]; !ok {
+		b.original[key] = word
+	}
+	hw := b.headwords[key]
+	if headword != "" || len(hw) > 0 {
+		// Keep Headwords parallel to the definitions of key.
+		for len(hw) < len(b.index[key])-1 {
+			hw = append(hw, "")
 		}
+		b.headwords[key] = append(hw, headword)
 	}
-	words := make([]string, 0, len(idx))
-	for k := range idx {
+}
+
+// finish sorts the words, writes the index cache and returns the
+// dictionary.
+func (b *builder) finish(id, name, path, options string) *Dictionary {
+	words := make([]string, 0, len(b.index))
+	for k := range b.index {
 		words = append(words, k)
 	}
 	sort.Strings(words)
 
-	_ = indexcache.Save(path, caseFold, words, idx, orig)
+	_ = indexcache.Save(path, &indexcache.Index{
+		CaseFold:  b.caseFold,
+		Options:   options,
+		Words:     words,
+		Entries:   b.index,
+		Original:  b.original,
+		Headwords: b.headwords,
+	})
 
 	return &Dictionary{
-		id:       id,
-		name:     name,
-		caseFold: caseFold,
-		index:    idx,
-		words:    words,
-		original: orig,
-	}, nil
+		id:        id,
+		name:      name,
+		caseFold:  b.caseFold,
+		index:     b.index,
+		words:     words,
+		original:  b.original,
+		headwords: b.headwords,
+	}
 }
 
-func Load(id, name, path, typ, delimiter string, caseFold bool) (*Dictionary, error) {
+// Load opens a TSV, CSV, JSON or JSON Lines dictionary. schema may be nil;
+// without one, TSV files keep the "word<delimiter>definition" layout and
+// CSV files use the first column as the word.
+func Load(id, name, path, typ, delimiter string, schema *Schema, caseFold bool) (*Dictionary, error) {
 	switch strings.ToLower(typ) {
 	case "tsv", "tab", "txt":
+		if schema != nil {
+			return NewFromTable(id, name, path, delimiter, schema, caseFold)
+		}
 		return NewFromTSV(id, name, path, delimiter, caseFold)
-	case "json":
+	case "csv":
+		if delimiter == "" {
+			delimiter = ","
+		}
+		return NewFromTable(id, name, path, delimiter, schema, caseFold)
+	case "json", "jsonl", "ndjson":
 		return NewFromJSON(id, name, path, caseFold)
 	case "":
 		// Attempt by extension.
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".json" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".jsonl", ".ndjson":
 			return NewFromJSON(id, name, path, caseFold)
+		case ".csv":
+			return Load(id, name, path, "csv", delimiter, schema, caseFold)
 		}
-		return NewFromTSV(id, name, path, delimiter, caseFold)
+		return Load(id, name, path, "tsv", delimiter, schema, caseFold)
 	default:
 		return nil, errors.New("unsupported dictionary type: " + typ)
 	}
@@ -176,9 +269,15 @@ func (d *Dictionary) Lookup(word string) []dict.Entry {
 	if len(defs) == 0 {
 		return nil
 	}
+	hw := d.headwords[key]
 	entries := make([]dict.Entry, 0, len(defs))
-	for _, def := range defs {
-		entries = append(entries, dict.Entry{Word: d.original[key], Definition: def})
+	for i, def := range defs {
+		e := dict.Entry{Word: d.original[key], Definition: def}
+		if i < len(hw) && hw[i] != "" {
+			// Matched through an alias.
+			e.Word, e.Synonym = hw[i], e.Word
+		}
+		entries = append(entries, e)
 	}
 	return entries
 }
@@ -205,13 +304,38 @@ func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
 	return res
 }
 
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
+	if limit <= 0 {
+		limit = 20
+	}
+	norm, _ := dict.PrefixRange(d.words, d.words, normalize(prefix, d.caseFold), limit)
+	words := make([]string, len(norm))
+	for i, w := range norm {
+		words[i] = d.original[w]
+	}
+	return norm, words
+}
+
 func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	res, _ := d.SearchContext(context.Background(), query, limit)
+	return res
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
 	if limit <= 0 {
 		limit = 20
 	}
 	q := normalize(query, d.caseFold)
 	res := make([]dict.Entry, 0, limit)
-	for _, w := range d.words {
+	for i, w := range d.words {
+		if i%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+		}
 		if strings.Contains(w, q) {
 			res = append(res, dict.Entry{Word: d.original[w]})
 			if len(res) >= limit {
@@ -219,7 +343,7 @@ func (d *Dictionary) Search(query string, limit int) []dict.Entry {
 			}
 		}
 	}
-	return res
+	return res, nil
 }
 
 func normalize(s string, caseFold bool) string {
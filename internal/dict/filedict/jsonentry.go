@@ -0,0 +1,175 @@
+package filedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"strings"
+)
+
+// jsonEntry is one entry of a .json or .jsonl file. Besides the plain
+// {"word", "definition"} form it accepts the fields of Wiktextract
+// (kaikki.org) exports: forms, senses with glosses and examples, and
+// sounds with IPA.
+type jsonEntry struct {
+	Word          string      `json:"word"`
+	Definition    string      `json:"definition"`
+	Format        string      `json:"format"`
+	Aliases       []jsonForm  `json:"aliases"`
+	Forms         []jsonForm  `json:"forms"`
+	POS           string      `json:"pos"`
+	Pronunciation string      `json:"pronunciation"`
+	Sounds        []jsonSound `json:"sounds"`
+	Senses        []jsonSense `json:"senses"`
+}
+
+type jsonSense struct {
+	Glosses  []string    `json:"glosses"`
+	Gloss    string      `json:"gloss"`
+	Tags     []string    `json:"tags"`
+	Examples []jsonForm  `json:"examples"`
+	Senses   []jsonSense `json:"senses"`
+}
+
+type jsonSound struct {
+	IPA string `json:"ipa"`
+}
+
+// jsonForm is a string or an object carrying the text in "form" or
+// "text" (Wiktextract forms and examples).
+type jsonForm struct {
+	Text string
+	Tags []string
+}
+
+func (f *jsonForm) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &f.Text)
+	}
+	var obj struct {
+		Form string   `json:"form"`
+		Text string   `json:"text"`
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	f.Text = obj.Form
+	if f.Text == "" {
+		f.Text = obj.Text
+	}
+	f.Tags = obj.Tags
+	return nil
+}
+
+// Wiktextract form tags that mark inflection table bookkeeping rather
+// than real word forms.
+var skipFormTags = map[string]bool{"table-tags": true, "inflection-template": true, "class": true}
+
+// aliases returns the alternative forms to index, without duplicates of
+// the headword.
+func (e *jsonEntry) aliases() []string {
+	seen := map[string]bool{e.Word: true}
+	var out []string
+	for _, list := range [][]jsonForm{e.Aliases, e.Forms} {
+	forms:
+		for _, f := range list {
+			for _, t := range f.Tags {
+				if skipFormTags[t] {
+					continue forms
+				}
+			}
+			s := strings.TrimSpace(f.Text)
+			if s == "" || s == "-" || seen[s] {
+				continue
+			}
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// isPlain reports whether the entry only has a word and a definition; such
+// entries keep their definition verbatim.
+func (e *jsonEntry) isPlain() bool {
+	return len(e.Senses) == 0 && e.POS == "" && e.Pronunciation == "" && len(e.Sounds) == 0 && e.Format == ""
+}
+
+// render builds the definition HTML. format "html" keeps text fields as
+// markup, "text" escapes them; it defaults to "html" for entries with a
+// definition and to "text" for sense-only entries.
+func (e *jsonEntry) render() string {
+	if e.isPlain() {
+		return strings.TrimSpace(e.Definition)
+	}
+	isHTML := strings.EqualFold(e.Format, "html") || (e.Format == "" && e.Definition != "")
+	text := func(s string) string {
+		s = strings.TrimSpace(s)
+		if isHTML {
+			return s
+		}
+		return html.EscapeString(s)
+	}
+
+	var b strings.Builder
+	if e.POS != "" {
+		b.WriteString(`<span class="json_pos">` + html.EscapeString(e.POS) + `</span> `)
+	}
+	pron := e.Pronunciation
+	if pron == "" {
+		var ipa []string
+		for _, s := range e.Sounds {
+			if s.IPA != "" && len(ipa) < 3 {
+				ipa = append(ipa, s.IPA)
+			}
+		}
+		pron = strings.Join(ipa, ", ")
+	}
+	if pron != "" {
+		b.WriteString(`<span class="json_pron">` + html.EscapeString(pron) + `</span>`)
+	}
+	if d := text(e.Definition); d != "" {
+		b.WriteString(`<div class="json_def">` + d + `</div>`)
+	}
+	writeSenses(&b, e.Senses, text)
+	return strings.TrimSpace(b.String())
+}
+
+func writeSenses(b *strings.Builder, senses []jsonSense, text func(string) string) {
+	if len(senses) == 0 {
+		return
+	}
+	b.WriteString(`<ol class="json_senses">`)
+	for _, s := range senses {
+		glosses := s.Glosses
+		if len(glosses) == 0 && s.Gloss != "" {
+			glosses = []string{s.Gloss}
+		}
+		if len(glosses) == 0 && len(s.Senses) == 0 {
+			continue
+		}
+		b.WriteString("<li>")
+		if len(s.Tags) > 0 {
+			b.WriteString(`<span class="json_tags">(` + html.EscapeString(strings.Join(s.Tags, ", ")) + `)</span> `)
+		}
+		parts := make([]string, 0, len(glosses))
+		for _, g := range glosses {
+			if g = text(g); g != "" {
+				parts = append(parts, g)
+			}
+		}
+		if len(parts) > 0 {
+			b.WriteString(`<span class="json_gloss">` + strings.Join(parts, "; ") + `</span>`)
+		}
+		for _, ex := range s.Examples {
+			if t := text(ex.Text); t != "" {
+				b.WriteString(`<div class="json_ex">` + t + `</div>`)
+			}
+		}
+		writeSenses(b, s.Senses, text)
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ol>")
+}
@@ -0,0 +1,59 @@
+package filedict
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewFromJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.jsonl")
+	data := `{"word":"run","pos":"verb","sounds":[{"ipa":"/ɹʌn/"}],` +
+		`"forms":[{"form":"ran","tags":["past"]},{"form":"en-verb","tags":["inflection-template"]}],` +
+		`"senses":[{"glosses":["To move swiftly."],"tags":["intransitive"],"examples":[{"text":"I <run> daily."}]}]}` + "\n" +
+		"\n" +
+		`{"word":"hi","definition":"<b>greeting</b>"}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load("t", "", path, "", "", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := d.Lookup("Ran")
+	if len(got) != 1 || got[0].Word != "run" || got[0].Synonym != "ran" {
+		t.Fatalf("Lookup(Ran) = %+v", got)
+	}
+	def := got[0].Definition
+	for _, want := range []string{`<span class="json_pos">verb</span>`, "/ɹʌn/", "(intransitive)", "To move swiftly.", "I &lt;run&gt; daily."} {
+		if !strings.Contains(def, want) {
+			t.Errorf("definition %q lacks %q", def, want)
+		}
+	}
+	if got := d.Lookup("en-verb"); len(got) != 0 {
+		t.Fatalf("template form indexed: %+v", got)
+	}
+	if got := d.Lookup("hi"); len(got) != 1 || got[0].Definition != "<b>greeting</b>" {
+		t.Fatalf("Lookup(hi) = %+v", got)
+	}
+}
+
+func TestNewFromJSONArray(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.json")
+	data := "\ufeff[{\"word\":\"a\",\"definition\":\"first\"},\n {\"word\":\"b\",\"definition\":\"second\",\"aliases\":[\"bee\"]}]"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load("t", "", path, "json", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Lookup("bee"); len(got) != 1 || got[0].Word != "b" || got[0].Definition != "second" {
+		t.Fatalf("Lookup(bee) = %+v", got)
+	}
+	if got := d.Lookup("a"); len(got) != 1 || got[0].Definition != "first" {
+		t.Fatalf("Lookup(a) = %+v", got)
+	}
+}
@@ -0,0 +1,237 @@
+package filedict
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema maps the columns of a delimited file to entry fields. Columns are
+// named by their header when Header is set, or by 1-based position ("1",
+// "2", ...). Template is an html/template rendered with a Row; column
+// values are escaped unless passed through safe.
+type Schema struct {
+	Header         bool
+	Headword       string
+	Aliases        string
+	AliasSeparator string
+	PartOfSpeech   string
+	Pronunciation  string
+	Definition     string
+	Example        string
+	Template       string
+}
+
+// Row is the data a schema template renders. Columns holds every column
+// of the record by name.
+type Row struct {
+	Headword      string
+	Aliases       []string
+	PartOfSpeech  string
+	Pronunciation string
+	Definition    string
+	Example       string
+	Columns       map[string]string
+}
+
+const defaultTemplate = `{{if .PartOfSpeech}}<span class="tsv_pos">{{.PartOfSpeech}}</span> {{end}}` +
+	`{{if .Pronunciation}}<span class="tsv_pron">[{{.Pronunciation}}]</span>{{end}}` +
+	`{{if .Definition}}<div class="tsv_def">{{.Definition}}</div>{{end}}` +
+	`{{if .Example}}<div class="tsv_ex">{{.Example}}</div>{{end}}`
+
+var templateFuncs = template.FuncMap{
+	"safe": func(s string) template.HTML { return template.HTML(s) },
+	"join": strings.Join,
+}
+
+// NewFromTable reads a delimited file with RFC 4180 quoting. With a nil
+// schema the first column is the word and the other columns, joined by
+// the delimiter, the definition.
+func NewFromTable(id, name, path, delimiter string, schema *Schema, caseFold bool) (*Dictionary, error) {
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+	if name == "" {
+		name = id
+	}
+	if delimiter == "" {
+		delimiter = "\t"
+	}
+	comma, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) {
+		return nil, fmt.Errorf("delimiter %q must be a single character", delimiter)
+	}
+	fingerprint, _ := json.Marshal(schema)
+	options := "table:" + delimiter + ":" + string(fingerprint)
+	if d, ok := fromCache(id, name, path, caseFold, options); ok {
+		return d, nil
+	}
+
+	var tmpl *template.Template
+	if schema != nil {
+		text := schema.Template
+		if text == "" {
+			text = defaultTemplate
+		}
+		var err error
+		if tmpl, err = template.New(id).Funcs(templateFuncs).Parse(text); err != nil {
+			return nil, fmt.Errorf("schema template: %w", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(bufio.NewReader(file))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	var (
+		b     = newBuilder(caseFold)
+		names []string
+		cols  columns
+	)
+	for first := true; ; first = false {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			rec[0] = strings.TrimPrefix(rec[0], "\ufeff")
+			if schema != nil && schema.Header {
+				names = make([]string, len(rec))
+				for i, h := range rec {
+					names[i] = strings.TrimSpace(h)
+				}
+			}
+			if schema != nil {
+				if cols, err = resolveColumns(schema, names); err != nil {
+					return nil, err
+				}
+			}
+			if schema != nil && schema.Header {
+				continue
+			}
+		}
+
+		if schema == nil {
+			if len(rec) < 2 {
+				continue
+			}
+			b.add(strings.TrimSpace(rec[0]), strings.TrimSpace(strings.Join(rec[1:], delimiter)), "")
+			continue
+		}
+
+		row := cols.row(rec, names, schema.AliasSeparator)
+		var def strings.Builder
+		if err := tmpl.Execute(&def, row); err != nil {
+			return nil, fmt.Errorf("schema template: %w", err)
+		}
+		text := strings.TrimSpace(def.String())
+		b.add(row.Headword, text, "")
+		for _, alias := range row.Aliases {
+			if normalize(alias, caseFold) != normalize(row.Headword, caseFold) {
+				b.add(alias, text, row.Headword)
+			}
+		}
+	}
+	return b.finish(id, name, path, options), nil
+}
+
+// columns holds the 0-based record index of each schema field, or -1.
+type columns struct {
+	headword, aliases, pos, pron, def, example int
+}
+
+func resolveColumns(s *Schema, names []string) (columns, error) {
+	find := func(field, ref string) (int, error) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return -1, nil
+		}
+		for i, n := range names {
+			if strings.EqualFold(n, ref) {
+				return i, nil
+			}
+		}
+		if n, err := strconv.Atoi(ref); err == nil && n > 0 {
+			return n - 1, nil
+		}
+		return -1, fmt.Errorf("schema %s: unknown column %q", field, ref)
+	}
+	var (
+		c   columns
+		err error
+	)
+	headword := s.Headword
+	if headword == "" {
+		headword = "1"
+	}
+	if c.headword, err = find("headword", headword); err != nil {
+		return c, err
+	}
+	if c.aliases, err = find("aliases", s.Aliases); err != nil {
+		return c, err
+	}
+	if c.pos, err = find("pos", s.PartOfSpeech); err != nil {
+		return c, err
+	}
+	if c.pron, err = find("pronunciation", s.Pronunciation); err != nil {
+		return c, err
+	}
+	if c.def, err = find("definition", s.Definition); err != nil {
+		return c, err
+	}
+	if c.example, err = find("example", s.Example); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func (c columns) row(rec, names []string, aliasSep string) Row {
+	get := func(i int) string {
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	row := Row{
+		Headword:      get(c.headword),
+		PartOfSpeech:  get(c.pos),
+		Pronunciation: get(c.pron),
+		Definition:    get(c.def),
+		Example:       get(c.example),
+		Columns:       make(map[string]string, len(rec)),
+	}
+	if aliasSep == "" {
+		aliasSep = "|"
+	}
+	for _, a := range strings.Split(get(c.aliases), aliasSep) {
+		if a = strings.TrimSpace(a); a != "" {
+			row.Aliases = append(row.Aliases, a)
+		}
+	}
+	for i := range rec {
+		key := strconv.Itoa(i + 1)
+		if i < len(names) && names[i] != "" {
+			key = names[i]
+		}
+		row.Columns[key] = get(i)
+	}
+	return row
+}
@@ -0,0 +1,34 @@
+package filedict
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFromTableSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.csv")
+	data := "word,forms,meaning\n" +
+		"run,runs|ran,\"to move fast, on foot\"\n" +
+		"\"say \"\"hi\"\"\",,<b>greet</b>\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schema := &Schema{Header: true, Headword: "word", Aliases: "forms", Definition: "meaning", Template: "{{.Definition}}"}
+	d, err := Load("t", "", path, "csv", "", schema, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := d.Lookup("ran")
+	if len(got) != 1 || got[0].Word != "run" || got[0].Synonym != "ran" || got[0].Definition != "to move fast, on foot" {
+		t.Fatalf("Lookup(ran) = %+v", got)
+	}
+	got = d.Lookup(`say "hi"`)
+	if len(got) != 1 || got[0].Definition != "&lt;b&gt;greet&lt;/b&gt;" {
+		t.Fatalf(`Lookup(say "hi") = %+v`, got)
+	}
+	if got := d.Lookup("word"); len(got) != 0 {
+		t.Fatalf("header row indexed: %+v", got)
+	}
+}
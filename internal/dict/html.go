@@ -145,6 +145,25 @@ func CleanResourceName(name string) string {
 	return strings.TrimPrefix(clean, "/")
 }
 
+// HasScheme reports whether a link points outside the dictionary: a full
+// URL or a mailto:, javascript:, data: or tel: link.
+func HasScheme(u string) bool {
+	lower := strings.ToLower(u)
+	for _, p := range []string{"mailto:", "javascript:", "data:", "tel:"} {
+		if strings.HasPrefix(lower, p) {
+			return true
+		}
+	}
+	return strings.Contains(lower, "://")
+}
+
+// MediaType returns the lower-cased media type of a content type, without
+// parameters: "text/html; charset=utf-8" -> "text/html".
+func MediaType(ct string) string {
+	t, _, _ := strings.Cut(ct, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
 func encodeResourcePath(name string) string {
 	parts := strings.Split(name, "/")
 	for i := range parts {
@@ -0,0 +1,249 @@
+package loader
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/sagerenn/mdict/internal/config"
+)
+
+// Skipped is a file in a dictionary directory that was not loaded.
+type Skipped struct {
+	Path   string
+	Reason string
+}
+
+// Discovered holds the dictionaries found in dictionary_dirs.
+type Discovered struct {
+	Dicts   []config.DictConfig
+	Skipped []Skipped
+	Errs    []error
+}
+
+// Discover walks dirs recursively and returns a config for every dictionary
+// file it recognises. Companion files of a set (.idx/.dict.dz/.syn next to
+// an .ifo, .mdd next to an .mdx, name_abrv.dsl, ...) and index caches are
+// not reported; other unusable files are listed in Skipped. Ids are derived
+// from the path relative to the directory, so they stay the same across
+// restarts; names come from the dictionary's own metadata. Files already
+// listed in configured are skipped.
+func Discover(dirs []config.DirConfig, configured []config.DictConfig) Discovered {
+	var res Discovered
+	ids := make(map[string]bool, len(configured))
+	paths := make(map[string]bool, len(configured))
+	for _, d := range configured {
+		ids[d.ID] = true
+		if abs, err := filepath.Abs(d.Path); err == nil {
+			paths[abs] = true
+		}
+	}
+
+	for _, dir := range dirs {
+		root := strings.TrimSpace(dir.Path)
+		if root == "" {
+			continue
+		}
+		files, err := walkDir(root)
+		if err != nil {
+			res.Errs = append(res.Errs, fmt.Errorf("scan %s: %w", root, err))
+			continue
+		}
+		set := make(map[string]bool, len(files))
+		for _, f := range files {
+			set[strings.ToLower(f)] = true
+		}
+		for _, path := range files {
+			typ, reason := classify(path, set)
+			if typ == "" {
+				if reason != "" {
+					res.Skipped = append(res.Skipped, Skipped{Path: path, Reason: reason})
+				}
+				continue
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				abs = path
+			}
+			if paths[abs] {
+				res.Skipped = append(res.Skipped, Skipped{Path: path, Reason: "already configured"})
+				continue
+			}
+			paths[abs] = true
+
+			id := discoveredID(root, path)
+			if ids[id] {
+				h := fnv.New32a()
+				_, _ = h.Write([]byte(abs))
+				id = fmt.Sprintf("%s-%08x", id, h.Sum32())
+			}
+			ids[id] = true
+			res.Dicts = append(res.Dicts, config.DictConfig{
+				ID:       id,
+				Name:     discoveredName(typ, path),
+				Type:     typ,
+				Path:     path,
+				CaseFold: dir.CaseFold,
+			})
+		}
+	}
+	return res
+}
+
+// walkDir lists the regular files under root in lexical order, leaving out
+// hidden entries and resource directories (name.files, name.dsl.files).
+func walkDir(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// Unreadable subdirectories are left out.
+			return nil
+		}
+		name := e.Name()
+		if path != root && strings.HasPrefix(name, ".") {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() {
+			if path != root && strings.HasSuffix(strings.ToLower(name), ".files") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		} else if !e.Type().IsRegular() {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	return files, err
+}
+
+// classify returns the dictionary type of path, or "" with the reason the
+// file is skipped. An empty reason means the file belongs to another
+// dictionary or is an index cache. files holds the lowercased paths of the
+// directory tree.
+func classify(path string, files map[string]bool) (string, string) {
+	lower := strings.ToLower(path)
+	has := func(p string) bool {
+		return files[strings.ToLower(p)]
+	}
+	trim := func(suffix string) string {
+		return path[:len(path)-len(suffix)]
+	}
+	hasSuffix := func(suffixes ...string) (string, bool) {
+		for _, s := range suffixes {
+			if strings.HasSuffix(lower, s) {
+				return s, true
+			}
+		}
+		return "", false
+	}
+
+	if strings.Contains(filepath.Base(lower), ".gdapi.") {
+		return "", ""
+	}
+	// StarDict: name.ifo with name.idx(.gz), name.dict(.dz), name.syn(.dz)
+	// and the res.rifo resource database.
+	if s, ok := hasSuffix(".idx", ".idx.gz", ".idx.dz", ".syn", ".syn.dz", ".dict", ".dict.dz"); ok && has(trim(s)+".ifo") {
+		return "", ""
+	}
+	if s, ok := hasSuffix(".dict", ".dict.dz"); ok {
+		if has(trim(s) + ".index") {
+			return "", ""
+		}
+		return "", "no .index or .ifo file for this .dict"
+	}
+	switch filepath.Base(lower) {
+	case "res.rifo", "res.ridx", "res.rdic", "res.rdic.dz":
+		return "", ""
+	}
+	// MDict: name.mdd and name.N.mdd next to name.mdx.
+	if strings.HasSuffix(lower, ".mdd") {
+		base := trim(".mdd")
+		if ext := filepath.Ext(base); ext != "" && strings.Trim(ext[1:], "0123456789") == "" {
+			base = strings.TrimSuffix(base, ext)
+		}
+		if has(base + ".mdx") {
+			return "", ""
+		}
+		return "", "no .mdx file for this .mdd"
+	}
+	// DSL: name_abrv.dsl(.dz), name.dsl.files.zip and name.dsl next to
+	// name.dsl.dz.
+	if s, ok := hasSuffix("_abrv.dsl", "_abrv.dsl.dz"); ok && has(trim(s)+s[len("_abrv"):]) {
+		return "", ""
+	}
+	if strings.HasSuffix(lower, ".files.zip") {
+		return "", ""
+	}
+	if strings.HasSuffix(lower, ".dsl") && has(path+".dz") {
+		return "", "also present as .dsl.dz"
+	}
+
+	typ := detectType(path)
+	switch {
+	case typ == "":
+		return "", "unknown format"
+	case strings.HasSuffix(lower, ".txt"):
+		// Plain text files are too often READMEs; list them explicitly.
+		return "", "plain .txt files are not auto-discovered"
+	}
+	return typ, ""
+}
+
+// discoveredName names plain data files after the file; the other formats
+// carry a title that their loader uses when the name is empty.
+func discoveredName(typ, path string) string {
+	switch typ {
+	case "tsv", "csv", "json", "jsonl":
+		base := filepath.Base(path)
+		return strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return ""
+}
+
+// discoveredID turns the path relative to root, without its dictionary
+// extensions, into an id such as "en/wordnet.index" -> "en-wordnet".
+func discoveredID(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	if strings.EqualFold(filepath.Ext(rel), ".dz") {
+		rel = rel[:len(rel)-3]
+	}
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(rel) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	id := strings.TrimSuffix(b.String(), "-")
+	if id == "" {
+		id = "dict"
+	}
+	return id
+}
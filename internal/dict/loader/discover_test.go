@@ -0,0 +1,68 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sagerenn/mdict/internal/config"
+)
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"en/wordnet.index", "en/wordnet.dict.dz",
+		"ru/Big Dict.ifo", "ru/Big Dict.idx.gz", "ru/Big Dict.dict.dz", "ru/Big Dict.syn",
+		"ru/Big Dict.ifo.gdapi.sdict.idx",
+		"oxford.mdx", "oxford.mdd", "oxford.1.mdd",
+		"lingvo.dsl.dz", "lingvo_abrv.dsl.dz", "lingvo.dsl.files.zip", "lingvo.dsl.files/a.wav",
+		"orphan.dict", "readme.txt", "cover.png", ".hidden/x.zim",
+		"words.csv", "listed.slob",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configured := []config.DictConfig{{ID: "oxford", Path: filepath.Join(root, "listed.slob")}}
+	got := Discover([]config.DirConfig{{Path: root, CaseFold: true}}, configured)
+	if len(got.Errs) > 0 {
+		t.Fatal(got.Errs)
+	}
+
+	var ids, types []string
+	for _, d := range got.Dicts {
+		ids = append(ids, d.ID)
+		types = append(types, d.Type)
+		if !d.CaseFold {
+			t.Errorf("%s: case_fold not inherited", d.ID)
+		}
+	}
+	// "oxford" is taken by a configured dictionary.
+	if len(ids) == 5 && strings.HasPrefix(ids[2], "oxford-") && len(ids[2]) == len("oxford-")+8 {
+		ids[2] = "oxford-*"
+	}
+	wantIDs := []string{"en-wordnet", "lingvo", "oxford-*", "ru-big-dict", "words"}
+	if !reflect.DeepEqual(ids, wantIDs) {
+		t.Errorf("ids = %q, want %q", ids, wantIDs)
+	}
+	if want := []string{"dictd", "dsl", "mdict", "stardict", "csv"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("types = %q, want %q", types, want)
+	}
+
+	skipped := make(map[string]bool)
+	for _, s := range got.Skipped {
+		rel, _ := filepath.Rel(root, s.Path)
+		skipped[filepath.ToSlash(rel)] = true
+	}
+	want := map[string]bool{"orphan.dict": true, "readme.txt": true, "cover.png": true, "listed.slob": true}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
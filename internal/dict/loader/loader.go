@@ -2,67 +2,158 @@ package loader
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/sagerenn/mdict/internal/config"
 	"github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/dict/bgl"
+	"github.com/sagerenn/mdict/internal/dict/dictd"
 	"github.com/sagerenn/mdict/internal/dict/dsl"
 	"github.com/sagerenn/mdict/internal/dict/filedict"
 	"github.com/sagerenn/mdict/internal/dict/mdict"
+	"github.com/sagerenn/mdict/internal/dict/slob"
 	"github.com/sagerenn/mdict/internal/dict/stardict"
+	"github.com/sagerenn/mdict/internal/dict/xdxf"
+	"github.com/sagerenn/mdict/internal/dict/zim"
 )
 
 type Result struct {
 	Dicts []dict.Dictionary
 	Errs  []error
+	// Skipped lists files found in dictionary_dirs that were not loaded.
+	Skipped []Skipped
 }
 
 func LoadAll(cfg config.Config) Result {
+	found := Discover(cfg.DictionaryDirs, cfg.Dictionaries)
+	all := make([]config.DictConfig, 0, len(cfg.Dictionaries)+len(found.Dicts))
+	all = append(all, cfg.Dictionaries...)
+	all = append(all, found.Dicts...)
+
 	res := Result{
-		Dicts: make([]dict.Dictionary, 0, len(cfg.Dictionaries)),
-		Errs:  nil,
+		Dicts:   make([]dict.Dictionary, 0, len(all)),
+		Errs:    found.Errs,
+		Skipped: found.Skipped,
 	}
-	for _, d := range cfg.Dictionaries {
-		if strings.TrimSpace(d.Path) == "" {
-			res.Errs = append(res.Errs, fmt.Errorf("dictionary %q missing path", d.ID))
-			continue
-		}
-		if strings.TrimSpace(d.ID) == "" || strings.TrimSpace(d.Name) == "" {
-			res.Errs = append(res.Errs, fmt.Errorf("dictionary entry missing id/name for path %q", d.Path))
+	for _, d := range all {
+		loaded, err := Load(d)
+		if err != nil {
+			res.Errs = append(res.Errs, err)
 			continue
 		}
-		typ := strings.ToLower(strings.TrimSpace(d.Type))
-		if typ == "" {
-			typ = detectType(d.Path)
+		res.Dicts = append(res.Dicts, loaded)
+	}
+	return res
+}
+
+// Load opens the dictionary described by d.
+func Load(d config.DictConfig) (dict.Dictionary, error) {
+	if strings.TrimSpace(d.Path) == "" {
+		return nil, fmt.Errorf("dictionary %q missing path", d.ID)
+	}
+	if strings.TrimSpace(d.ID) == "" {
+		return nil, fmt.Errorf("dictionary entry missing id for path %q", d.Path)
+	}
+	typ := dictType(d)
+	var (
+		loaded dict.Dictionary
+		err    error
+	)
+	switch typ {
+	case "tsv", "tab", "txt", "csv", "json", "jsonl", "ndjson":
+		loaded, err = filedict.Load(d.ID, d.Name, d.Path, typ, d.Delimiter, tableSchema(d.Schema), d.CaseFold)
+	case "dsl":
+		loaded, err = dsl.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "stardict", "ifo":
+		loaded, err = stardict.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "bgl":
+		loaded, err = bgl.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "xdxf":
+		loaded, err = xdxf.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "dictd":
+		loaded, err = dictd.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "slob":
+		loaded, err = slob.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "zim":
+		loaded, err = zim.Load(d.ID, d.Name, d.Path, d.CaseFold)
+	case "mdict", "mdx":
+		loaded, err = mdict.Load(d.ID, d.Name, d.Path, d.CaseFold, d.RegCode, d.Email, d.DeviceID)
+	default:
+		err = fmt.Errorf("unsupported dictionary type: %q", typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", d.ID, err)
+	}
+	return loaded, nil
+}
+
+// SourceFiles returns the files Load reads for d: the configured path and
+// the companion files of its format that exist (StarDict .idx/.dict/.syn,
+// MDict .mdd volumes, DSL abbreviations and resource archives, dictd data
+// files).
+func SourceFiles(d config.DictConfig) []string {
+	switch dictType(d) {
+	case "dsl":
+		return dsl.SourceFiles(d.Path)
+	case "stardict", "ifo":
+		return stardict.SourceFiles(d.Path)
+	case "dictd":
+		return dictd.SourceFiles(d.Path)
+	case "mdict", "mdx":
+		return mdict.SourceFiles(d.Path)
+	default:
+		return []string{d.Path}
+	}
+}
+
+func dictType(d config.DictConfig) string {
+	if typ := strings.ToLower(strings.TrimSpace(d.Type)); typ != "" {
+		return typ
+	}
+	return detectType(d.Path)
+}
+
+// RemoveCaches deletes the index caches of d (name.gdapi*.idx next to the
+// source), so the next Load rebuilds them.
+func RemoveCaches(d config.DictConfig) error {
+	paths := []string{d.Path}
+	lower := strings.ToLower(d.Path)
+	for _, ext := range []string{".dict.dz", ".dict"} {
+		if strings.HasSuffix(lower, ext) {
+			// dictd caches sit next to the .index file.
+			paths = append(paths, d.Path[:len(d.Path)-len(ext)]+".index")
+			break
 		}
-		var (
-			loaded dict.Dictionary
-			err    error
-		)
-		switch typ {
-		case "tsv", "tab", "txt", "json":
-			loaded, err = filedict.Load(d.ID, d.Name, d.Path, typ, d.Delimiter, d.CaseFold)
-		case "dsl":
-			loaded, err = dsl.Load(d.ID, d.Name, d.Path, d.CaseFold)
-		case "stardict", "ifo":
-			loaded, err = stardict.Load(d.ID, d.Name, d.Path, d.CaseFold)
-		case "mdict", "mdx":
-			loaded, err = mdict.Load(d.ID, d.Name, d.Path, d.CaseFold)
-		default:
-			err = fmt.Errorf("unsupported dictionary type: %q", typ)
+	}
+	for _, p := range paths {
+		dir, base := filepath.Split(p)
+		if dir == "" {
+			dir = "."
 		}
+		entries, err := os.ReadDir(dir)
 		if err != nil {
-			res.Errs = append(res.Errs, fmt.Errorf("load %s: %w", d.ID, err))
-			continue
+			return err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if strings.HasPrefix(name, base+".gdapi") && strings.HasSuffix(name, ".idx") {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+			}
 		}
-		res.Dicts = append(res.Dicts, loaded)
 	}
-	return res
+	return nil
 }
 
 func detectType(path string) string {
 	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".dz" {
+		// Compressed sources are typed by the inner extension (name.dsl.dz).
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path))))
+	}
 	switch ext {
 	case ".ifo":
 		return "stardict"
@@ -70,11 +161,42 @@ func detectType(path string) string {
 		return "mdict"
 	case ".dsl":
 		return "dsl"
+	case ".bgl":
+		return "bgl"
+	case ".xdxf":
+		return "xdxf"
+	case ".index", ".dict":
+		return "dictd"
+	case ".slob":
+		return "slob"
+	case ".zim":
+		return "zim"
 	case ".json":
 		return "json"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
 	case ".tsv", ".txt":
 		return "tsv"
+	case ".csv":
+		return "csv"
 	default:
 		return ""
 	}
 }
+
+func tableSchema(s *config.SchemaConfig) *filedict.Schema {
+	if s == nil {
+		return nil
+	}
+	return &filedict.Schema{
+		Header:         s.Header,
+		Headword:       s.Headword,
+		Aliases:        s.Aliases,
+		AliasSeparator: s.AliasSeparator,
+		PartOfSpeech:   s.PartOfSpeech,
+		Pronunciation:  s.Pronunciation,
+		Definition:     s.Definition,
+		Example:        s.Example,
+		Template:       s.Template,
+	}
+}
@@ -7,11 +7,12 @@ import (
 	"path/filepath"
 )
 
-const cacheVersion = 1
+const cacheVersion = 3
 
 type cacheIndex struct {
 	Version       int
 	CaseFold      bool
+	StripKey      bool
 	SourcePath    string
 	SourceSize    int64
 	SourceMtime   int64
@@ -23,14 +24,20 @@ type cacheIndex struct {
 
 type wordEntry struct {
 	Word    string
-	Offsets []int
+	Records []recordSpan
+}
+
+// recordSpan locates a record in the decompressed record stream.
+type recordSpan struct {
+	Offset int64
+	Size   int64
 }
 
 func cachePath(path string) string {
 	return path + ".gdapi.mdx.idx"
 }
 
-func loadCache(path string, caseFold bool) (*cacheIndex, bool, error) {
+func loadCache(path string, keys keyNormalizer) (*cacheIndex, bool, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil, false, err
@@ -49,7 +56,7 @@ func loadCache(path string, caseFold bool) (*cacheIndex, bool, error) {
 	if err := dec.Decode(&idx); err != nil {
 		return nil, false, err
 	}
-	if idx.Version != cacheVersion || idx.CaseFold != caseFold {
+	if idx.Version != cacheVersion || idx.CaseFold != keys.Fold || idx.StripKey != keys.Strip {
 		return nil, false, nil
 	}
 	if filepath.Clean(idx.SourcePath) != filepath.Clean(path) || idx.SourceSize != info.Size() || idx.SourceMtime != info.ModTime().UnixNano() {
@@ -58,14 +65,15 @@ func loadCache(path string, caseFold bool) (*cacheIndex, bool, error) {
 	return &idx, true, nil
 }
 
-func saveCache(path string, caseFold bool, entries []wordEntry, norm map[string][]int, sortedN, sortedW []string) error {
+func saveCache(path string, keys keyNormalizer, entries []wordEntry, norm map[string][]int, sortedN, sortedW []string) error {
 	info, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
 	idx := cacheIndex{
 		Version:       cacheVersion,
-		CaseFold:      caseFold,
+		CaseFold:      keys.Fold,
+		StripKey:      keys.Strip,
 		SourcePath:    filepath.Clean(path),
 		SourceSize:    info.Size(),
 		SourceMtime:   info.ModTime().UnixNano(),
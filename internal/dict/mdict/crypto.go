@@ -0,0 +1,121 @@
+package mdict
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/C0MM4ND/go-ripemd"
+	"github.com/cespare/xxhash/v2"
+)
+
+func ripemd128(data []byte) []byte {
+	h := ripemd.New128()
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
+
+// fastDecrypt reverses MDict's nibble-swap/XOR block cipher in place.
+func fastDecrypt(data, key []byte) {
+	prev := byte(0x36)
+	for i, b := range data {
+		t := b>>4 | b<<4
+		data[i] = t ^ prev ^ byte(i) ^ key[i%len(key)]
+		prev = b
+	}
+}
+
+// keyInfoKey derives the key of an encrypted (Encrypted & 2) key block info
+// section from the block checksum.
+func keyInfoKey(checksum []byte) []byte {
+	buf := make([]byte, 0, 8)
+	buf = append(buf, checksum...)
+	buf = binary.LittleEndian.AppendUint32(buf, 0x3695)
+	return ripemd128(buf)
+}
+
+// uuidKey derives the block key of engine 3.0 files from the header UUID.
+func uuidKey(uuid string) []byte {
+	mid := (len(uuid) + 1) / 2
+	key := make([]byte, 0, 16)
+	key = binary.BigEndian.AppendUint64(key, xxhash.Sum64String(uuid[:mid]))
+	key = binary.BigEndian.AppendUint64(key, xxhash.Sum64String(uuid[mid:]))
+	return key
+}
+
+// salsa20 XORs data with the Salsa20 key stream (zero nonce) using the given
+// number of rounds. MDict uses Salsa20/8 with 16 byte keys.
+func salsa20(data, key []byte, rounds int) []byte {
+	var state [16]uint32
+	constants := [4]uint32{0x61707865, 0x3120646e, 0x79622d36, 0x6b206574} // "expand 16-byte k"
+	k2 := key
+	if len(key) == 32 {
+		constants = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574} // "expand 32-byte k"
+		k2 = key[16:]
+	}
+	state[0], state[5], state[10], state[15] = constants[0], constants[1], constants[2], constants[3]
+	for i := 0; i < 4; i++ {
+		state[1+i] = binary.LittleEndian.Uint32(key[i*4:])
+		state[11+i] = binary.LittleEndian.Uint32(k2[i*4:])
+	}
+
+	out := make([]byte, len(data))
+	var block [64]byte
+	var counter uint64
+	for pos := 0; pos < len(data); pos += 64 {
+		state[8] = uint32(counter)
+		state[9] = uint32(counter >> 32)
+		salsaBlock(&block, &state, rounds)
+		for i := 0; i < 64 && pos+i < len(data); i++ {
+			out[pos+i] = data[pos+i] ^ block[i]
+		}
+		counter++
+	}
+	return out
+}
+
+func salsaBlock(out *[64]byte, in *[16]uint32, rounds int) {
+	x := *in
+	qr := func(a, b, c, d int) {
+		x[b] ^= bits.RotateLeft32(x[a]+x[d], 7)
+		x[c] ^= bits.RotateLeft32(x[b]+x[a], 9)
+		x[d] ^= bits.RotateLeft32(x[c]+x[b], 13)
+		x[a] ^= bits.RotateLeft32(x[d]+x[c], 18)
+	}
+	for i := 0; i < rounds; i += 2 {
+		qr(0, 4, 8, 12)
+		qr(5, 9, 13, 1)
+		qr(10, 14, 2, 6)
+		qr(15, 3, 7, 11)
+		qr(0, 1, 2, 3)
+		qr(5, 6, 7, 4)
+		qr(10, 11, 8, 9)
+		qr(15, 12, 13, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+in[i])
+	}
+}
+
+// registrationKey derives the keyword header key of an Encrypted & 1 file
+// from the hex registration code and the email or device ID it was issued
+// to. Emails are hashed as UTF-16LE, device IDs as raw bytes; a device ID
+// takes precedence when both are set.
+func registrationKey(regcode, email, deviceID string) ([]byte, error) {
+	code, err := hex.DecodeString(strings.TrimSpace(regcode))
+	if err != nil {
+		return nil, fmt.Errorf("mdict: invalid registration code: %w", err)
+	}
+	var id []byte
+	if deviceID = strings.TrimSpace(deviceID); deviceID != "" {
+		id = []byte(deviceID)
+	} else {
+		for _, u := range utf16.Encode([]rune(strings.TrimSpace(email))) {
+			id = binary.LittleEndian.AppendUint16(id, u)
+		}
+	}
+	return salsa20(code, ripemd128(id), 8), nil
+}
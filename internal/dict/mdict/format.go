@@ -0,0 +1,592 @@
+package mdict
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/sagerenn/mdict/internal/cache"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// Block type markers of the engine 3.0 layout.
+const (
+	v3RecordData = 0x01000000
+	v3RecordInfo = 0x02000000
+	v3KeyData    = 0x03000000
+	v3KeyInfo    = 0x04000000
+)
+
+// maxBlockSize guards against allocating for corrupt block sizes.
+const maxBlockSize = 1 << 30
+
+var errEncrypted = errors.New("keyword header is encrypted; set regcode and email for this dictionary")
+
+// mdictFile gives block-level random access to an MDX or MDD file. It keeps
+// only the block tables; key and record blocks are read from disk and
+// decompressed on demand. Dictionary still scans every key block once to
+// build its in-memory key index.
+type mdictFile struct {
+	path    string
+	file    *os.File
+	header  *header
+	version float64
+	encrypt int
+	mdd     bool
+
+	numWidth int
+	utf16    bool
+	text     encoding.Encoding
+	blockKey []byte
+	regKey   []byte
+
+	keyBlocks    []blockInfo
+	recordBlocks []blockInfo
+	recordSize   int64
+
+	blocks *cache.Cache
+}
+
+// blockInfo locates one compressed block. decompOff is the position of its
+// data in the concatenated decompressed stream.
+type blockInfo struct {
+	offset     int64
+	compSize   int64
+	decompSize int64
+	decompOff  int64
+}
+
+// keyRecord is a key and the span of its record in the decompressed record
+// stream.
+type keyRecord struct {
+	key    string
+	offset int64
+	size   int64
+}
+
+// openMDictFile opens an MDX (or MDD when mdd is set) file. regKey is the
+// registration key for files whose keyword header is encrypted, or nil.
+func openMDictFile(path string, mdd bool, regKey []byte) (*mdictFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &mdictFile{path: path, file: f, mdd: mdd, regKey: regKey, blocks: cache.New(16, 0)}
+	if err := m.readLayout(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("mdict: %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *mdictFile) Close() error {
+	return m.file.Close()
+}
+
+func (m *mdictFile) readLayout() error {
+	r := &offsetReader{r: m.file}
+	hdr, _, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	m.header = hdr
+	m.version, _ = strconv.ParseFloat(hdr.get("GeneratedByEngineVersion"), 64)
+	m.encrypt = parseEncrypted(hdr.get("Encrypted"))
+	m.numWidth = 4
+	if m.version >= 2 {
+		m.numWidth = 8
+	}
+	m.setEncoding()
+
+	if m.version >= 3 {
+		if uuid := hdr.get("UUID"); uuid != "" {
+			m.blockKey = uuidKey(uuid)
+		}
+		return m.readLayoutV3(r)
+	}
+	if err := m.readKeySection(r); err != nil {
+		return fmt.Errorf("key section: %w", err)
+	}
+	if err := m.readRecordSection(r); err != nil {
+		return fmt.Errorf("record section: %w", err)
+	}
+	return nil
+}
+
+func parseEncrypted(v string) int {
+	switch strings.ToLower(v) {
+	case "", "no":
+		return 0
+	case "yes":
+		return 1
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+func (m *mdictFile) setEncoding() {
+	if m.version >= 3 {
+		return
+	}
+	if m.mdd {
+		m.utf16 = true
+		return
+	}
+	name := strings.ToUpper(m.header.get("Encoding"))
+	switch name {
+	case "", "UTF-8", "UTF8":
+	case "UTF-16", "UTF-16LE":
+		m.utf16 = true
+	case "GBK", "GB2312", "GB18030":
+		m.text = simplifiedchinese.GB18030
+	default:
+		if enc, err := htmlindex.Get(name); err == nil {
+			m.text = enc
+		}
+	}
+}
+
+// readKeySection reads the engine 1.2/2.0 keyword header and key block info
+// table, leaving r at the start of the record section.
+func (m *mdictFile) readKeySection(r *offsetReader) error {
+	fields := 4
+	if m.version >= 2 {
+		fields = 5
+	}
+	head := make([]byte, fields*m.numWidth)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	if m.version >= 2 {
+		var sum [4]byte
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return err
+		}
+	}
+	if m.encrypt&1 != 0 {
+		if m.regKey == nil {
+			return errEncrypted
+		}
+		head = salsa20(head, m.regKey, 8)
+	}
+	nums := m.readNums(head, fields)
+	numBlocks := nums[0]
+	var infoDecompSize, infoSize, blocksSize int64
+	if m.version >= 2 {
+		infoDecompSize, infoSize, blocksSize = nums[2], nums[3], nums[4]
+	} else {
+		infoSize, blocksSize = nums[2], nums[3]
+	}
+	if infoSize < 0 || infoSize > maxBlockSize || numBlocks < 0 {
+		return errors.New("invalid key block info size")
+	}
+
+	info := make([]byte, infoSize)
+	if _, err := io.ReadFull(r, info); err != nil {
+		return err
+	}
+	if m.version >= 2 {
+		if len(info) < 8 {
+			return errors.New("short key block info")
+		}
+		if m.encrypt&2 != 0 {
+			fastDecrypt(info[8:], keyInfoKey(info[4:8]))
+		}
+		var err error
+		if info, err = m.decodeBlock(info, infoDecompSize); err != nil {
+			return err
+		}
+	}
+
+	blocks, err := m.parseKeyBlockInfo(info, int(numBlocks), r.off)
+	if err != nil {
+		return err
+	}
+	m.keyBlocks = blocks
+	_, err = r.Seek(blocksSize, io.SeekCurrent)
+	return err
+}
+
+func (m *mdictFile) parseKeyBlockInfo(info []byte, count int, offset int64) ([]blockInfo, error) {
+	sizeWidth, term := 1, 0
+	if m.version >= 2 {
+		sizeWidth, term = 2, 1
+	}
+	unit := 1
+	if m.utf16 {
+		unit = 2
+	}
+	p := 0
+	need := func(n int) bool { return p+n <= len(info) }
+	readSize := func() int {
+		if sizeWidth == 2 {
+			v := int(binary.BigEndian.Uint16(info[p:]))
+			p += 2
+			return v
+		}
+		v := int(info[p])
+		p++
+		return v
+	}
+
+	out := make([]blockInfo, 0, count)
+	for i := 0; i < count; i++ {
+		if !need(m.numWidth + sizeWidth) {
+			return nil, errors.New("truncated key block info")
+		}
+		p += m.numWidth // entry count
+		// First and last keys of the block. Lookups go through the full
+		// key index, so they are skipped.
+		for j := 0; j < 2; j++ {
+			if !need(sizeWidth) {
+				return nil, errors.New("truncated key block info")
+			}
+			p += (readSize() + term) * unit
+		}
+		if !need(2 * m.numWidth) {
+			return nil, errors.New("truncated key block info")
+		}
+		comp := m.readNum(info[p:])
+		decomp := m.readNum(info[p+m.numWidth:])
+		p += 2 * m.numWidth
+		out = append(out, blockInfo{offset: offset, compSize: comp, decompSize: decomp})
+		offset += comp
+	}
+	return out, nil
+}
+
+// readRecordSection reads the engine 1.2/2.0 record block table.
+func (m *mdictFile) readRecordSection(r *offsetReader) error {
+	head := make([]byte, 4*m.numWidth)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	nums := m.readNums(head, 4)
+	numBlocks, infoSize := nums[0], nums[2]
+	if infoSize < 0 || infoSize > maxBlockSize || numBlocks < 0 || numBlocks*2*int64(m.numWidth) > infoSize {
+		return errors.New("invalid record block info size")
+	}
+	info := make([]byte, infoSize)
+	if _, err := io.ReadFull(r, info); err != nil {
+		return err
+	}
+	offset := r.off
+	var decompOff int64
+	m.recordBlocks = make([]blockInfo, 0, numBlocks)
+	for i := int64(0); i < numBlocks; i++ {
+		p := int(i) * 2 * m.numWidth
+		comp := m.readNum(info[p:])
+		decomp := m.readNum(info[p+m.numWidth:])
+		m.recordBlocks = append(m.recordBlocks, blockInfo{offset: offset, compSize: comp, decompSize: decomp, decompOff: decompOff})
+		offset += comp
+		decompOff += decomp
+	}
+	m.recordSize = decompOff
+	return nil
+}
+
+// readLayoutV3 walks the typed sections of an engine 3.0 file and builds the
+// block tables from the size prefixes of their blocks.
+func (m *mdictFile) readLayoutV3(r *offsetReader) error {
+	var keyData, recordData int64 = -1, -1
+	var head [12]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		kind := binary.BigEndian.Uint32(head[:4])
+		size := int64(binary.BigEndian.Uint64(head[4:]))
+		switch kind {
+		case v3RecordData:
+			recordData = r.off
+		case v3KeyData:
+			keyData = r.off
+		case v3RecordInfo, v3KeyInfo:
+		default:
+			return fmt.Errorf("unknown section type %#x", kind)
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	if keyData < 0 || recordData < 0 {
+		return errors.New("missing key or record data section")
+	}
+	var err error
+	if m.keyBlocks, err = m.readBlocksV3(keyData); err != nil {
+		return fmt.Errorf("key data: %w", err)
+	}
+	if m.recordBlocks, err = m.readBlocksV3(recordData); err != nil {
+		return fmt.Errorf("record data: %w", err)
+	}
+	if n := len(m.recordBlocks); n > 0 {
+		last := m.recordBlocks[n-1]
+		m.recordSize = last.decompOff + last.decompSize
+	}
+	return nil
+}
+
+func (m *mdictFile) readBlocksV3(start int64) ([]blockInfo, error) {
+	var head [12]byte
+	if _, err := m.file.ReadAt(head[:], start); err != nil {
+		return nil, err
+	}
+	count := binary.BigEndian.Uint32(head[:4])
+	offset := start + 12
+	out := make([]blockInfo, 0, count)
+	var decompOff int64
+	var sizes [8]byte
+	for i := uint32(0); i < count; i++ {
+		if _, err := m.file.ReadAt(sizes[:], offset); err != nil {
+			return nil, err
+		}
+		decomp := int64(binary.BigEndian.Uint32(sizes[:4]))
+		comp := int64(binary.BigEndian.Uint32(sizes[4:]))
+		out = append(out, blockInfo{offset: offset + 8, compSize: comp, decompSize: decomp, decompOff: decompOff})
+		offset += 8 + comp
+		decompOff += decomp
+	}
+	return out, nil
+}
+
+func (m *mdictFile) readNum(b []byte) int64 {
+	if m.numWidth == 8 {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return int64(binary.BigEndian.Uint32(b))
+}
+
+func (m *mdictFile) readNums(b []byte, n int) []int64 {
+	out := make([]int64, n)
+	for i := range out {
+		out[i] = m.readNum(b[i*m.numWidth:])
+	}
+	return out
+}
+
+// readBlock reads and decodes the block described by b.
+func (m *mdictFile) readBlock(b blockInfo) ([]byte, error) {
+	if b.compSize < 8 || b.compSize > maxBlockSize || b.decompSize < 0 || b.decompSize > maxBlockSize {
+		return nil, errors.New("mdict: invalid block size")
+	}
+	raw := make([]byte, b.compSize)
+	if _, err := m.file.ReadAt(raw, b.offset); err != nil {
+		return nil, err
+	}
+	return m.decodeBlock(raw, b.decompSize)
+}
+
+// decodeBlock decrypts and decompresses a block. Its first four bytes hold
+// the compression type (low nibble), encryption method (next nibble) and
+// encrypted length (second byte); the next four an Adler-32 checksum.
+func (m *mdictFile) decodeBlock(raw []byte, size int64) ([]byte, error) {
+	if len(raw) < 8 {
+		return nil, errors.New("mdict: short block")
+	}
+	info := binary.LittleEndian.Uint32(raw[:4])
+	checksum := raw[4:8]
+	data := raw[8:]
+
+	if method := (info >> 4) & 0xf; method != 0 {
+		key := m.blockKey
+		if key == nil {
+			key = ripemd128(checksum)
+		}
+		n := min(int(info>>8&0xff), len(data))
+		switch method {
+		case 1:
+			data = append([]byte(nil), data...)
+			fastDecrypt(data[:n], key)
+		case 2:
+			data = append(salsa20(data[:n], key, 8), data[n:]...)
+		default:
+			return nil, fmt.Errorf("mdict: unsupported block encryption %d", method)
+		}
+	}
+
+	var out []byte
+	switch info & 0xf {
+	case 0:
+		out = data
+	case 1:
+		var err error
+		if out, err = lzo1xDecompress(data, int(size)); err != nil {
+			return nil, err
+		}
+	case 2:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		out = make([]byte, 0, size)
+		buf := bytes.NewBuffer(out)
+		_, err = io.Copy(buf, zr)
+		_ = zr.Close()
+		if err != nil {
+			return nil, err
+		}
+		out = buf.Bytes()
+	default:
+		return nil, fmt.Errorf("mdict: unknown block compression %d", info&0xf)
+	}
+	if int64(len(out)) != size {
+		return nil, errors.New("mdict: decompressed block size mismatch")
+	}
+	if m.version < 3 && adler32.Checksum(out) != binary.BigEndian.Uint32(checksum) {
+		return nil, errors.New("mdict: block checksum mismatch")
+	}
+	return out, nil
+}
+
+// keys scans every key block and returns the keys with the span of their
+// records. Only one key block is held in memory at a time.
+func (m *mdictFile) keys() ([]keyRecord, error) {
+	var out []keyRecord
+	for _, b := range m.keyBlocks {
+		data, err := m.readBlock(b)
+		if err != nil {
+			return nil, err
+		}
+		out, err = m.splitKeyBlock(data, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A record runs up to the next distinct record offset.
+	offsets := make([]int64, 0, len(out))
+	for _, k := range out {
+		offsets = append(offsets, k.offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for i := range out {
+		j := sort.Search(len(offsets), func(j int) bool { return offsets[j] > out[i].offset })
+		end := m.recordSize
+		if j < len(offsets) {
+			end = offsets[j]
+		}
+		out[i].size = end - out[i].offset
+	}
+	return out, nil
+}
+
+func (m *mdictFile) splitKeyBlock(data []byte, out []keyRecord) ([]keyRecord, error) {
+	unit := 1
+	if m.utf16 {
+		unit = 2
+	}
+	p := 0
+	for p < len(data) {
+		if p+m.numWidth > len(data) {
+			return nil, errors.New("mdict: truncated key block")
+		}
+		offset := m.readNum(data[p:])
+		p += m.numWidth
+		end := p
+		for end+unit <= len(data) && !isZero(data[end:end+unit]) {
+			end += unit
+		}
+		out = append(out, keyRecord{key: m.decodeText(data[p:end]), offset: offset})
+		p = end + unit
+	}
+	return out, nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeText converts key or record bytes to UTF-8.
+func (m *mdictFile) decodeText(b []byte) string {
+	switch {
+	case m.utf16:
+		u16 := make([]uint16, len(b)/2)
+		for i := range u16 {
+			u16[i] = binary.LittleEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(u16))
+	case m.text != nil:
+		if out, err := m.text.NewDecoder().Bytes(b); err == nil {
+			return string(out)
+		}
+	}
+	return string(b)
+}
+
+// record returns size bytes of the decompressed record stream at offset,
+// decoding only the record blocks that hold them.
+func (m *mdictFile) record(offset, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > m.recordSize {
+		return nil, errors.New("mdict: record out of range")
+	}
+	i := sort.Search(len(m.recordBlocks), func(i int) bool {
+		b := m.recordBlocks[i]
+		return b.decompOff+b.decompSize > offset
+	})
+	out := make([]byte, 0, size)
+	for ; i < len(m.recordBlocks) && int64(len(out)) < size; i++ {
+		data, err := m.recordBlock(i)
+		if err != nil {
+			return nil, err
+		}
+		start := offset + int64(len(out)) - m.recordBlocks[i].decompOff
+		end := min(int64(len(data)), start+size-int64(len(out)))
+		out = append(out, data[start:end]...)
+	}
+	if int64(len(out)) != size {
+		return nil, errors.New("mdict: truncated record")
+	}
+	return out, nil
+}
+
+func (m *mdictFile) recordBlock(i int) ([]byte, error) {
+	key := strconv.Itoa(i)
+	if v, ok := m.blocks.Get(key); ok {
+		return v.([]byte), nil
+	}
+	data, err := m.readBlock(m.recordBlocks[i])
+	if err != nil {
+		return nil, err
+	}
+	m.blocks.Set(key, data)
+	return data, nil
+}
+
+// offsetReader tracks the file position while reading the block tables.
+type offsetReader struct {
+	r   io.ReadSeeker
+	off int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	off, err := o.r.Seek(offset, whence)
+	if err == nil {
+		o.off = off
+	}
+	return off, err
+}
@@ -0,0 +1,416 @@
+package mdict
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testEntry is a key and its record in a generated MDX/MDD file.
+type testEntry struct {
+	key    string
+	record []byte
+}
+
+// testFile describes an MDX or MDD file for writeTestFile.
+type testFile struct {
+	version  string // GeneratedByEngineVersion: "1.2", "2.0" or "3.0"
+	mdd      bool
+	encoding string // MDX Encoding attribute
+	encrypt  int    // Encrypted attribute
+	regKey   []byte // keyword header key for encrypt&1
+	uuid     string // engine 3.0 block key source
+	attrs    string // extra header attributes
+	compress uint32 // block compression: 0 none, 1 lzo, 2 zlib
+	blockEnc uint32 // record block encryption: 0, 1 (fast) or 2 (salsa20)
+	keysPer  int    // keys per key block
+	recSize  int    // bytes per record block
+}
+
+func (f testFile) v2() bool { return f.version != "1.2" }
+func (f testFile) v3() bool { return f.version == "3.0" }
+
+func (f testFile) numWidth() int {
+	if f.v2() {
+		return 8
+	}
+	return 4
+}
+
+// utf16 reports whether keys (and MDX records) are UTF-16LE.
+func (f testFile) utf16() bool {
+	if f.v3() {
+		return false
+	}
+	return f.mdd || f.encoding == "UTF-16"
+}
+
+func (f testFile) text(s string) []byte {
+	if !f.utf16() {
+		return []byte(s)
+	}
+	return utf16LE(s)
+}
+
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func (f testFile) num(b []byte, v int) []byte {
+	if f.numWidth() == 8 {
+		return binary.BigEndian.AppendUint64(b, uint64(v))
+	}
+	return binary.BigEndian.AppendUint32(b, uint32(v))
+}
+
+// fastEncrypt is the inverse of fastDecrypt.
+func fastEncrypt(data, key []byte) {
+	prev := byte(0x36)
+	for i, p := range data {
+		t := p ^ prev ^ byte(i) ^ key[i%len(key)]
+		data[i] = t>>4 | t<<4
+		prev = data[i]
+	}
+}
+
+// encodeBlock compresses and optionally encrypts data into a block with
+// its 8 byte type/checksum prefix.
+func (f testFile) encodeBlock(t *testing.T, data []byte, comp, enc uint32) []byte {
+	t.Helper()
+	sum := binary.BigEndian.AppendUint32(nil, adler32.Checksum(data))
+	var payload []byte
+	switch comp {
+	case 0:
+		payload = append([]byte(nil), data...)
+	case 1:
+		// A single literal run followed by the end marker.
+		if len(data) > 238 {
+			t.Fatal("lzo test blocks hold at most 238 bytes")
+		}
+		payload = append([]byte{byte(17 + len(data))}, data...)
+		payload = append(payload, 0x11, 0, 0)
+	case 2:
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		_, _ = zw.Write(data)
+		_ = zw.Close()
+		payload = buf.Bytes()
+	}
+	n := min(len(payload), 0xff)
+	if enc != 0 {
+		key := ripemd128(sum)
+		if f.uuid != "" {
+			key = uuidKey(f.uuid)
+		}
+		if enc == 1 {
+			fastEncrypt(payload[:n], key)
+		} else {
+			payload = append(salsa20(payload[:n], key, 8), payload[n:]...)
+		}
+	}
+	out := binary.LittleEndian.AppendUint32(nil, comp|enc<<4|uint32(n)<<8)
+	out = append(out, sum...)
+	return append(out, payload...)
+}
+
+// writeTestFile writes an MDX (or MDD) file holding entries in order.
+func writeTestFile(t *testing.T, path string, f testFile, entries []testEntry) {
+	t.Helper()
+	if f.keysPer == 0 {
+		f.keysPer = 2
+	}
+	if f.recSize == 0 {
+		f.recSize = 16
+	}
+
+	// Records form one stream, split into fixed-size blocks so records
+	// straddle block boundaries.
+	var stream []byte
+	offsets := make([]int, len(entries))
+	for i, e := range entries {
+		offsets[i] = len(stream)
+		stream = append(stream, e.record...)
+	}
+	var recBlocks [][]byte
+	var recDecomp []int
+	for p := 0; p < len(stream); p += f.recSize {
+		chunk := stream[p:min(p+f.recSize, len(stream))]
+		recBlocks = append(recBlocks, f.encodeBlock(t, chunk, f.compress, f.blockEnc))
+		recDecomp = append(recDecomp, len(chunk))
+	}
+
+	term := f.text("\x00")
+	var keyBlocks [][]byte
+	var keyDecomp []int
+	var keyInfo []byte
+	for i := 0; i < len(entries); i += f.keysPer {
+		group := entries[i:min(i+f.keysPer, len(entries))]
+		var data []byte
+		for j, e := range group {
+			data = f.num(data, offsets[i+j])
+			data = append(data, f.text(e.key)...)
+			data = append(data, term...)
+		}
+		block := f.encodeBlock(t, data, f.compress, 0)
+		keyBlocks = append(keyBlocks, block)
+		keyDecomp = append(keyDecomp, len(data))
+
+		keyInfo = f.num(keyInfo, len(group))
+		for _, k := range []string{group[0].key, group[len(group)-1].key} {
+			size := len([]rune(k))
+			if f.utf16() {
+				size = len(utf16.Encode([]rune(k)))
+			}
+			if f.v2() {
+				keyInfo = binary.BigEndian.AppendUint16(keyInfo, uint16(size))
+				keyInfo = append(keyInfo, f.text(k)...)
+				keyInfo = append(keyInfo, term...)
+			} else {
+				keyInfo = append(keyInfo, byte(size))
+				keyInfo = append(keyInfo, f.text(k)...)
+			}
+		}
+		keyInfo = f.num(keyInfo, len(block))
+		keyInfo = f.num(keyInfo, len(data))
+	}
+
+	attrs := fmt.Sprintf(`GeneratedByEngineVersion="%s" RequiredEngineVersion="%s" Encrypted="%d" Title="Test" %s`, f.version, f.version, f.encrypt, f.attrs)
+	if !f.mdd {
+		attrs += ` Encoding="` + f.encoding + `"`
+	}
+	if f.uuid != "" {
+		attrs += ` UUID="` + f.uuid + `"`
+	}
+	hdr := utf16LE(`<Dictionary ` + attrs + `/>` + "\r\n\x00")
+	var out []byte
+	out = binary.BigEndian.AppendUint32(out, uint32(len(hdr)))
+	out = append(out, hdr...)
+	out = binary.LittleEndian.AppendUint32(out, adler32.Checksum(hdr))
+
+	if f.v3() {
+		section := func(kind uint32, body []byte) {
+			out = binary.BigEndian.AppendUint32(out, kind)
+			out = binary.BigEndian.AppendUint64(out, uint64(len(body)))
+			out = append(out, body...)
+		}
+		data := func(blocks [][]byte, decomp []int) []byte {
+			b := binary.BigEndian.AppendUint32(nil, uint32(len(blocks)))
+			b = append(b, make([]byte, 8)...)
+			for i, block := range blocks {
+				b = binary.BigEndian.AppendUint32(b, uint32(decomp[i]))
+				b = binary.BigEndian.AppendUint32(b, uint32(len(block)))
+				b = append(b, block...)
+			}
+			return b
+		}
+		section(v3KeyInfo, []byte("ignored"))
+		section(v3KeyData, data(keyBlocks, keyDecomp))
+		section(v3RecordInfo, []byte("ignored"))
+		section(v3RecordData, data(recBlocks, recDecomp))
+		if err := os.WriteFile(path, out, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	info := keyInfo
+	if f.v2() {
+		info = f.encodeBlock(t, keyInfo, 2, 0)
+		if f.encrypt&2 != 0 {
+			fastEncrypt(info[8:], keyInfoKey(info[4:8]))
+		}
+	}
+	blocksSize := 0
+	for _, b := range keyBlocks {
+		blocksSize += len(b)
+	}
+	var head []byte
+	head = f.num(head, len(keyBlocks))
+	head = f.num(head, len(entries))
+	if f.v2() {
+		head = f.num(head, len(keyInfo))
+	}
+	head = f.num(head, len(info))
+	head = f.num(head, blocksSize)
+	if f.encrypt&1 != 0 {
+		head = salsa20(head, f.regKey, 8)
+	}
+	out = append(out, head...)
+	if f.v2() {
+		out = binary.BigEndian.AppendUint32(out, adler32.Checksum(head))
+	}
+	out = append(out, info...)
+	for _, b := range keyBlocks {
+		out = append(out, b...)
+	}
+
+	var recInfo []byte
+	recTotal := 0
+	for i, b := range recBlocks {
+		recInfo = f.num(recInfo, len(b))
+		recInfo = f.num(recInfo, recDecomp[i])
+		recTotal += len(b)
+	}
+	out = f.num(out, len(recBlocks))
+	out = f.num(out, len(entries))
+	out = f.num(out, len(recInfo))
+	out = f.num(out, recTotal)
+	out = append(out, recInfo...)
+	for _, b := range recBlocks {
+		out = append(out, b...)
+	}
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The registration keys below were computed independently with Python
+// RIPEMD-128 and Salsa20/8 implementations, following readmdict: emails
+// are hashed as UTF-16LE, device IDs as raw bytes.
+const (
+	testRegcode  = "00112233445566778899aabbccddeeff"
+	testEmail    = "user@example.com"
+	testEmailKey = "cffdf4220cabd4f5f0e52e3c92a04468"
+	testDeviceID = "0123456789abcdef"
+	testDevKey   = "93058f1955b57548cb7edf5f7eb53ec7"
+)
+
+func TestRegistrationKey(t *testing.T) {
+	tests := []struct {
+		email, deviceID, want string
+	}{
+		{testEmail, "", testEmailKey},
+		{" " + testEmail + "\n", "", testEmailKey},
+		{"", testDeviceID, testDevKey},
+		{testEmail, testDeviceID, testDevKey},
+	}
+	for _, tc := range tests {
+		key, err := registrationKey(testRegcode, tc.email, tc.deviceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(key); got != tc.want {
+			t.Errorf("registrationKey(%q, %q) = %s, want %s", tc.email, tc.deviceID, got, tc.want)
+		}
+	}
+	if _, err := registrationKey("not hex", testEmail, ""); err == nil {
+		t.Error("invalid registration code was accepted")
+	}
+}
+
+func TestLoadGeneratedFiles(t *testing.T) {
+	regKey, _ := hex.DecodeString(testEmailKey)
+	tests := []struct {
+		name   string
+		mdx    testFile
+		mdd    *testFile
+		loadRC string
+	}{
+		{
+			name: "v1.2 utf-16",
+			mdx:  testFile{version: "1.2", encoding: "UTF-16", compress: 0},
+			mdd:  &testFile{version: "1.2", mdd: true, compress: 0},
+		},
+		{
+			name: "v2.0 zlib with encrypted key info",
+			mdx:  testFile{version: "2.0", encoding: "UTF-8", encrypt: 2, compress: 2, blockEnc: 1},
+			mdd:  &testFile{version: "2.0", mdd: true, encrypt: 2, compress: 2},
+		},
+		{
+			name:   "v2.0 lzo with registration code",
+			mdx:    testFile{version: "2.0", encoding: "UTF-8", encrypt: 3, regKey: regKey, compress: 1},
+			loadRC: testRegcode,
+		},
+		{
+			name: "v3.0 with uuid block key",
+			mdx:  testFile{version: "3.0", uuid: "0f3c2a9e-6b1d-4e27-9a55-2d8f1c7b3e40", compress: 2, blockEnc: 2},
+			mdd:  &testFile{version: "3.0", mdd: true, uuid: "0f3c2a9e-6b1d-4e27-9a55-2d8f1c7b3e40", compress: 2, blockEnc: 1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.mdx")
+			rec := func(s string) []byte {
+				if tc.mdx.utf16() {
+					return utf16LE(s + "\x00")
+				}
+				return []byte(s + "\x00")
+			}
+			writeTestFile(t, path, tc.mdx, []testEntry{
+				{"Apple", rec(`<b>a red fruit</b><img src="img/a.png">`)},
+				{"apples", rec("@@@LINK=Apple")},
+				{"apricot", rec("an orange fruit")},
+				{"banana", rec("a long yellow fruit")},
+				{"Apple", rec("a technology company")},
+			})
+			if tc.mdd != nil {
+				writeTestFile(t, filepath.Join(dir, "test.mdd"), *tc.mdd, []testEntry{
+					{`\img\a.png`, []byte("PNGDATA")},
+					{`\style.css`, []byte("b { color: red }")},
+				})
+			}
+
+			// The second load reads the index cache.
+			for _, pass := range []string{"build", "cached"} {
+				d, err := Load("t", "", path, true, tc.loadRC, testEmail, "")
+				if err != nil {
+					t.Fatalf("%s: Load: %v", pass, err)
+				}
+				got := d.Lookup("apple")
+				if len(got) != 2 || got[0].Word != "Apple" || !strings.Contains(got[0].Definition, "<b>a red fruit</b>") || !strings.Contains(got[1].Definition, "technology") {
+					t.Fatalf("%s: Lookup(apple) = %+v", pass, got)
+				}
+				if got := d.Lookup("apples"); len(got) != 2 || !strings.Contains(got[0].Definition, "red fruit") {
+					t.Fatalf("%s: Lookup(apples) did not follow the redirect: %+v", pass, got)
+				}
+				if got := d.Lookup("banana"); len(got) != 1 || !strings.Contains(got[0].Definition, "yellow") {
+					t.Fatalf("%s: Lookup(banana) = %+v", pass, got)
+				}
+				var words []string
+				for _, e := range d.Prefix("ap", 10) {
+					words = append(words, e.Word)
+				}
+				if strings.Join(words, ",") != "Apple,apples,apricot" {
+					t.Fatalf("%s: Prefix(ap) = %v", pass, words)
+				}
+				if tc.mdd != nil {
+					if data, _, ok := d.Resource("img/a.png"); !ok || string(data) != "PNGDATA" {
+						t.Fatalf("%s: Resource(img/a.png) = %q, %v", pass, data, ok)
+					}
+					if data, _, ok := d.Resource("style.css"); !ok || !strings.Contains(string(data), "color: red") {
+						t.Fatalf("%s: Resource(style.css) = %q, %v", pass, data, ok)
+					}
+				}
+				if _, _, ok := d.Resource("missing.png"); ok {
+					t.Fatalf("%s: Resource(missing.png) found", pass)
+				}
+				if err := d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadEncryptedWithoutRegcode(t *testing.T) {
+	regKey, _ := hex.DecodeString(testEmailKey)
+	path := filepath.Join(t.TempDir(), "test.mdx")
+	writeTestFile(t, path, testFile{version: "2.0", encoding: "UTF-8", encrypt: 1, regKey: regKey}, []testEntry{{"a", []byte("b\x00")}})
+	if _, err := Load("t", "", path, true, "", "", ""); !errors.Is(err, errEncrypted) {
+		t.Fatalf("Load without regcode: %v, want errEncrypted", err)
+	}
+}
@@ -0,0 +1,167 @@
+package mdict
+
+import (
+	"encoding/binary"
+	"errors"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxHeaderLen guards against reading a corrupt length prefix.
+const maxHeaderLen = 16 * 1024 * 1024
+
+var headerAttrRe = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+
+// header holds the attributes of the XML header that opens MDX/MDD files.
+type header struct {
+	attrs      map[string]string
+	styleSheet map[string][2]string
+}
+
+// readHeader reads the header of an MDX/MDD file: a big-endian length, the
+// header text (UTF-16LE for MDX) and an Adler-32 checksum.
+func readHeader(r io.Reader) (*header, int64, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, 0, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n == 0 || n > maxHeaderLen {
+		return nil, 0, errors.New("mdict: invalid header length")
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, 0, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return nil, 0, err
+	}
+	return parseHeader(decodeHeaderText(raw)), int64(4 + n + 4), nil
+}
+
+func readHeaderFile(path string) (*header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, _, err := readHeader(f)
+	return h, err
+}
+
+func decodeHeaderText(raw []byte) string {
+	// MDX headers are UTF-16LE; some MDD files use UTF-8.
+	if len(raw) >= 2 && raw[1] == 0 {
+		u16 := make([]uint16, len(raw)/2)
+		for i := range u16 {
+			u16[i] = binary.LittleEndian.Uint16(raw[i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u16)), "\x00")
+	}
+	return strings.TrimRight(string(raw), "\x00")
+}
+
+func parseHeader(text string) *header {
+	h := &header{attrs: make(map[string]string)}
+	for _, m := range headerAttrRe.FindAllStringSubmatch(text, -1) {
+		h.attrs[m[1]] = html.UnescapeString(m[2])
+	}
+	h.styleSheet = parseStyleSheet(h.attrs["StyleSheet"])
+	return h
+}
+
+func (h *header) get(key string) string {
+	if h == nil {
+		return ""
+	}
+	return strings.TrimSpace(h.attrs[key])
+}
+
+func (h *header) yes(key string) bool {
+	return strings.EqualFold(h.get(key), "yes")
+}
+
+// title returns the dictionary title, ignoring MdxBuilder's placeholder.
+func (h *header) title() string {
+	t := h.get("Title")
+	if strings.HasPrefix(t, "Title (No HTML code allowed)") {
+		return ""
+	}
+	return t
+}
+
+// metadata returns the descriptive header attributes exposed to clients.
+func (h *header) metadata() map[string]string {
+	out := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			out[key] = value
+		}
+	}
+	set("title", h.title())
+	set("description", h.get("Description"))
+	set("encoding", h.get("Encoding"))
+	set("generated_by_engine_version", h.get("GeneratedByEngineVersion"))
+	set("creation_date", h.get("CreationDate"))
+	set("format", h.get("Format"))
+	return out
+}
+
+// parseStyleSheet parses the StyleSheet attribute: groups of three lines
+// holding a style number, its opening markup and its closing markup.
+func parseStyleSheet(s string) map[string][2]string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	out := make(map[string][2]string)
+	for i := 0; i+2 < len(lines); i += 3 {
+		key := strings.TrimSpace(lines[i])
+		if key == "" {
+			continue
+		}
+		out[key] = [2]string{lines[i+1], lines[i+2]}
+	}
+	return out
+}
+
+var styleMarkerRe = regexp.MustCompile("`(\\d+)`")
+
+// applyStyleSheet replaces `N` markers with the begin/end markup of style N;
+// each style runs until the next marker.
+func applyStyleSheet(text string, styles map[string][2]string) string {
+	if len(styles) == 0 || !strings.Contains(text, "`") {
+		return text
+	}
+	locs := styleMarkerRe.FindAllStringSubmatchIndex(text, -1)
+	if len(locs) == 0 {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	b.WriteString(text[:locs[0][0]])
+	for i, loc := range locs {
+		end := len(text)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		part := text[loc[1]:end]
+		style, ok := styles[text[loc[2]:loc[3]]]
+		if !ok {
+			b.WriteString(part)
+			continue
+		}
+		if strings.HasSuffix(part, "\n") {
+			b.WriteString(style[0] + strings.TrimRight(part, "\r\n") + style[1] + "\r\n")
+			continue
+		}
+		b.WriteString(style[0] + part + style[1])
+	}
+	return b.String()
+}
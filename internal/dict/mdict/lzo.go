@@ -0,0 +1,171 @@
+package mdict
+
+import "errors"
+
+var errLZOCorrupt = errors.New("mdict: corrupt lzo block")
+
+// lzo1xDecompress inflates an LZO1X-1 stream whose decompressed size is
+// known up front, as stored in MDict record blocks of compression type 1.
+func lzo1xDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	ip := 0
+
+	next := func() (int, error) {
+		if ip >= len(in) {
+			return 0, errLZOCorrupt
+		}
+		b := int(in[ip])
+		ip++
+		return b, nil
+	}
+	// runLength decodes the zero-byte extension of a length field.
+	runLength := func(base int) (int, error) {
+		n := 0
+		for {
+			b, err := next()
+			if err != nil {
+				return 0, err
+			}
+			if b != 0 {
+				return n + base + b, nil
+			}
+			n += 255
+		}
+	}
+	literals := func(n int) error {
+		if ip+n > len(in) || len(out)+n > size {
+			return errLZOCorrupt
+		}
+		out = append(out, in[ip:ip+n]...)
+		ip += n
+		return nil
+	}
+	copyMatch := func(dist, n int) error {
+		pos := len(out) - dist
+		if pos < 0 || len(out)+n > size {
+			return errLZOCorrupt
+		}
+		// Byte by byte: the source may overlap the bytes being written.
+		for i := 0; i < n; i++ {
+			out = append(out, out[pos+i])
+		}
+		return nil
+	}
+
+	const (
+		stateLiteral = iota // expect a literal run or a match
+		stateFirst          // just after a literal run
+		stateMatch          // after a match or its trailing literals
+	)
+	state := stateLiteral
+	if len(in) > 0 && in[0] > 17 {
+		t := int(in[0]) - 17
+		ip++
+		if err := literals(t); err != nil {
+			return nil, err
+		}
+		if t < 4 {
+			state = stateMatch
+		} else {
+			state = stateFirst
+		}
+	}
+
+	for {
+		t, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if t < 16 {
+			switch state {
+			case stateLiteral:
+				n := t
+				if n == 0 {
+					if n, err = runLength(15); err != nil {
+						return nil, err
+					}
+				}
+				if err := literals(n + 3); err != nil {
+					return nil, err
+				}
+				state = stateFirst
+				continue
+			case stateFirst:
+				b, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if err := copyMatch(1+0x0800+(t>>2)+(b<<2), 3); err != nil {
+					return nil, err
+				}
+			default:
+				b, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if err := copyMatch(1+(t>>2)+(b<<2), 2); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			var dist, n int
+			switch {
+			case t >= 64:
+				b, err := next()
+				if err != nil {
+					return nil, err
+				}
+				dist = 1 + ((t >> 2) & 7) + (b << 3)
+				n = (t >> 5) + 1
+			case t >= 32:
+				n = t & 31
+				if n == 0 {
+					if n, err = runLength(31); err != nil {
+						return nil, err
+					}
+				}
+				if ip+2 > len(in) {
+					return nil, errLZOCorrupt
+				}
+				dist = 1 + (int(in[ip])|int(in[ip+1])<<8)>>2
+				ip += 2
+				n += 2
+			default:
+				high := (t & 8) << 11
+				n = t & 7
+				if n == 0 {
+					if n, err = runLength(7); err != nil {
+						return nil, err
+					}
+				}
+				if ip+2 > len(in) {
+					return nil, errLZOCorrupt
+				}
+				low := (int(in[ip]) | int(in[ip+1])<<8) >> 2
+				ip += 2
+				if high == 0 && low == 0 {
+					if len(out) != size {
+						return nil, errLZOCorrupt
+					}
+					return out, nil
+				}
+				dist = high + low + 0x4000
+				n += 2
+			}
+			if err := copyMatch(dist, n); err != nil {
+				return nil, err
+			}
+		}
+
+		// The low two bits of the byte two positions back give the number
+		// of literals that follow the match.
+		if lit := int(in[ip-2]) & 3; lit > 0 {
+			if err := literals(lit); err != nil {
+				return nil, err
+			}
+			state = stateMatch
+		} else {
+			state = stateLiteral
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package mdict
+
+import "testing"
+
+func TestLZO1XDecompress(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{
+			name: "literals only",
+			in:   []byte{17 + 5, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0},
+			want: "hello",
+		},
+		{
+			name: "overlapping match",
+			// "abc", then an 8 byte match at distance 3.
+			in:   []byte{17 + 3, 'a', 'b', 'c', 7<<5 | 2<<2, 0, 0x11, 0, 0},
+			want: "abcabcabcab",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lzo1xDecompress(tt.in, len(tt.want))
+			if err != nil {
+				t.Fatalf("lzo1xDecompress: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := lzo1xDecompress([]byte{17 + 5, 'h', 'e'}, 5); err == nil {
+		t.Fatal("expected error for truncated input")
+	}
+}
@@ -1,90 +1,106 @@
 package mdict
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"fmt"
+	"html"
 	"log"
 	"mime"
 	"os"
 	"path/filepath"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"unicode/utf16"
 
-	"github.com/ChaosNyaruko/ondict/decoder"
-	"github.com/ChaosNyaruko/ondict/util"
 	"github.com/sagerenn/mdict/internal/dict"
 )
 
 type Dictionary struct {
 	id          string
 	name        string
-	caseFold    bool
-	mdx         *decoder.MDict
+	keys        keyNormalizer
+	header      *header
+	mdx         *mdictFile
 	entries     []wordEntry
 	normIndex   map[string][]int
 	sortedN     []string
 	sortedW     []string
 	encoding    string
-	path        string
 	resourceDir string
-	resources   []resourceIndex
+	resources   []*resourceFile
 }
 
-func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
-	md := &decoder.MDict{}
-	if err := md.Decode(path, false); err != nil {
+// Load opens an MDX file and its MDD resources. regcode and the email or
+// device ID it was issued for are only needed for dictionaries whose keyword
+// index is encrypted (Encrypted & 1).
+func Load(id, name, path string, caseFold bool, regcode, email, deviceID string) (*Dictionary, error) {
+	var regKey []byte
+	if regcode != "" {
+		var err error
+		if regKey, err = registrationKey(regcode, email, deviceID); err != nil {
+			return nil, err
+		}
+	}
+	md, err := openMDictFile(path, false, regKey)
+	if err != nil {
 		return nil, err
 	}
+	hdr := md.header
+	if name == "" {
+		name = hdr.title()
+	}
 	if name == "" {
 		name = id
 	}
+	keys := newKeyNormalizer(hdr, caseFold)
 
-	enc := mdictEncoding(md)
-	resources := loadResources(path)
-	resDir := filepath.Dir(path)
+	d := &Dictionary{
+		id:          id,
+		name:        name,
+		keys:        keys,
+		header:      hdr,
+		mdx:         md,
+		encoding:    hdr.get("Encoding"),
+		resourceDir: filepath.Dir(path),
+	}
 
-	if cached, ok, err := loadCache(path, caseFold); err == nil && ok {
-		return &Dictionary{
-			id:          id,
-			name:        name,
-			caseFold:    caseFold,
-			mdx:         md,
-			entries:     cached.Entries,
-			normIndex:   cached.NormToEntries,
-			sortedN:     cached.SortedNorm,
-			sortedW:     cached.SortedWord,
-			encoding:    enc,
-			path:        path,
-			resourceDir: resDir,
-			resources:   resources,
-		}, nil
+	if cached, ok, err := loadCache(path, keys); err == nil && ok {
+		d.entries = cached.Entries
+		d.normIndex = cached.NormToEntries
+		d.sortedN = cached.SortedNorm
+		d.sortedW = cached.SortedWord
+		d.resources = loadResources(path, regKey)
+		return d, nil
 	}
 
-	_ = md.Keys() // populate keymap
-	keymap := mdictKeyMap(md)
+	records, err := md.keys()
+	if err != nil {
+		_ = md.Close()
+		return nil, fmt.Errorf("mdict: %s: %w", path, err)
+	}
 
-	entries := make([]wordEntry, 0, len(keymap))
+	entries := make([]wordEntry, 0, len(records))
+	byWord := make(map[string]int, len(records))
 	normIndex := make(map[string][]int)
 	type item struct {
 		norm string
 		word string
 	}
-	items := make([]item, 0, len(keymap))
+	items := make([]item, 0, len(records))
 
-	for word, offs := range keymap {
-		idx := len(entries)
-		o := make([]int, 0, len(offs))
-		for _, v := range offs {
-			o = append(o, int(v))
+	for _, r := range records {
+		span := recordSpan{Offset: r.offset, Size: r.size}
+		if idx, ok := byWord[r.key]; ok {
+			entries[idx].Records = append(entries[idx].Records, span)
+			continue
 		}
-		entries = append(entries, wordEntry{Word: word, Offsets: o})
-		norm := normalize(word, caseFold)
+		idx := len(entries)
+		byWord[r.key] = idx
+		entries = append(entries, wordEntry{Word: r.key, Records: []recordSpan{span}})
+		norm := keys.normalize(r.key)
 		normIndex[norm] = append(normIndex[norm], idx)
-		items = append(items, item{norm: norm, word: word})
+		items = append(items, item{norm: norm, word: r.key})
 	}
 
 	sort.Slice(items, func(i, j int) bool {
@@ -100,22 +116,15 @@ func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
 		sortedW = append(sortedW, it.word)
 	}
 
-	_ = saveCache(path, caseFold, entries, normIndex, sortedN, sortedW)
+	_ = saveCache(path, keys, entries, normIndex, sortedN, sortedW)
 
-	return &Dictionary{
-		id:          id,
-		name:        name,
-		caseFold:    caseFold,
-		mdx:         md,
-		entries:     entries,
-		normIndex:   normIndex,
-		sortedN:     sortedN,
-		sortedW:     sortedW,
-		encoding:    enc,
-		path:        path,
-		resourceDir: resDir,
-		resources:   resources,
-	}, nil
+	d.entries = entries
+	d.normIndex = normIndex
+	d.sortedN = sortedN
+	d.sortedW = sortedW
+	// MDD files are opened last so a failed MDX leaves nothing open.
+	d.resources = loadResources(path, regKey)
+	return d, nil
 }
 
 func (d *Dictionary) ID() string {
@@ -126,6 +135,22 @@ func (d *Dictionary) Name() string {
 	return d.name
 }
 
+// Metadata returns the MDX header fields (title, description, encoding, ...).
+func (d *Dictionary) Metadata() map[string]string {
+	return d.header.metadata()
+}
+
+// Close releases the MDX and MDD files.
+func (d *Dictionary) Close() error {
+	err := d.mdx.Close()
+	for _, r := range d.resources {
+		if cerr := r.mdd.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (d *Dictionary) Lookup(word string) []dict.Entry {
 	return d.lookup(word, make(map[string]bool))
 }
@@ -134,7 +159,7 @@ func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
 	if limit <= 0 {
 		limit = 20
 	}
-	pfx := normalize(prefix, d.caseFold)
+	pfx := d.keys.normalize(prefix)
 	idx := sort.Search(len(d.sortedN), func(i int) bool {
 		return d.sortedN[i] >= pfx
 	})
@@ -151,23 +176,28 @@ func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
 	return out
 }
 
-func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
 	if limit <= 0 {
 		limit = 20
 	}
-	q := normalize(query, d.caseFold)
-	out := make([]dict.Entry, 0, limit)
-	for i, n := range d.sortedN {
-		if strings.Contains(n, q) {
-			out = append(out, dict.Entry{Word: d.sortedW[i]})
-			if len(out) >= limit {
-				break
-			}
-		}
-	}
+	return dict.PrefixRange(d.sortedN, d.sortedW, d.keys.normalize(prefix), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
 	return out
 }
 
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, d.keys.normalize(query), limit)
+}
+
 func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
 	if name == "" {
 		return nil, "", false
@@ -189,8 +219,8 @@ func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
 		}
 	}
 
-	for i := range d.resources {
-		if data, ok := d.resources[i].read(clean); ok {
+	for _, r := range d.resources {
+		if data, ok := r.read(clean); ok {
 			if isCSSFile(clean) {
 				data = processCSS(data, d.encoding, d.id)
 			}
@@ -202,7 +232,7 @@ func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
 }
 
 func (d *Dictionary) lookup(word string, visited map[string]bool) []dict.Entry {
-	q := normalize(word, d.caseFold)
+	q := d.keys.normalize(word)
 	if visited[q] {
 		return nil
 	}
@@ -215,8 +245,13 @@ func (d *Dictionary) lookup(word string, visited map[string]bool) []dict.Entry {
 	seen := make(map[string]bool)
 	for _, i := range idxs {
 		entry := d.entries[i]
-		for _, off := range entry.Offsets {
-			raw := d.decode(d.mdx.ReadAtOffset(off))
+		for _, rec := range entry.Records {
+			data, err := d.mdx.record(rec.Offset, rec.Size)
+			if err != nil {
+				log.Printf("mdict: failed to read record for %q in %q: %v", entry.Word, d.id, err)
+				continue
+			}
+			raw := strings.TrimRight(d.mdx.decodeText(data), "\x00")
 			if target := parseRedirect(raw); target != "" {
 				redirected := d.lookup(target, visited)
 				if len(redirected) > 0 {
@@ -228,8 +263,11 @@ func (d *Dictionary) lookup(word string, visited map[string]bool) []dict.Entry {
 					}
 					continue
 				}
+				// Unresolved redirect: leave a link to the target.
+				raw = `See <a href="entry://` + html.EscapeString(target) + `">` + html.EscapeString(target) + `</a>`
 			}
-			def := strings.TrimSpace(util.ReplaceLINK(raw))
+			raw = applyStyleSheet(raw, d.header.styleSheet)
+			def := strings.TrimSpace(raw)
 			if def == "" {
 				continue
 			}
@@ -248,72 +286,85 @@ func (d *Dictionary) lookup(word string, visited map[string]bool) []dict.Entry {
 	return out
 }
 
-func (d *Dictionary) decode(b []byte) string {
-	if d.encoding == "UTF-16" {
-		runes := make([]uint16, len(b)/2)
-		_ = binary.Read(bytes.NewBuffer(b), binary.LittleEndian, runes)
-		return string(utf16.Decode(runes))
-	}
-	return string(b)
+// keyNormalizer folds keys the way the MDX header asks: KeyCaseSensitive="No"
+// enables case folding on top of the configured case_fold, and StripKey="Yes"
+// ignores spaces and punctuation.
+type keyNormalizer struct {
+	Fold  bool
+	Strip bool
 }
 
-func mdictEncoding(m *decoder.MDict) string {
-	v := reflect.ValueOf(m).Elem().FieldByName("encoding")
-	if v.IsValid() && v.Kind() == reflect.String {
-		return v.String()
+func newKeyNormalizer(h *header, caseFold bool) keyNormalizer {
+	return keyNormalizer{
+		Fold:  caseFold || strings.EqualFold(h.get("KeyCaseSensitive"), "no"),
+		Strip: h.yes("StripKey"),
 	}
-	return "UTF-8"
 }
 
-func mdictKeyMap(m *decoder.MDict) map[string][]uint64 {
-	v := reflect.ValueOf(m).Elem().FieldByName("keymap")
-	if !v.IsValid() || v.IsNil() {
-		return nil
+func (k keyNormalizer) normalize(s string) string {
+	s = strings.TrimSpace(s)
+	if k.Strip {
+		s = stripKey(s)
 	}
-	out := make(map[string][]uint64)
-	for _, k := range v.MapKeys() {
-		key := k.String()
-		vals := v.MapIndex(k)
-		offs := make([]uint64, 0, vals.Len())
-		for i := 0; i < vals.Len(); i++ {
-			offs = append(offs, uint64(vals.Index(i).Uint()))
-		}
-		out[key] = offs
+	if k.Fold {
+		s = strings.ToLower(s)
 	}
-	return out
+	return s
 }
 
-func normalize(s string, caseFold bool) string {
-	if caseFold {
-		return strings.ToLower(strings.TrimSpace(s))
-	}
-	return strings.TrimSpace(s)
+// stripKey drops the characters MDict ignores when StripKey is set.
+func stripKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" _=,.;:!?@%&#~`()[]<>{}/\\$+-*^'\"\t|", r) {
+			return -1
+		}
+		return r
+	}, s)
 }
 
-type resourceIndex struct {
-	dict   *decoder.MDict
-	once   sync.Once
-	keymap map[string][]uint64
+// resourceFile serves the files stored in an MDD archive. Its name index is
+// built on first use.
+type resourceFile struct {
+	mdd   *mdictFile
+	once  sync.Once
+	names map[string]keyRecord
 }
 
-func (r *resourceIndex) read(name string) ([]byte, bool) {
-	if r == nil || r.dict == nil {
-		return nil, false
-	}
+func (r *resourceFile) read(name string) ([]byte, bool) {
 	r.once.Do(func() {
-		_ = r.dict.Keys()
-		r.keymap = mdictKeyMap(r.dict)
+		keys, err := r.mdd.keys()
+		if err != nil {
+			log.Printf("mdict: failed to index resource file %q: %v", r.mdd.path, err)
+			return
+		}
+		r.names = make(map[string]keyRecord, len(keys))
+		for _, k := range keys {
+			// MDD keys look like "\\img\\a.png".
+			n := strings.ToLower(dict.CleanResourceName(k.key))
+			if _, ok := r.names[n]; !ok {
+				r.names[n] = k
+			}
+		}
 	})
-	if r.keymap == nil {
+	k, ok := r.names[strings.ToLower(name)]
+	if !ok {
 		return nil, false
 	}
-	if offs, ok := r.keymap[name]; ok && len(offs) > 0 {
-		return r.dict.ReadAtOffset(int(offs[0])), true
+	data, err := r.mdd.record(k.offset, k.size)
+	if err != nil {
+		log.Printf("mdict: failed to read resource %q from %q: %v", name, r.mdd.path, err)
+		return nil, false
 	}
-	return nil, false
+	return data, true
+}
+
+// SourceFiles returns the .mdx file and the .mdd volumes next to it
+// (name.mdd, name.1.mdd, name.2.mdd, ...).
+func SourceFiles(mdxPath string) []string {
+	return append([]string{mdxPath}, mddPaths(mdxPath)...)
 }
 
-func loadResources(mdxPath string) []resourceIndex {
+func mddPaths(mdxPath string) []string {
 	base := strings.TrimSuffix(mdxPath, filepath.Ext(mdxPath))
 	var paths []string
 	if _, err := os.Stat(base + ".mdd"); err == nil {
@@ -326,14 +377,19 @@ func loadResources(mdxPath string) []resourceIndex {
 		}
 		paths = append(paths, p)
 	}
-	out := make([]resourceIndex, 0, len(paths))
+	return paths
+}
+
+func loadResources(mdxPath string, regKey []byte) []*resourceFile {
+	paths := mddPaths(mdxPath)
+	out := make([]*resourceFile, 0, len(paths))
 	for _, p := range paths {
-		mdd := &decoder.MDict{}
-		if err := mdd.Decode(p, false); err != nil {
+		mdd, err := openMDictFile(p, true, regKey)
+		if err != nil {
 			log.Printf("mdict: failed to decode mdd resource file %q: %v", p, err)
 			continue
 		}
-		out = append(out, resourceIndex{dict: mdd})
+		out = append(out, &resourceFile{mdd: mdd})
 	}
 	return out
 }
@@ -2,32 +2,47 @@ package registry
 
 import (
 	"errors"
+	"io"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/sagerenn/mdict/internal/dict"
 )
 
+// Registry holds the loaded dictionaries in display order. Dictionaries
+// that implement io.Closer are closed when they leave the registry through
+// Remove, Replace or Swap.
 type Registry struct {
-	mu    sync.RWMutex
-	byID  map[string]dict.Dictionary
-	order []dict.Dictionary
+	mu         sync.RWMutex
+	byID       map[string]dict.Dictionary
+	order      []dict.Dictionary
+	disabled   map[string]bool
+	closeDelay time.Duration
+	version    uint64
 }
 
 func New() *Registry {
 	return &Registry{
-		byID:  make(map[string]dict.Dictionary),
-		order: nil,
+		byID:     make(map[string]dict.Dictionary),
+		order:    nil,
+		disabled: make(map[string]bool),
 	}
 }
 
+// SetCloseDelay delays closing dictionaries that leave the registry, so
+// requests that still use them can finish.
+func (r *Registry) SetCloseDelay(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeDelay = d
+}
+
 func (r *Registry) Add(d dict.Dictionary) error {
-	if d == nil {
-		return errors.New("dictionary is nil")
+	if err := validate(d); err != nil {
+		return err
 	}
 	id := d.ID()
-	if id == "" {
-		return errors.New("dictionary id is empty")
-	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if _, exists := r.byID[id]; exists {
@@ -35,17 +50,191 @@ func (r *Registry) Add(d dict.Dictionary) error {
 	}
 	r.byID[id] = d
 	r.order = append(r.order, d)
+	r.version++
+	return nil
+}
+
+// Remove takes the dictionary out of the registry and closes it.
+func (r *Registry) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.byID[id]
+	if !ok {
+		return errors.New("unknown dictionary id: " + id)
+	}
+	delete(r.byID, id)
+	delete(r.disabled, id)
+	for i, o := range r.order {
+		if o == d {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.version++
+	r.retire(d)
+	return nil
+}
+
+// Replace swaps in d for the registered dictionary with the same id,
+// keeping its position and enabled state, and closes the old one.
+func (r *Registry) Replace(d dict.Dictionary) error {
+	if err := validate(d); err != nil {
+		return err
+	}
+	id := d.ID()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.byID[id]
+	if !ok {
+		return errors.New("unknown dictionary id: " + id)
+	}
+	if old == d {
+		return nil
+	}
+	order := make([]dict.Dictionary, len(r.order))
+	for i, o := range r.order {
+		if o == old {
+			o = d
+		}
+		order[i] = o
+	}
+	r.byID[id] = d
+	r.order = order
+	r.version++
+	r.retire(old)
 	return nil
 }
 
+// Swap replaces all dictionaries at once and returns the previous ones.
+// Lookups see either the old or the new set, never a mix. Previous
+// dictionaries that are not part of the new set are closed; enabled state
+// is kept for ids present in both.
+func (r *Registry) Swap(dicts []dict.Dictionary) ([]dict.Dictionary, error) {
+	byID := make(map[string]dict.Dictionary, len(dicts))
+	for _, d := range dicts {
+		if err := validate(d); err != nil {
+			return nil, err
+		}
+		id := d.ID()
+		if _, exists := byID[id]; exists {
+			return nil, errors.New("duplicate dictionary id: " + id)
+		}
+		byID[id] = d
+	}
+	order := make([]dict.Dictionary, 0, len(dicts))
+	order = append(order, dicts...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.order
+	for id := range r.disabled {
+		if _, ok := byID[id]; !ok {
+			delete(r.disabled, id)
+		}
+	}
+	r.byID = byID
+	r.order = order
+	r.version++
+	kept := make(map[dict.Dictionary]bool, len(dicts))
+	for _, d := range dicts {
+		kept[d] = true
+	}
+	for _, d := range old {
+		if !kept[d] {
+			r.retire(d)
+		}
+	}
+	return old, nil
+}
+
+// SetEnabled enables or disables a dictionary. Disabled dictionaries stay
+// registered but are left out of Get and List.
+func (r *Registry) SetEnabled(id string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return errors.New("unknown dictionary id: " + id)
+	}
+	if enabled {
+		delete(r.disabled, id)
+	} else {
+		r.disabled[id] = true
+	}
+	r.version++
+	return nil
+}
+
+// Enabled reports whether id is registered and enabled.
+func (r *Registry) Enabled(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.byID[id]
+	return ok && !r.disabled[id]
+}
+
+// Reorder moves the given dictionaries to the front in the given order;
+// the others follow in their current order.
+func (r *Registry) Reorder(ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := make([]dict.Dictionary, 0, len(r.order))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		d, ok := r.byID[id]
+		if !ok {
+			return errors.New("unknown dictionary id: " + id)
+		}
+		if seen[id] {
+			return errors.New("duplicate dictionary id: " + id)
+		}
+		seen[id] = true
+		order = append(order, d)
+	}
+	for _, d := range r.order {
+		if !seen[d.ID()] {
+			order = append(order, d)
+		}
+	}
+	r.order = order
+	r.version++
+	return nil
+}
+
+// Version changes whenever dictionaries are added, removed, replaced,
+// enabled, disabled or reordered.
+func (r *Registry) Version() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.version
+}
+
+// Get returns an enabled dictionary.
 func (r *Registry) Get(id string) (dict.Dictionary, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	d, ok := r.byID[id]
-	return d, ok
+	if !ok || r.disabled[id] {
+		return nil, false
+	}
+	return d, true
 }
 
+// List returns the enabled dictionaries in order.
 func (r *Registry) List() []dict.Dictionary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]dict.Dictionary, 0, len(r.order))
+	for _, d := range r.order {
+		if !r.disabled[d.ID()] {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// All returns every registered dictionary in order, disabled ones
+// included.
+func (r *Registry) All() []dict.Dictionary {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	out := make([]dict.Dictionary, 0, len(r.order))
@@ -53,11 +242,43 @@ func (r *Registry) List() []dict.Dictionary {
 	return out
 }
 
-func (r *Registry) MustAddAll(dicts []dict.Dictionary) error {
+// AddAll adds every dictionary it can and returns the errors of the ones
+// it could not add, such as duplicate ids.
+func (r *Registry) AddAll(dicts []dict.Dictionary) error {
+	var errs []error
 	for _, d := range dicts {
 		if err := r.Add(d); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
+}
+
+func validate(d dict.Dictionary) error {
+	if d == nil {
+		return errors.New("dictionary is nil")
+	}
+	if d.ID() == "" {
+		return errors.New("dictionary id is empty")
+	}
 	return nil
 }
+
+// retire closes d after the close delay if it is an io.Closer. r.mu must
+// be held.
+func (r *Registry) retire(d dict.Dictionary) {
+	c, ok := d.(io.Closer)
+	if !ok {
+		return
+	}
+	closeFn := func() {
+		if err := c.Close(); err != nil {
+			log.Printf("registry: failed to close %q: %v", d.ID(), err)
+		}
+	}
+	if r.closeDelay <= 0 {
+		closeFn()
+		return
+	}
+	time.AfterFunc(r.closeDelay, closeFn)
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+type fakeDict struct {
+	id     string
+	closed bool
+}
+
+func (f *fakeDict) ID() string                      { return f.id }
+func (f *fakeDict) Name() string                    { return f.id }
+func (f *fakeDict) Lookup(string) []dict.Entry      { return nil }
+func (f *fakeDict) Prefix(string, int) []dict.Entry { return nil }
+func (f *fakeDict) Search(string, int) []dict.Entry { return nil }
+func (f *fakeDict) Close() error                    { f.closed = true; return nil }
+
+func ids(dicts []dict.Dictionary) string {
+	out := make([]string, 0, len(dicts))
+	for _, d := range dicts {
+		out = append(out, d.ID())
+	}
+	return strings.Join(out, ",")
+}
+
+func TestRegistryMutations(t *testing.T) {
+	a, b, c := &fakeDict{id: "a"}, &fakeDict{id: "b"}, &fakeDict{id: "c"}
+	r := New()
+	if err := r.AddAll([]dict.Dictionary{a, b, &fakeDict{id: "a"}, c}); err == nil {
+		t.Fatal("duplicate id not reported")
+	}
+	if got := ids(r.List()); got != "a,b,c" {
+		t.Fatalf("List = %s", got)
+	}
+
+	if err := r.SetEnabled("b", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Get("b"); ok || ids(r.List()) != "a,c" || ids(r.All()) != "a,b,c" {
+		t.Fatalf("disabled b still listed: List=%s All=%s", ids(r.List()), ids(r.All()))
+	}
+
+	if err := r.Reorder([]string{"c", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(r.All()); got != "c,b,a" {
+		t.Fatalf("Reorder = %s", got)
+	}
+
+	b2 := &fakeDict{id: "b"}
+	if err := r.Replace(b2); err != nil {
+		t.Fatal(err)
+	}
+	if !b.closed || ids(r.All()) != "c,b,a" || r.Enabled("b") {
+		t.Fatalf("Replace: closed=%v order=%s enabled=%v", b.closed, ids(r.All()), r.Enabled("b"))
+	}
+
+	if err := r.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if !a.closed || ids(r.All()) != "c,b" {
+		t.Fatalf("Remove: closed=%v order=%s", a.closed, ids(r.All()))
+	}
+	if err := r.Remove("a"); err == nil {
+		t.Fatal("Remove of unknown id succeeded")
+	}
+
+	if _, err := r.Swap([]dict.Dictionary{c}); err != nil {
+		t.Fatal(err)
+	}
+	if !b2.closed || c.closed || ids(r.All()) != "c" {
+		t.Fatalf("Swap: b closed=%v c closed=%v order=%s", b2.closed, c.closed, ids(r.All()))
+	}
+}
@@ -0,0 +1,104 @@
+package slob
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const cacheVersion = 1
+
+// cacheIndex holds the refs list split into words and resources; blobs
+// stay in the slob store.
+type cacheIndex struct {
+	Version     int
+	CaseFold    bool
+	SourcePath  string
+	SourceSize  int64
+	SourceMtime int64
+	Entries     []entry
+	Resources   map[string]blobRef
+	NormToKeys  map[string][]int
+	SortedNorm  []string
+	SortedWord  []string
+}
+
+// blobRef locates an item in the store.
+type blobRef struct {
+	Bin  uint32
+	Item uint16
+}
+
+type entry struct {
+	Key  string
+	Blob blobRef
+}
+
+func cachePath(path string) string {
+	return path + ".gdapi.slob.idx"
+}
+
+func loadCache(path string, caseFold bool) (*cacheIndex, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := os.Open(cachePath(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var idx cacheIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, false, err
+	}
+	if idx.Version != cacheVersion || idx.CaseFold != caseFold {
+		return nil, false, nil
+	}
+	if filepath.Clean(idx.SourcePath) != filepath.Clean(path) || idx.SourceSize != info.Size() || idx.SourceMtime != info.ModTime().UnixNano() {
+		return nil, false, nil
+	}
+	return &idx, true, nil
+}
+
+func saveCache(path string, idx *cacheIndex) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	idx.Version = cacheVersion
+	idx.SourcePath = filepath.Clean(path)
+	idx.SourceSize = info.Size()
+	idx.SourceMtime = info.ModTime().UnixNano()
+
+	idxPath := cachePath(path)
+	tmp, err := os.CreateTemp(filepath.Dir(idxPath), filepath.Base(idxPath)+".tmp.*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, idxPath); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
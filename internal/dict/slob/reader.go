@@ -0,0 +1,344 @@
+package slob
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/cache"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+var slobMagic = []byte("!-1SLOB\x1f")
+
+// lzma2DictCap bounds the LZMA2 dictionary; bins are far smaller than the
+// 8 MiB dictionary of the default preset slob writers use.
+const lzma2DictCap = 8 << 20
+
+// slobFile is an open slob container. All integers are big-endian. The
+// header is followed by two item lists: the refs (key -> bin, item) and the
+// store of compressed bins.
+type slobFile struct {
+	file         *os.File
+	encoding     string
+	compression  string
+	tags         map[string]string
+	contentTypes []string
+	blobCount    uint32
+	refsOffset   int64
+	storeOffset  int64
+	storeCount   uint32
+	bins         *cache.Cache
+}
+
+// ref is one entry of the refs list. Fragment names an anchor inside the
+// blob.
+type ref struct {
+	key      string
+	bin      uint32
+	item     uint16
+	fragment string
+}
+
+func openSlob(path string) (*slobFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &slobFile{file: f, tags: make(map[string]string), bins: cache.New(16, 0)}
+	if err := s.readHeader(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("slob: %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *slobFile) Close() error {
+	return s.file.Close()
+}
+
+func (s *slobFile) readHeader() error {
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(s.file, 0, 1<<62))}
+	magic := make([]byte, len(slobMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, slobMagic) {
+		return errors.New("not a slob file")
+	}
+	// uuid
+	if _, err := io.ReadFull(r, make([]byte, 16)); err != nil {
+		return err
+	}
+	var err error
+	if s.encoding, err = readTinyText(r); err != nil {
+		return err
+	}
+	if enc := strings.ToLower(s.encoding); enc != "utf-8" && enc != "utf8" {
+		return fmt.Errorf("unsupported text encoding %q", s.encoding)
+	}
+	if s.compression, err = readTinyText(r); err != nil {
+		return err
+	}
+	switch s.compression {
+	case "", "zlib", "bz2", "lzma2":
+	default:
+		return fmt.Errorf("unsupported compression %q", s.compression)
+	}
+	tagCount, err := readUint8(r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(tagCount); i++ {
+		k, err := readTinyText(r)
+		if err != nil {
+			return err
+		}
+		v, err := readTinyText(r)
+		if err != nil {
+			return err
+		}
+		s.tags[k] = v
+	}
+	typeCount, err := readUint8(r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(typeCount); i++ {
+		t, err := readText(r)
+		if err != nil {
+			return err
+		}
+		s.contentTypes = append(s.contentTypes, t)
+	}
+	if err := binary.Read(r, binary.BigEndian, &s.blobCount); err != nil {
+		return err
+	}
+	var storeOffset, size uint64
+	if err := binary.Read(r, binary.BigEndian, &storeOffset); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	s.refsOffset = r.n
+	s.storeOffset = int64(storeOffset)
+
+	var count [4]byte
+	if _, err := s.file.ReadAt(count[:], s.storeOffset); err != nil {
+		return fmt.Errorf("read store: %w", err)
+	}
+	s.storeCount = binary.BigEndian.Uint32(count[:])
+	return nil
+}
+
+// readRefs reads the whole refs list. Items follow the uint64 position
+// table in order, so they are read sequentially.
+func (s *slobFile) readRefs() ([]ref, error) {
+	var count [4]byte
+	if _, err := s.file.ReadAt(count[:], s.refsOffset); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(count[:])
+	posStart := s.refsOffset + 4
+	dataStart := posStart + int64(n)*8
+
+	positions := make([]uint64, n)
+	pr := bufio.NewReader(io.NewSectionReader(s.file, posStart, int64(n)*8))
+	if err := binary.Read(pr, binary.BigEndian, positions); err != nil {
+		return nil, fmt.Errorf("read ref positions: %w", err)
+	}
+
+	refs := make([]ref, 0, n)
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(s.file, dataStart, s.storeOffset-dataStart))}
+	for i, pos := range positions {
+		if int64(pos) != r.n {
+			r = &countingReader{
+				r: bufio.NewReader(io.NewSectionReader(s.file, dataStart+int64(pos), s.storeOffset-dataStart-int64(pos))),
+				n: int64(pos),
+			}
+		}
+		var (
+			rf  ref
+			err error
+		)
+		if rf.key, err = readText(r); err != nil {
+			return nil, fmt.Errorf("read ref %d: %w", i, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &rf.bin); err != nil {
+			return nil, fmt.Errorf("read ref %d: %w", i, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &rf.item); err != nil {
+			return nil, fmt.Errorf("read ref %d: %w", i, err)
+		}
+		if rf.fragment, err = readTinyText(r); err != nil {
+			return nil, fmt.Errorf("read ref %d: %w", i, err)
+		}
+		refs = append(refs, rf)
+	}
+	return refs, nil
+}
+
+// blob returns the content type and data of item in bin.
+func (s *slobFile) blob(bin uint32, item uint16) (string, []byte, error) {
+	b, err := s.readBin(bin)
+	if err != nil {
+		return "", nil, err
+	}
+	if int(item) >= len(b.types) {
+		return "", nil, fmt.Errorf("slob: item %d out of range in bin %d", item, bin)
+	}
+	data, err := b.item(int(item))
+	if err != nil {
+		return "", nil, err
+	}
+	ct := ""
+	if t := int(b.types[item]); t < len(s.contentTypes) {
+		ct = s.contentTypes[t]
+	}
+	return ct, data, nil
+}
+
+// binData is a decompressed store item: a uint32 offset table with one
+// entry per item, then length-prefixed item contents.
+type binData struct {
+	types []byte
+	data  []byte
+}
+
+func (b *binData) item(i int) ([]byte, error) {
+	count := len(b.types)
+	if (i+1)*4 > len(b.data) {
+		return nil, errors.New("slob: truncated bin")
+	}
+	pos := int(binary.BigEndian.Uint32(b.data[i*4:]))
+	start := count*4 + pos
+	if start+4 > len(b.data) {
+		return nil, errors.New("slob: truncated bin")
+	}
+	size := int(binary.BigEndian.Uint32(b.data[start:]))
+	start += 4
+	if start+size > len(b.data) {
+		return nil, errors.New("slob: truncated bin")
+	}
+	return b.data[start : start+size], nil
+}
+
+func (s *slobFile) readBin(i uint32) (*binData, error) {
+	key := strconv.FormatUint(uint64(i), 10)
+	if v, ok := s.bins.Get(key); ok {
+		return v.(*binData), nil
+	}
+	if i >= s.storeCount {
+		return nil, fmt.Errorf("slob: bin %d out of range", i)
+	}
+	var pos [8]byte
+	if _, err := s.file.ReadAt(pos[:], s.storeOffset+4+int64(i)*8); err != nil {
+		return nil, err
+	}
+	off := s.storeOffset + 4 + int64(s.storeCount)*8 + int64(binary.BigEndian.Uint64(pos[:]))
+	r := bufio.NewReader(io.NewSectionReader(s.file, off, 1<<62))
+
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	types := make([]byte, n)
+	if _, err := io.ReadFull(r, types); err != nil {
+		return nil, err
+	}
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	comp := make([]byte, size)
+	if _, err := io.ReadFull(r, comp); err != nil {
+		return nil, err
+	}
+	data, err := s.decompress(comp)
+	if err != nil {
+		return nil, fmt.Errorf("slob: bin %d: %w", i, err)
+	}
+	b := &binData{types: types, data: data}
+	s.bins.Set(key, b)
+	return b, nil
+}
+
+func (s *slobFile) decompress(data []byte) ([]byte, error) {
+	switch s.compression {
+	case "":
+		return data, nil
+	case "zlib":
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case "bz2":
+		return io.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+	case "lzma2":
+		// Bins are raw LZMA2 streams without an xz container.
+		lr, err := lzma.Reader2Config{DictCap: lzma2DictCap}.NewReader2(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(lr)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", s.compression)
+}
+
+// countingReader tracks how many bytes were consumed.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func readUint8(r io.Reader) (uint8, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readTinyText reads a string with a uint8 length. Editable tag values are
+// padded with NULs up to 255 bytes.
+func readTinyText(r io.Reader) (string, error) {
+	n, err := readUint8(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(b, "\x00")), nil
+}
+
+// readText reads a string with a uint16 length.
+func readText(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,270 @@
+package slob
+
+import (
+	"context"
+	"html"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+// resourcePrefix marks refs that are stylesheets, scripts and images rather
+// than articles ("~/css/shared.css").
+const resourcePrefix = "~/"
+
+var anchorHrefRe = regexp.MustCompile(`(?i)(<a\b[^>]*?\bhref\s*=\s*)("|')([^"']*)("|')`)
+
+type Dictionary struct {
+	id        string
+	name      string
+	caseFold  bool
+	file      *slobFile
+	entries   []entry
+	resources map[string]blobRef
+	normIndex map[string][]int
+	sortedN   []string
+	sortedW   []string
+}
+
+func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
+	sf, err := openSlob(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dictionary{id: id, caseFold: caseFold, file: sf}
+	d.name = dictName(name, sf.tags, id)
+
+	if cached, ok, err := loadCache(path, caseFold); err == nil && ok {
+		d.entries = cached.Entries
+		d.resources = cached.Resources
+		d.normIndex = cached.NormToKeys
+		d.sortedN = cached.SortedNorm
+		d.sortedW = cached.SortedWord
+		return d, nil
+	}
+
+	refs, err := sf.readRefs()
+	if err != nil {
+		_ = sf.Close()
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(refs))
+	resources := make(map[string]blobRef)
+	normIndex := make(map[string][]int)
+	type item struct {
+		norm string
+		word string
+	}
+	items := make([]item, 0, len(refs))
+	for _, r := range refs {
+		blob := blobRef{Bin: r.bin, Item: r.item}
+		if strings.HasPrefix(r.key, resourcePrefix) {
+			if clean := dict.CleanResourceName(r.key); clean != "" {
+				resources[clean] = blob
+			}
+			continue
+		}
+		i := len(entries)
+		entries = append(entries, entry{Key: r.key, Blob: blob})
+		norm := normalize(r.key, caseFold)
+		normIndex[norm] = append(normIndex[norm], i)
+		items = append(items, item{norm: norm, word: r.key})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].norm == items[j].norm {
+			return items[i].word < items[j].word
+		}
+		return items[i].norm < items[j].norm
+	})
+	sortedN := make([]string, 0, len(items))
+	sortedW := make([]string, 0, len(items))
+	for _, it := range items {
+		sortedN = append(sortedN, it.norm)
+		sortedW = append(sortedW, it.word)
+	}
+
+	_ = saveCache(path, &cacheIndex{
+		CaseFold:   caseFold,
+		Entries:    entries,
+		Resources:  resources,
+		NormToKeys: normIndex,
+		SortedNorm: sortedN,
+		SortedWord: sortedW,
+	})
+
+	d.entries = entries
+	d.resources = resources
+	d.normIndex = normIndex
+	d.sortedN = sortedN
+	d.sortedW = sortedW
+	return d, nil
+}
+
+// dictName prefers the configured name, then the slob label, then the id.
+func dictName(name string, tags map[string]string, id string) string {
+	if name != "" {
+		return name
+	}
+	if l := tags["label"]; l != "" {
+		return l
+	}
+	return id
+}
+
+func (d *Dictionary) ID() string {
+	return d.id
+}
+
+func (d *Dictionary) Name() string {
+	return d.name
+}
+
+// Metadata returns the slob tags (label, license.name, source, uri, ...).
+func (d *Dictionary) Metadata() map[string]string {
+	out := make(map[string]string, len(d.file.tags))
+	for k, v := range d.file.tags {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+// Close releases the slob file.
+func (d *Dictionary) Close() error {
+	return d.file.Close()
+}
+
+func (d *Dictionary) Lookup(word string) []dict.Entry {
+	idxs := d.normIndex[normalize(word, d.caseFold)]
+	if len(idxs) == 0 {
+		return nil
+	}
+	out := make([]dict.Entry, 0, len(idxs))
+	// Refs to different fragments of one article share its blob.
+	seen := make(map[blobRef]bool, len(idxs))
+	for _, i := range idxs {
+		e := d.entries[i]
+		if seen[e.Blob] {
+			continue
+		}
+		seen[e.Blob] = true
+		def, ok := d.render(e)
+		if !ok {
+			continue
+		}
+		out = append(out, dict.Entry{Word: e.Key, Definition: def})
+	}
+	return out
+}
+
+func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
+	if limit <= 0 {
+		limit = 20
+	}
+	pfx := normalize(prefix, d.caseFold)
+	idx := sort.Search(len(d.sortedN), func(i int) bool {
+		return d.sortedN[i] >= pfx
+	})
+	if idx == len(d.sortedN) {
+		return nil
+	}
+	out := make([]dict.Entry, 0, limit)
+	for i := idx; i < len(d.sortedN) && len(out) < limit; i++ {
+		if !strings.HasPrefix(d.sortedN[i], pfx) {
+			break
+		}
+		out = append(out, dict.Entry{Word: d.sortedW[i]})
+	}
+	return out
+}
+
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
+	return out
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
+}
+
+// Resource serves the "~/" refs (stylesheets, images, ...) of the slob.
+// Stylesheets get their url(...) links rewritten and are scoped to the
+// article wrapper.
+func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
+	clean := dict.CleanResourceName(name)
+	if clean == "" {
+		return nil, "", false
+	}
+	b, ok := d.resources[clean]
+	if !ok {
+		b, ok = d.resources[resourcePrefix+clean]
+	}
+	if !ok {
+		return nil, "", false
+	}
+	ct, data, err := d.file.blob(b.Bin, b.Item)
+	if err != nil {
+		log.Printf("slob: failed to read resource %q in %q: %v", name, d.id, err)
+		return nil, "", false
+	}
+	if dict.MediaType(ct) == "text/css" {
+		css := dict.RewriteCSSLinks(string(data), d.id)
+		data = []byte(dict.IsolateCSS(css, d.id, ""))
+	}
+	return data, ct, true
+}
+
+func (d *Dictionary) render(e entry) (string, bool) {
+	ct, data, err := d.file.blob(e.Blob.Bin, e.Blob.Item)
+	if err != nil {
+		log.Printf("slob: failed to read %q in %q: %v", e.Key, d.id, err)
+		return "", false
+	}
+	var body string
+	switch dict.MediaType(ct) {
+	case "text/html":
+		body = dict.RewriteResourceLinks(articleLinks(string(data)), d.id)
+	case "text/plain":
+		body = strings.ReplaceAll(html.EscapeString(string(data)), "\n", "<br>")
+	default:
+		return "", false
+	}
+	return `<div id="gdarticlefrom-` + dict.ScopeID(d.id) + `" class="slob">` + body + `</div>`, true
+}
+
+// articleLinks turns relative <a href> targets, which name other keys of
+// the slob, into entry:// links before resource rewriting.
+func articleLinks(s string) string {
+	return anchorHrefRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := anchorHrefRe.FindStringSubmatch(m)
+		u := strings.TrimSpace(sub[3])
+		if u == "" || strings.HasPrefix(u, "#") || strings.HasPrefix(u, "/") || strings.HasPrefix(u, resourcePrefix) || dict.HasScheme(u) {
+			return m
+		}
+		return sub[1] + sub[2] + "entry://" + u + sub[4]
+	})
+}
+
+func normalize(s string, caseFold bool) string {
+	if caseFold {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,212 @@
+package slob
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+type testItem struct {
+	ctype byte
+	data  string
+}
+
+type testRef struct {
+	key      string
+	bin      uint32
+	item     uint16
+	fragment string
+}
+
+func putTiny(b *bytes.Buffer, s string) {
+	b.WriteByte(byte(len(s)))
+	b.WriteString(s)
+}
+
+func putText(b *bytes.Buffer, s string) {
+	_ = binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+func compress(t *testing.T, compression string, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	switch compression {
+	case "zlib":
+		zw := zlib.NewWriter(&b)
+		_, _ = zw.Write(data)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "lzma2":
+		lw, err := lzma.Writer2Config{}.NewWriter2(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = lw.Write(data)
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		b.Write(data)
+	}
+	return b.Bytes()
+}
+
+// writeSlob builds a slob file with one store item per bin.
+func writeSlob(t *testing.T, path, compression string, tags [][2]string, types []string, refs []testRef, bins [][]testItem) {
+	t.Helper()
+	var refList bytes.Buffer
+	_ = binary.Write(&refList, binary.BigEndian, uint32(len(refs)))
+	var refData bytes.Buffer
+	positions := make([]uint64, len(refs))
+	for i, r := range refs {
+		positions[i] = uint64(refData.Len())
+		putText(&refData, r.key)
+		_ = binary.Write(&refData, binary.BigEndian, r.bin)
+		_ = binary.Write(&refData, binary.BigEndian, r.item)
+		putTiny(&refData, r.fragment)
+	}
+	_ = binary.Write(&refList, binary.BigEndian, positions)
+	refList.Write(refData.Bytes())
+
+	var store bytes.Buffer
+	_ = binary.Write(&store, binary.BigEndian, uint32(len(bins)))
+	var storeData bytes.Buffer
+	binPos := make([]uint64, len(bins))
+	for i, items := range bins {
+		binPos[i] = uint64(storeData.Len())
+		var offsets, contents bytes.Buffer
+		for _, it := range items {
+			_ = binary.Write(&offsets, binary.BigEndian, uint32(contents.Len()))
+			_ = binary.Write(&contents, binary.BigEndian, uint32(len(it.data)))
+			contents.WriteString(it.data)
+		}
+		raw := append(offsets.Bytes(), contents.Bytes()...)
+		comp := compress(t, compression, raw)
+		_ = binary.Write(&storeData, binary.BigEndian, uint32(len(items)))
+		for _, it := range items {
+			storeData.WriteByte(it.ctype)
+		}
+		_ = binary.Write(&storeData, binary.BigEndian, uint32(len(comp)))
+		storeData.Write(comp)
+	}
+	_ = binary.Write(&store, binary.BigEndian, binPos)
+	store.Write(storeData.Bytes())
+
+	var header bytes.Buffer
+	header.Write(slobMagic)
+	header.Write(make([]byte, 16))
+	putTiny(&header, "utf-8")
+	putTiny(&header, compression)
+	header.WriteByte(byte(len(tags)))
+	for _, kv := range tags {
+		putTiny(&header, kv[0])
+		// Editable tags are NUL padded to 255 bytes.
+		padded := kv[1] + strings.Repeat("\x00", 255-len(kv[1]))
+		putTiny(&header, padded)
+	}
+	header.WriteByte(byte(len(types)))
+	for _, ct := range types {
+		putText(&header, ct)
+	}
+	blobCount := 0
+	for _, items := range bins {
+		blobCount += len(items)
+	}
+	_ = binary.Write(&header, binary.BigEndian, uint32(blobCount))
+	storeOffset := header.Len() + 16 + refList.Len()
+	size := storeOffset + store.Len()
+	_ = binary.Write(&header, binary.BigEndian, uint64(storeOffset))
+	_ = binary.Write(&header, binary.BigEndian, uint64(size))
+
+	out := append(header.Bytes(), refList.Bytes()...)
+	out = append(out, store.Bytes()...)
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	types := []string{"text/html; charset=utf-8", "text/css", "text/plain; charset=utf-8"}
+	// The articles are not the first item of their bin, so the offset
+	// tables are exercised.
+	bins := [][]testItem{
+		{
+			{0, "<p>padding</p>"},
+			{0, `<p>a fruit, see <a href="banana">banana</a></p><link href="~/css/style.css">`},
+		},
+		{
+			{1, ".hw { color: red; background: url(img/bg.png) }"},
+			{2, "long\nyellow fruit"},
+		},
+	}
+	refs := []testRef{
+		{key: "Apple", bin: 0, item: 1},
+		{key: "apples", bin: 0, item: 1, fragment: "plural"},
+		{key: "banana", bin: 1, item: 1},
+		{key: "~/css/style.css", bin: 1, item: 0},
+	}
+	for _, compression := range []string{"lzma2", "zlib", ""} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.slob")
+			writeSlob(t, path, compression, [][2]string{{"label", "Test Slob"}}, types, refs, bins)
+			for _, pass := range []string{"build", "cached"} {
+				d, err := Load("t", "", path, true)
+				if err != nil {
+					t.Fatalf("%s: %v", pass, err)
+				}
+				if d.Name() != "Test Slob" {
+					t.Fatalf("%s: name = %q", pass, d.Name())
+				}
+				got := d.Lookup("apple")
+				if len(got) != 1 || got[0].Word != "Apple" ||
+					!strings.Contains(got[0].Definition, "a fruit") ||
+					!strings.Contains(got[0].Definition, `href="/entry?dict=t&q=banana"`) ||
+					strings.Contains(got[0].Definition, "padding") {
+					t.Fatalf("%s: lookup apple = %+v", pass, got)
+				}
+				got = d.Lookup("banana")
+				if len(got) != 1 || !strings.Contains(got[0].Definition, "long<br>yellow fruit") {
+					t.Fatalf("%s: lookup banana = %+v", pass, got)
+				}
+				if got := d.Lookup("style.css"); len(got) != 0 {
+					t.Fatalf("%s: resource ref listed as article: %+v", pass, got)
+				}
+				pre := d.Prefix("app", 10)
+				if len(pre) != 2 || pre[0].Word != "Apple" || pre[1].Word != "apples" {
+					t.Fatalf("%s: prefix = %+v", pass, pre)
+				}
+				data, ct, ok := d.Resource("css/style.css")
+				if !ok || ct != "text/css" ||
+					string(data) != "#gdarticlefrom-t .hw { color: red; background: url(/resource/img/bg.png?dict=t) }" {
+					t.Fatalf("%s: resource = %q, %q, %v", pass, data, ct, ok)
+				}
+				// Article links keep the "~/" prefix.
+				if _, _, ok := d.Resource("~/css/style.css"); !ok {
+					t.Fatalf("%s: resource ~/css/style.css not found", pass)
+				}
+				if _, _, ok := d.Resource("css/missing.css"); ok {
+					t.Fatalf("%s: missing resource found", pass)
+				}
+				if err := d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.slob")
+	writeSlob(t, path, "xz", nil, nil, nil, nil)
+	if _, err := Load("t", "", path, true); err == nil || !strings.Contains(err.Error(), "unsupported compression") {
+		t.Fatalf("err = %v", err)
+	}
+}
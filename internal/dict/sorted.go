@@ -0,0 +1,26 @@
+package dict
+
+import (
+	"sort"
+	"strings"
+)
+
+// SortedPrefixer is implemented by backends that keep their headwords
+// sorted by normalized form. PrefixSorted returns, in that order, up to
+// limit normalized forms that start with the normalized prefix, together
+// with their headwords.
+type SortedPrefixer interface {
+	PrefixSorted(prefix string, limit int) (norm, words []string)
+}
+
+// PrefixRange returns the run of sortedN starting with pfx, cut to limit,
+// and the matching run of sortedW. The slices share storage with the
+// index and must not be modified.
+func PrefixRange(sortedN, sortedW []string, pfx string, limit int) ([]string, []string) {
+	start := sort.SearchStrings(sortedN, pfx)
+	end := start
+	for end < len(sortedN) && end-start < limit && strings.HasPrefix(sortedN[end], pfx) {
+		end++
+	}
+	return sortedN[start:end:end], sortedW[start:end:end]
+}
@@ -8,7 +8,7 @@ import (
 	"strings"
 )
 
-const cacheVersion = 1
+const cacheVersion = 2
 
 type sourceSig struct {
 	Path  string
@@ -17,13 +17,15 @@ type sourceSig struct {
 }
 
 type cacheIndex struct {
-	Version      int
-	CaseFold     bool
-	Sources      []sourceSig
-	Entries      []entry
-	NormToEntry  map[string][]int
-	SortedNorm   []string
-	SortedWord   []string
+	Version       int
+	CaseFold      bool
+	Sources       []sourceSig
+	Entries       []entry
+	NormToEntry   map[string][]int
+	Synonyms      []synonym
+	NormToSyn     map[string][]int
+	SortedNorm    []string
+	SortedWord    []string
 	SourceIfopath string
 }
 
@@ -33,6 +35,12 @@ type entry struct {
 	Size   uint32
 }
 
+// synonym is a .syn record: an alternative form pointing at an .idx entry.
+type synonym struct {
+	Word  string
+	Entry int
+}
+
 func cachePath(ifoPath string) string {
 	return ifoPath + ".gdapi.sdict.idx"
 }
@@ -96,17 +104,20 @@ func saveCache(ifoPath string, idx *cacheIndex) error {
 	return nil
 }
 
-func buildSourceSig(ifoPath string) ([]sourceSig, error) {
+// SourceFiles returns the .ifo file and the .idx, .dict and .syn files
+// found next to it.
+func SourceFiles(ifoPath string) []string {
 	paths := []string{ifoPath}
-	idxPath, err := findIdxPath(ifoPath)
-	if err == nil {
-		paths = append(paths, idxPath)
-	}
-	dictPath, err := findDictPath(ifoPath)
-	if err == nil {
-		paths = append(paths, dictPath)
+	for _, find := range []func(string) (string, error){findIdxPath, findDictPath, findSynPath} {
+		if p, err := find(ifoPath); err == nil {
+			paths = append(paths, p)
+		}
 	}
+	return paths
+}
 
+func buildSourceSig(ifoPath string) ([]sourceSig, error) {
+	paths := SourceFiles(ifoPath)
 	out := make([]sourceSig, 0, len(paths))
 	for _, p := range paths {
 		clean := filepath.Clean(p)
@@ -175,3 +186,26 @@ func findDictPath(ifoPath string) (string, error) {
 	}
 	return "", os.ErrNotExist
 }
+
+func findSynPath(ifoPath string) (string, error) {
+	base := strings.TrimSuffix(ifoPath, filepath.Ext(ifoPath))
+	ext := []string{
+		".syn",
+		".syn.gz",
+		".syn.GZ",
+		".syn.dz",
+		".syn.DZ",
+		".SYN",
+		".SYN.gz",
+		".SYN.GZ",
+		".SYN.dz",
+		".SYN.DZ",
+	}
+	for _, e := range ext {
+		p := base + e
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
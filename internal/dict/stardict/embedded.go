@@ -0,0 +1,111 @@
+package stardict
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ianlewis/go-stardict/dict"
+	"github.com/ianlewis/go-stardict/idx"
+)
+
+// embeddedPrefix names the synthetic resources that expose 'W' (wav) and 'P'
+// (picture) data stored inline in .dict entries:
+//
+//	__embedded/<entry offset>-<part index>.<ext>
+const embeddedPrefix = "__embedded/"
+
+// embeddedName returns the resource name of part i of the entry at offset.
+func embeddedName(offset uint64, part int, d *dict.Data) string {
+	return embeddedPrefix + strconv.FormatUint(offset, 10) + "-" + strconv.Itoa(part) + embeddedExt(d)
+}
+
+func embeddedExt(d *dict.Data) string {
+	if d.Type == dict.WavType {
+		return ".wav"
+	}
+	switch http.DetectContentType(d.Data) {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/bmp":
+		return ".bmp"
+	case "image/webp":
+		return ".webp"
+	case "image/x-icon":
+		return ".ico"
+	default:
+		return ".bin"
+	}
+}
+
+// parseEmbeddedName splits a synthetic resource name into entry offset and
+// part index.
+func parseEmbeddedName(name string) (uint64, int, bool) {
+	if !strings.HasPrefix(name, embeddedPrefix) {
+		return 0, 0, false
+	}
+	rest := strings.TrimPrefix(name, embeddedPrefix)
+	if dot := strings.IndexByte(rest, '.'); dot >= 0 {
+		rest = rest[:dot]
+	}
+	offStr, partStr, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	off, err := strconv.ParseUint(offStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	part, err := strconv.Atoi(partStr)
+	if err != nil || part < 0 {
+		return 0, 0, false
+	}
+	return off, part, true
+}
+
+// entrySizes maps .dict offsets to entry sizes so synthetic names can only
+// address real entries.
+type entrySizes struct {
+	once  sync.Once
+	sizes map[uint64]uint32
+}
+
+func (s *entrySizes) lookup(entries []entry, offset uint64) (uint32, bool) {
+	s.once.Do(func() {
+		s.sizes = make(map[uint64]uint32, len(entries))
+		for _, e := range entries {
+			s.sizes[e.Offset] = e.Size
+		}
+	})
+	size, ok := s.sizes[offset]
+	return size, ok
+}
+
+func (d *Dictionary) embeddedResource(name string) ([]byte, string, bool) {
+	offset, part, ok := parseEmbeddedName(name)
+	if !ok {
+		return nil, "", false
+	}
+	size, ok := d.sizes.lookup(d.entries, offset)
+	if !ok {
+		return nil, "", false
+	}
+	w, err := d.dict.Word(&idx.Word{Offset: offset, Size: size})
+	if err != nil || part >= len(w.Data) {
+		return nil, "", false
+	}
+	data := w.Data[part]
+	switch data.Type {
+	case dict.WavType:
+		return data.Data, "audio/wav", true
+	case dict.PictureType:
+		return data.Data, http.DetectContentType(data.Data), true
+	default:
+		return nil, "", false
+	}
+}
@@ -0,0 +1,204 @@
+package stardict
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sagerenn/mdict/internal/dict/dictzip"
+)
+
+// resourceDB reads the StarDict resource storage database: res.rifo
+// describes it, res.ridx lists file names with their offset and size, and
+// res.rdic (optionally dictzipped) holds the file contents.
+type resourceDB struct {
+	dir string
+
+	// mu guards everything below; the index is read on first use and
+	// never after Close.
+	mu     sync.Mutex
+	opened bool
+	closed bool
+	files  map[string]resEntry
+	err    error
+	rdic   *dictzip.Reader
+}
+
+type resEntry struct {
+	offset uint64
+	size   uint32
+}
+
+// openResourceDB returns the resource database next to the .ifo file, or nil
+// when the dictionary has none. The index is read on first use.
+func openResourceDB(ifoPath string) *resourceDB {
+	dir := filepath.Dir(ifoPath)
+	if _, err := os.Stat(filepath.Join(dir, "res.rifo")); err != nil {
+		return nil
+	}
+	return &resourceDB{dir: dir}
+}
+
+func (db *resourceDB) read(name string) ([]byte, bool) {
+	if db == nil {
+		return nil, false
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, false
+	}
+	if !db.opened {
+		db.opened = true
+		db.err = db.open()
+		if db.err != nil {
+			log.Printf("stardict: failed to open resource database in %q: %v", db.dir, db.err)
+		}
+	}
+	if db.err != nil {
+		return nil, false
+	}
+	e, ok := db.files[name]
+	if !ok {
+		e, ok = db.files[strings.ToLower(name)]
+	}
+	if !ok {
+		return nil, false
+	}
+	buf, err := db.rdic.Bytes(int64(e.offset), int(e.size))
+	if err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("stardict: failed to read resource %q: %v", name, err)
+		}
+		return nil, false
+	}
+	return buf, true
+}
+
+func (db *resourceDB) open() error {
+	offsetBits, err := readRifo(filepath.Join(db.dir, "res.rifo"))
+	if err != nil {
+		return err
+	}
+	files, err := readRidx(db.dir, offsetBits)
+	if err != nil {
+		return err
+	}
+	db.files = files
+
+	rdic, err := dictzip.Open(filepath.Join(db.dir, "res.rdic"))
+	if errors.Is(err, os.ErrNotExist) {
+		rdic, err = dictzip.Open(filepath.Join(db.dir, "res.rdic.dz"))
+	}
+	if err != nil {
+		return err
+	}
+	db.rdic = rdic
+	return nil
+}
+
+// Close releases res.rdic. Reads after Close fail instead of reopening it.
+func (db *resourceDB) Close() error {
+	if db == nil {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	if db.rdic == nil {
+		return nil
+	}
+	return db.rdic.Close()
+}
+
+// readRifo validates res.rifo and returns the offset width of res.ridx.
+func readRifo(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "StarDict's storage ifo file" {
+		return 0, errors.New("res.rifo: bad magic")
+	}
+	bits := 32
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok || key != "idxoffsetbits" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || (n != 32 && n != 64) {
+			return 0, fmt.Errorf("res.rifo: invalid idxoffsetbits %q", value)
+		}
+		bits = n
+	}
+	return bits, sc.Err()
+}
+
+// readRidx reads res.ridx (or res.ridx.gz) into a name index. Names are
+// also registered lower-cased so lookups tolerate case differences.
+func readRidx(dir string, offsetBits int) (map[string]resEntry, error) {
+	var r io.Reader
+	f, err := os.Open(filepath.Join(dir, "res.ridx"))
+	if err != nil {
+		f, err = os.Open(filepath.Join(dir, "res.ridx.gz"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	} else {
+		defer f.Close()
+		r = f
+	}
+
+	offLen := offsetBits / 8
+	br := bufio.NewReader(r)
+	files := make(map[string]resEntry)
+	num := make([]byte, offLen+4)
+	for {
+		name, err := br.ReadString(0)
+		if err != nil {
+			if errors.Is(err, io.EOF) && name == "" {
+				break
+			}
+			return nil, fmt.Errorf("res.ridx: %w", err)
+		}
+		if _, err := io.ReadFull(br, num); err != nil {
+			return nil, fmt.Errorf("res.ridx: %w", err)
+		}
+		var e resEntry
+		if offLen == 8 {
+			e.offset = binary.BigEndian.Uint64(num)
+		} else {
+			e.offset = uint64(binary.BigEndian.Uint32(num))
+		}
+		e.size = binary.BigEndian.Uint32(num[offLen:])
+		name = strings.TrimSuffix(name, "\x00")
+		files[name] = e
+		if lower := strings.ToLower(name); lower != name {
+			if _, ok := files[lower]; !ok {
+				files[lower] = e
+			}
+		}
+	}
+	return files, nil
+}
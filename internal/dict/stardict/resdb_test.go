@@ -0,0 +1,102 @@
+package stardict
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+type testResource struct {
+	name         string
+	offset, size uint32
+}
+
+// writeResourceDB writes res.rifo, res.ridx and res.rdic into dir. Index
+// records may point anywhere in rdic, or past its end.
+func writeResourceDB(t *testing.T, dir, rdic string, index []testResource) {
+	t.Helper()
+	var ridx []byte
+	for _, r := range index {
+		ridx = append(ridx, r.name...)
+		ridx = append(ridx, 0)
+		ridx = binary.BigEndian.AppendUint32(ridx, r.offset)
+		ridx = binary.BigEndian.AppendUint32(ridx, r.size)
+	}
+	writeFile(t, filepath.Join(dir, "res.rifo"), []byte("StarDict's storage ifo file\nversion=3.0.0\nfilecount=3\n"))
+	writeFile(t, filepath.Join(dir, "res.ridx"), ridx)
+	writeFile(t, filepath.Join(dir, "res.rdic"), []byte(rdic))
+}
+
+func loadWithResourceDB(t *testing.T) *Dictionary {
+	t.Helper()
+	dir := t.TempDir()
+	ifoPath := writeStarDict(t, dir, "test", "m", []testEntry{{"apple", "a red fruit"}})
+	writeResourceDB(t, dir, "PNGDATA.hw { color: red }", []testResource{
+		{"img/Logo.png", 0, 7},
+		{"style.css", 7, 18},
+		{"broken.png", 20, 100},
+	})
+	d, err := Load("t", "", ifoPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestResourceDB(t *testing.T) {
+	d := loadWithResourceDB(t)
+	defer d.Close()
+
+	if data, ct, ok := d.Resource("img/Logo.png"); !ok || string(data) != "PNGDATA" || ct != "image/png" {
+		t.Fatalf("Resource(img/Logo.png) = %q, %q, %v", data, ct, ok)
+	}
+	// Names are also matched lower-cased.
+	if data, _, ok := d.Resource("img/logo.png"); !ok || string(data) != "PNGDATA" {
+		t.Fatalf("Resource(img/logo.png) = %q, %v", data, ok)
+	}
+	if data, _, ok := d.Resource("style.css"); !ok || string(data) != "#gdarticlefrom-t .hw { color: red }" {
+		t.Fatalf("Resource(style.css) = %q, %v", data, ok)
+	}
+
+	for _, name := range []string{
+		"missing.png",
+		"broken.png", // extends past the end of res.rdic
+		"../res.rdic",
+		"../test.ifo",
+		"img/../../test.dict",
+		"/etc/passwd",
+	} {
+		if data, _, ok := d.Resource(name); ok {
+			t.Fatalf("Resource(%q) = %q", name, data)
+		}
+	}
+}
+
+func TestResourceDBReadAfterClose(t *testing.T) {
+	d := loadWithResourceDB(t)
+	if _, _, ok := d.Resource("img/Logo.png"); !ok {
+		t.Fatal("resource not found before Close")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := d.Resource("img/Logo.png"); ok {
+		t.Fatal("resource served after Close")
+	}
+	if err := d.resDB.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	// Closing before the first read must not leave a lazily opened
+	// res.rdic behind.
+	d = loadWithResourceDB(t)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := d.Resource("img/Logo.png"); ok {
+		t.Fatal("resource served after Close")
+	}
+	if d.resDB.rdic != nil {
+		t.Fatal("res.rdic reopened after Close")
+	}
+}
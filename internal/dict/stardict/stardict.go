@@ -1,7 +1,10 @@
 package stardict
 
 import (
+	"compress/gzip"
+	"context"
 	"html"
+	"io"
 	"mime"
 	"os"
 	"path/filepath"
@@ -9,10 +12,12 @@ import (
 	"strings"
 
 	gd "github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/dict/xdxf"
 
 	std "github.com/ianlewis/go-stardict"
 	"github.com/ianlewis/go-stardict/dict"
 	"github.com/ianlewis/go-stardict/idx"
+	"github.com/ianlewis/go-stardict/syn"
 )
 
 type Dictionary struct {
@@ -23,10 +28,14 @@ type Dictionary struct {
 	dict        *dict.Dict
 	entries     []entry
 	normMap     map[string][]int
+	synonyms    []synonym
+	synMap      map[string][]int
 	sortedN     []string
 	sortedW     []string
 	ifoPath     string
 	resourceDir string
+	resDB       *resourceDB
+	sizes       entrySizes
 }
 
 func Load(id, name, ifoPath string, caseFold bool) (*Dictionary, error) {
@@ -52,10 +61,13 @@ func Load(id, name, ifoPath string, caseFold bool) (*Dictionary, error) {
 			dict:        d,
 			entries:     cached.Entries,
 			normMap:     cached.NormToEntry,
+			synonyms:    cached.Synonyms,
+			synMap:      cached.NormToSyn,
 			sortedN:     cached.SortedNorm,
 			sortedW:     cached.SortedWord,
 			ifoPath:     ifoPath,
 			resourceDir: base + ".files",
+			resDB:       openResourceDB(ifoPath),
 		}, nil
 	}
 
@@ -89,6 +101,17 @@ func Load(id, name, ifoPath string, caseFold bool) (*Dictionary, error) {
 		return nil, err
 	}
 
+	synonyms, err := readSynonyms(ifoPath, len(entries))
+	if err != nil {
+		return nil, err
+	}
+	synMap := make(map[string][]int)
+	for i, syn := range synonyms {
+		norm := normalize(syn.Word, caseFold)
+		synMap[norm] = append(synMap[norm], i)
+		items = append(items, item{norm: norm, word: syn.Word})
+	}
+
 	sort.Slice(items, func(i, j int) bool {
 		if items[i].norm == items[j].norm {
 			return items[i].word < items[j].word
@@ -107,6 +130,8 @@ func Load(id, name, ifoPath string, caseFold bool) (*Dictionary, error) {
 		Sources:     mustSources(ifoPath),
 		Entries:     entries,
 		NormToEntry: normMap,
+		Synonyms:    synonyms,
+		NormToSyn:   synMap,
 		SortedNorm:  sortedN,
 		SortedWord:  sortedW,
 	})
@@ -119,10 +144,13 @@ func Load(id, name, ifoPath string, caseFold bool) (*Dictionary, error) {
 		dict:        d,
 		entries:     entries,
 		normMap:     normMap,
+		synonyms:    synonyms,
+		synMap:      synMap,
 		sortedN:     sortedN,
 		sortedW:     sortedW,
 		ifoPath:     ifoPath,
 		resourceDir: strings.TrimSuffix(ifoPath, filepath.Ext(ifoPath)) + ".files",
+		resDB:       openResourceDB(ifoPath),
 	}, nil
 }
 
@@ -134,14 +162,26 @@ func (d *Dictionary) Name() string {
 	return d.name
 }
 
+// Close releases the .dict file and the resource database.
+func (d *Dictionary) Close() error {
+	err := d.dict.Close()
+	if cerr := d.resDB.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (d *Dictionary) Lookup(word string) []gd.Entry {
 	norm := normalize(word, d.caseFold)
 	idxs := d.normMap[norm]
-	if len(idxs) == 0 {
+	syns := d.synMap[norm]
+	if len(idxs) == 0 && len(syns) == 0 {
 		return nil
 	}
-	out := make([]gd.Entry, 0, len(idxs))
+	out := make([]gd.Entry, 0, len(idxs)+len(syns))
+	seen := make(map[int]bool, len(idxs)+len(syns))
 	for _, i := range idxs {
+		seen[i] = true
 		e := d.entries[i]
 		def, ok := d.readDefinition(e)
 		if !ok {
@@ -149,6 +189,20 @@ func (d *Dictionary) Lookup(word string) []gd.Entry {
 		}
 		out = append(out, gd.Entry{Word: e.Word, Definition: def})
 	}
+	// Synonym hits resolve to the original entry and carry the matched form.
+	for _, si := range syns {
+		syn := d.synonyms[si]
+		if seen[syn.Entry] {
+			continue
+		}
+		seen[syn.Entry] = true
+		e := d.entries[syn.Entry]
+		def, ok := d.readDefinition(e)
+		if !ok {
+			continue
+		}
+		out = append(out, gd.Entry{Word: e.Word, Definition: def, Synonym: syn.Word})
+	}
 	return out
 }
 
@@ -173,40 +227,45 @@ func (d *Dictionary) Prefix(prefix string, limit int) []gd.Entry {
 	return out
 }
 
-func (d *Dictionary) Search(query string, limit int) []gd.Entry {
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
 	if limit <= 0 {
 		limit = 20
 	}
-	q := normalize(query, d.caseFold)
-	out := make([]gd.Entry, 0, limit)
-	for i, n := range d.sortedN {
-		if strings.Contains(n, q) {
-			out = append(out, gd.Entry{Word: d.sortedW[i]})
-			if len(out) >= limit {
-				break
-			}
-		}
-	}
+	return gd.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []gd.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
 	return out
 }
 
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]gd.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return gd.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
+}
+
 func (d *Dictionary) readDefinition(e entry) (string, bool) {
 	word := idx.Word{Word: e.Word, Offset: e.Offset, Size: e.Size}
 	w, err := d.dict.Word(&word)
 	if err != nil {
 		return "", false
 	}
-	def := dataToHTML(w.Data, d.id)
+	def := dataToHTML(w.Data, d.id, e.Offset)
 	if def != "" {
 		def = `<div id="gdarticlefrom-` + gd.ScopeID(d.id) + `" class="stardict">` + def + `</div>`
 	}
 	return def, true
 }
 
-func dataToHTML(data []*dict.Data, dictID string) string {
+func dataToHTML(data []*dict.Data, dictID string, offset uint64) string {
 	var b strings.Builder
-	for _, d := range data {
-		s := strings.TrimSpace(renderData(d, dictID))
+	for i, d := range data {
+		s := strings.TrimSpace(renderData(d, dictID, offset, i))
 		if s == "" {
 			continue
 		}
@@ -225,6 +284,56 @@ func normalize(s string, caseFold bool) string {
 	return strings.TrimSpace(s)
 }
 
+// readSynonyms reads the optional .syn file. Records pointing outside the
+// .idx entry range are dropped.
+func readSynonyms(ifoPath string, entryCount int) ([]synonym, error) {
+	synPath, err := findSynPath(ifoPath)
+	if err != nil {
+		return nil, nil
+	}
+	f, err := os.Open(synPath)
+	if err != nil {
+		return nil, err
+	}
+	var r io.ReadCloser = f
+	if ext := strings.ToLower(filepath.Ext(synPath)); ext == ".gz" || ext == ".dz" {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		r = readCloser{Reader: zr, close: f.Close}
+	}
+	sc, err := syn.NewScanner(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	defer sc.Close()
+
+	var out []synonym
+	for sc.Scan() {
+		w := sc.Word()
+		if w.Word == "" || int(w.OriginalWordIndex) >= entryCount {
+			continue
+		}
+		out = append(out, synonym{Word: w.Word, Entry: int(w.OriginalWordIndex)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
 func mustSources(ifoPath string) []sourceSig {
 	sigs, err := buildSourceSig(ifoPath)
 	if err != nil {
@@ -241,22 +350,32 @@ func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
 	if clean == "" {
 		return nil, "", false
 	}
+	if strings.HasPrefix(clean, embeddedPrefix) {
+		return d.embeddedResource(clean)
+	}
 	if d.resourceDir != "" {
 		p := filepath.Join(d.resourceDir, filepath.FromSlash(clean))
 		if data, err := os.ReadFile(p); err == nil {
-			if isCSSFile(clean) {
-				css := string(data)
-				css = gd.RewriteCSSLinks(css, d.id)
-				css = gd.IsolateCSS(css, d.id, "")
-				data = []byte(css)
-			}
-			return data, mime.TypeByExtension(filepath.Ext(p)), true
+			return d.processResource(clean, data), mime.TypeByExtension(filepath.Ext(p)), true
 		}
 	}
+	if data, ok := d.resDB.read(clean); ok {
+		return d.processResource(clean, data), mime.TypeByExtension(filepath.Ext(clean)), true
+	}
 	return nil, "", false
 }
 
-func renderData(d *dict.Data, dictID string) string {
+func (d *Dictionary) processResource(name string, data []byte) []byte {
+	if !isCSSFile(name) {
+		return data
+	}
+	css := string(data)
+	css = gd.RewriteCSSLinks(css, d.id)
+	css = gd.IsolateCSS(css, d.id, "")
+	return []byte(css)
+}
+
+func renderData(d *dict.Data, dictID string, offset uint64, part int) string {
 	switch d.Type {
 	case dict.HTMLType:
 		htmlText := `<div class="sdct_h">` + string(d.Data) + `</div>`
@@ -278,11 +397,13 @@ func renderData(d *dict.Data, dictID string) string {
 	case dict.ResourceFileListType:
 		return renderResourceList(string(d.Data), dictID)
 	case dict.XDXFType:
-		return xdxfToHTML(string(d.Data), dictID)
+		return xdxf.ToHTML(string(d.Data), dictID, nil)
 	case dict.WavType:
-		return `<div class="sdct_W">(an embedded .wav file)</div>`
+		src := gd.ResourceURL(dictID, embeddedName(offset, part, d))
+		return `<div class="sdct_W"><audio controls src="` + src + `"></audio></div>`
 	case dict.PictureType:
-		return `<div class="sdct_P">(an embedded picture file)</div>`
+		src := gd.ResourceURL(dictID, embeddedName(offset, part, d))
+		return `<div class="sdct_P"><img src="` + src + `"/></div>`
 	default:
 		if d.Type >= 'a' && d.Type <= 'z' {
 			return `<div class="sdct_unknown">` + html.EscapeString(string(d.Data)) + `</div>`
@@ -0,0 +1,198 @@
+package stardict
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	word string
+	data string
+}
+
+// writeStarDict writes name.ifo, name.idx and name.dict into dir and
+// returns the .ifo path. Entries are stored in the given order.
+func writeStarDict(t *testing.T, dir, name, sameTypeSequence string, entries []testEntry) string {
+	t.Helper()
+	var idx, data []byte
+	for _, e := range entries {
+		idx = append(idx, e.word...)
+		idx = append(idx, 0)
+		idx = binary.BigEndian.AppendUint32(idx, uint32(len(data)))
+		idx = binary.BigEndian.AppendUint32(idx, uint32(len(e.data)))
+		data = append(data, e.data...)
+	}
+	ifo := "StarDict's dict ifo file\nversion=2.4.2\nbookname=Test StarDict\n" +
+		"wordcount=" + strconv.Itoa(len(entries)) + "\n" +
+		"idxfilesize=" + strconv.Itoa(len(idx)) + "\n"
+	if sameTypeSequence != "" {
+		ifo += "sametypesequence=" + sameTypeSequence + "\n"
+	}
+	base := filepath.Join(dir, name)
+	writeFile(t, base+".ifo", []byte(ifo))
+	writeFile(t, base+".idx", idx)
+	writeFile(t, base+".dict", data)
+	return base + ".ifo"
+}
+
+// writeSyn writes a .syn file mapping each word to an .idx entry index.
+func writeSyn(t *testing.T, path string, words []string, entries []uint32) {
+	t.Helper()
+	var b []byte
+	for i, w := range words {
+		b = append(b, w...)
+		b = append(b, 0)
+		b = binary.BigEndian.AppendUint32(b, entries[i])
+	}
+	writeFile(t, path, b)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSynonyms(t *testing.T) {
+	dir := t.TempDir()
+	ifoPath := writeStarDict(t, dir, "test", "m", []testEntry{
+		{"apple", "a red fruit"},
+		{"banana", "a long yellow fruit"},
+	})
+	writeSyn(t, filepath.Join(dir, "test.syn"), []string{"Apples", "plantain", "ghost"}, []uint32{0, 1, 7})
+
+	for _, pass := range []string{"build", "cached"} {
+		d, err := Load("t", "", ifoPath, true)
+		if err != nil {
+			t.Fatalf("%s: %v", pass, err)
+		}
+		got := d.Lookup("apples")
+		if len(got) != 1 || got[0].Word != "apple" || got[0].Synonym != "Apples" ||
+			!strings.Contains(got[0].Definition, "a red fruit") {
+			t.Fatalf("%s: Lookup(apples) = %+v", pass, got)
+		}
+		got = d.Lookup("apple")
+		if len(got) != 1 || got[0].Synonym != "" {
+			t.Fatalf("%s: Lookup(apple) = %+v", pass, got)
+		}
+		if got := d.Lookup("ghost"); len(got) != 0 {
+			t.Fatalf("%s: out-of-range synonym resolved: %+v", pass, got)
+		}
+		pre := d.Prefix("pl", 10)
+		if len(pre) != 1 || pre[0].Word != "plantain" {
+			t.Fatalf("%s: Prefix(pl) = %+v", pass, pre)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCacheTracksSyn(t *testing.T) {
+	dir := t.TempDir()
+	ifoPath := writeStarDict(t, dir, "test", "m", []testEntry{
+		{"apple", "a red fruit"},
+		{"banana", "a long yellow fruit"},
+	})
+	synPath := filepath.Join(dir, "test.syn")
+	load := func(word string) int {
+		t.Helper()
+		d, err := Load("t", "", ifoPath, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		return len(d.Lookup(word))
+	}
+
+	if n := load("plantain"); n != 0 {
+		t.Fatalf("plantain found before the .syn existed")
+	}
+	if _, err := os.Stat(cachePath(ifoPath)); err != nil {
+		t.Fatalf("no cache written: %v", err)
+	}
+
+	// A new .syn file invalidates the cache.
+	writeSyn(t, synPath, []string{"plantain"}, []uint32{1})
+	if n := load("plantain"); n != 1 {
+		t.Fatalf("plantain not found after adding the .syn")
+	}
+
+	// So does a changed one, even with the same size.
+	writeSyn(t, synPath, []string{"plantaim"}, []uint32{1})
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(synPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if n := load("plantain"); n != 0 {
+		t.Fatalf("stale synonym served from the cache")
+	}
+	if n := load("plantaim"); n != 1 {
+		t.Fatalf("plantaim not found after changing the .syn")
+	}
+}
+
+// filePart encodes a 'W'/'P' style part: type, big-endian size, data.
+func filePart(typ byte, data string) string {
+	b := []byte{typ}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return string(append(b, data...))
+}
+
+var resourceSrcRe = regexp.MustCompile(`src="/resource/([^"?]+)\?dict=t"`)
+
+func TestEmbeddedResources(t *testing.T) {
+	const wav, png = "RIFF\x24\x00\x00\x00WAVEfmt ", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	dir := t.TempDir()
+	ifoPath := writeStarDict(t, dir, "test", "", []testEntry{
+		{"apple", "ma red fruit\x00"},
+		{"bell", "ma ringing bell\x00" + filePart('W', wav) + filePart('P', png)},
+	})
+	d, err := Load("t", "", ifoPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	got := d.Lookup("bell")
+	if len(got) != 1 {
+		t.Fatalf("Lookup(bell) = %+v", got)
+	}
+	var names []string
+	for _, m := range resourceSrcRe.FindAllStringSubmatch(got[0].Definition, -1) {
+		names = append(names, m[1])
+	}
+	// The entry starts after apple's 13 bytes; parts 1 and 2 follow the text.
+	if strings.Join(names, ",") != "__embedded/13-1.wav,__embedded/13-2.png" {
+		t.Fatalf("resource names = %v in %s", names, got[0].Definition)
+	}
+	want := []struct{ data, ct string }{{wav, "audio/wav"}, {png, "image/png"}}
+	for i, name := range names {
+		data, ct, ok := d.Resource(name)
+		if !ok || string(data) != want[i].data || ct != want[i].ct {
+			t.Fatalf("Resource(%q) = %q, %q, %v", name, data, ct, ok)
+		}
+	}
+
+	for _, name := range []string{
+		"__embedded/13-0.txt", // text part
+		"__embedded/13-3.wav", // past the last part
+		"__embedded/5-1.wav",  // not an entry offset
+		"__embedded/13.wav",
+		"__embedded/x-1.wav",
+	} {
+		if _, _, ok := d.Resource(name); ok {
+			t.Fatalf("Resource(%q) served", name)
+		}
+	}
+}
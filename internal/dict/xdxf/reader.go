@@ -0,0 +1,209 @@
+package xdxf
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// source is what a scan of an XDXF file yields: articles located by byte
+// range, the dictionary description and the abbreviation table.
+type source struct {
+	info     map[string]string
+	abbrev   map[string]string
+	articles []article
+}
+
+// scanner state for the element currently collecting text.
+type collector struct {
+	depth int
+	text  strings.Builder
+	// noOpt receives the same text minus <opt> content (headwords only).
+	noOpt    strings.Builder
+	optDepth int
+}
+
+// readSource scans an XDXF file without keeping article bodies. Both the
+// old (visual) layout, with <full_name> and <ar> directly under <xdxf>, and
+// the logical layout, with <meta_info> and <lexicon>, are accepted.
+func readSource(path string) (*source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := xml.NewDecoder(bufio.NewReader(f))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	src := &source{info: make(map[string]string), abbrev: make(map[string]string)}
+	var (
+		stack []string
+
+		inArticle bool
+		artStart  int64
+		artKeys   []string
+		key       *collector
+
+		meta *collector
+		// Abbreviation definition being read: its keys and values.
+		abbrKeys, abbrVals []string
+		abbrPart           *collector
+	)
+	parent := func() string {
+		if len(stack) < 2 {
+			return ""
+		}
+		return stack[len(stack)-2]
+	}
+
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("xdxf: %s: %w", path, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			stack = append(stack, name)
+			depth := len(stack)
+			switch {
+			case name == "xdxf":
+				for _, a := range t.Attr {
+					switch v := strings.TrimSpace(a.Value); strings.ToLower(a.Name.Local) {
+					case "lang_from":
+						src.info["lang_from"] = v
+					case "lang_to":
+						src.info["lang_to"] = v
+					case "format":
+						src.info["format"] = v
+					case "revision":
+						src.info["revision"] = v
+					}
+				}
+			case name == "ar":
+				inArticle = true
+				artStart = dec.InputOffset()
+				artKeys = nil
+			case inArticle:
+				if name == "k" && depth == articleDepth(stack)+1 {
+					key = &collector{depth: depth}
+				} else if name == "opt" && key != nil {
+					key.optDepth++
+				}
+			case name == "abr_def" || name == "abbr_def":
+				abbrKeys, abbrVals = nil, nil
+			case (name == "k" || name == "abbr_k" || name == "v" || name == "abbr_v") && isAbbrDef(parent()):
+				abbrPart = &collector{depth: depth}
+			case isInfoElement(name) && (parent() == "xdxf" || parent() == "meta_info"):
+				meta = &collector{depth: depth}
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			depth := len(stack)
+			switch {
+			case name == "ar" && inArticle:
+				inArticle = false
+				if len(artKeys) > 0 {
+					src.articles = append(src.articles, article{
+						Keys:   artKeys,
+						Offset: artStart,
+						Size:   int(before - artStart),
+					})
+				}
+			case key != nil && name == "k" && depth == key.depth:
+				full := collapseSpaces(key.text.String())
+				if full != "" {
+					artKeys = appendUnique(artKeys, full)
+				}
+				if short := collapseSpaces(key.noOpt.String()); short != "" {
+					artKeys = appendUnique(artKeys, short)
+				}
+				key = nil
+			case key != nil && name == "opt":
+				key.optDepth--
+			case abbrPart != nil && depth == abbrPart.depth:
+				if v := collapseSpaces(abbrPart.text.String()); v != "" {
+					if name == "k" || name == "abbr_k" {
+						abbrKeys = append(abbrKeys, v)
+					} else {
+						abbrVals = append(abbrVals, v)
+					}
+				}
+				abbrPart = nil
+			case name == "abr_def" || name == "abbr_def":
+				if len(abbrVals) > 0 {
+					for _, k := range abbrKeys {
+						src.abbrev[k] = strings.Join(abbrVals, "; ")
+					}
+				}
+			case meta != nil && depth == meta.depth:
+				if v := strings.TrimSpace(meta.text.String()); v != "" {
+					if _, ok := src.info[name]; !ok {
+						src.info[name] = v
+					}
+				}
+				meta = nil
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			for _, c := range []*collector{key, abbrPart, meta} {
+				if c == nil {
+					continue
+				}
+				c.text.Write(t)
+				if c.optDepth == 0 {
+					c.noOpt.Write(t)
+				}
+			}
+		}
+	}
+	return src, nil
+}
+
+func articleDepth(stack []string) int {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == "ar" {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func isAbbrDef(name string) bool {
+	return name == "abr_def" || name == "abbr_def"
+}
+
+// isInfoElement reports whether name is a dictionary description element.
+func isInfoElement(name string) bool {
+	switch name {
+	case "full_name", "full_title", "title", "description", "publisher", "authors", "file_ver", "creation_date", "last_edited_date", "dict_edition", "publishing_date", "dict_src_url":
+		return true
+	}
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
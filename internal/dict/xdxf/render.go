@@ -1,4 +1,4 @@
-package stardict
+package xdxf
 
 import (
 	"encoding/xml"
@@ -29,13 +29,17 @@ type xdxfNode struct {
 	children []*xdxfNode
 }
 
-func xdxfToHTML(input, dictID string) string {
+// ToHTML renders XDXF article markup (StarDict 'x' data or the body of an
+// <ar> element) as HTML. abbrev maps abbreviations to their expansions and
+// may be nil.
+func ToHTML(input, dictID string, abbrev map[string]string) string {
 	if input == "" {
 		return ""
 	}
 
 	converted := xdxfNormalizeInput(input)
-	wrapped := `<root><div class="sdct_x">` + converted + `</div></root>`
+	// xdxfParse supplies the root node itself.
+	wrapped := `<div class="sdct_x">` + converted + `</div>`
 
 	root, err := xdxfParse(wrapped)
 	if err != nil || root == nil {
@@ -54,7 +58,7 @@ func xdxfToHTML(input, dictID string) string {
 		return input
 	}
 
-	ctx := xdxfContext{dictID: dictID}
+	ctx := xdxfContext{dictID: dictID, abbrev: abbrev}
 	xdxfTransform(wrapper, &ctx)
 
 	var b strings.Builder
@@ -64,6 +68,7 @@ func xdxfToHTML(input, dictID string) string {
 
 type xdxfContext struct {
 	dictID string
+	abbrev map[string]string
 }
 
 func xdxfNormalizeInput(in string) string {
@@ -216,9 +221,30 @@ func xdxfApply(n, parent *xdxfNode, idx int, ctx *xdxfContext) {
 	case "abr", "abbr":
 		n.data = "span"
 		n.setAttr("class", "xdxf_abbr")
+		if full, ok := ctx.abbrev[strings.TrimSpace(xdxfTextContent(n))]; ok {
+			n.setAttr("title", full)
+		}
 	case "dtrn":
 		n.data = "span"
 		n.setAttr("class", "xdxf_dtrn")
+	case "def":
+		n.data = "div"
+		n.setAttr("class", "xdxf_def")
+	case "deftext":
+		n.data = "span"
+		n.setAttr("class", "xdxf_deftext")
+	case "sr":
+		n.data = "div"
+		n.setAttr("class", "xdxf_sr")
+	case "etm":
+		n.data = "span"
+		n.setAttr("class", "xdxf_etm")
+	case "categ":
+		n.data = "span"
+		n.setAttr("class", "xdxf_categ")
+	case "di":
+		n.data = "span"
+		n.setAttr("class", "xdxf_di")
 	case "c":
 		n.data = "span"
 		if color, ok := n.getAttr("c"); ok && color != "" {
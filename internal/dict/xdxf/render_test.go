@@ -0,0 +1,30 @@
+package xdxf
+
+import "testing"
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "visual",
+			in:   "<k>hue</k>\n<abr>n.</abr> <kref>tint</kref>",
+			want: `<div class="sdct_x"><span class="xdxf_k">hue</span><br><span class="xdxf_abbr" title="noun">n.</span> <a class="xdxf_kref" href="/entry?dict=d&amp;q=tint">tint</a></div>`,
+		},
+		{
+			name: "logical",
+			in:   "<def><deftext>colour</deftext></def>",
+			want: `<div class="sdct_x"><div class="xdxf_def"><span class="xdxf_deftext">colour</span></div></div>`,
+		},
+	}
+	abbrev := map[string]string{"n.": "noun"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.in, "d", abbrev); got != tt.want {
+				t.Fatalf("got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package xdxf
+
+import (
+	"context"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+type Dictionary struct {
+	id          string
+	name        string
+	caseFold    bool
+	file        *os.File
+	info        map[string]string
+	abbrev      map[string]string
+	articles    []article
+	keys        []key
+	normIndex   map[string][]int
+	sortedN     []string
+	sortedW     []string
+	resourceDir string
+}
+
+func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dictionary{
+		id:          id,
+		caseFold:    caseFold,
+		file:        file,
+		resourceDir: strings.TrimSuffix(path, filepath.Ext(path)) + ".files",
+	}
+
+	if cached, ok, err := loadCache(path, caseFold); err == nil && ok {
+		d.info = cached.Info
+		d.abbrev = cached.Abbrev
+		d.articles = cached.Articles
+		d.keys = cached.Keys
+		d.normIndex = cached.NormToKeys
+		d.sortedN = cached.SortedNorm
+		d.sortedW = cached.SortedWord
+		d.name = dictName(name, d.info, id)
+		return d, nil
+	}
+
+	src, err := readSource(path)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	keys := make([]key, 0, len(src.articles))
+	normIndex := make(map[string][]int)
+	type item struct {
+		norm string
+		word string
+	}
+	items := make([]item, 0, len(src.articles))
+	for i, a := range src.articles {
+		for _, w := range a.Keys {
+			ki := len(keys)
+			keys = append(keys, key{Word: w, Article: i})
+			norm := normalize(w, caseFold)
+			normIndex[norm] = append(normIndex[norm], ki)
+			items = append(items, item{norm: norm, word: w})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].norm == items[j].norm {
+			return items[i].word < items[j].word
+		}
+		return items[i].norm < items[j].norm
+	})
+	sortedN := make([]string, 0, len(items))
+	sortedW := make([]string, 0, len(items))
+	for _, it := range items {
+		sortedN = append(sortedN, it.norm)
+		sortedW = append(sortedW, it.word)
+	}
+
+	_ = saveCache(path, &cacheIndex{
+		CaseFold:   caseFold,
+		Info:       src.info,
+		Abbrev:     src.abbrev,
+		Articles:   src.articles,
+		Keys:       keys,
+		NormToKeys: normIndex,
+		SortedNorm: sortedN,
+		SortedWord: sortedW,
+	})
+
+	d.info = src.info
+	d.abbrev = src.abbrev
+	d.articles = src.articles
+	d.keys = keys
+	d.normIndex = normIndex
+	d.sortedN = sortedN
+	d.sortedW = sortedW
+	d.name = dictName(name, d.info, id)
+	return d, nil
+}
+
+// dictName prefers the configured name, then the XDXF title, then the id.
+func dictName(name string, info map[string]string, id string) string {
+	if name != "" {
+		return name
+	}
+	for _, k := range []string{"full_name", "full_title", "title"} {
+		if v := info[k]; v != "" {
+			return v
+		}
+	}
+	return id
+}
+
+func (d *Dictionary) ID() string {
+	return d.id
+}
+
+func (d *Dictionary) Name() string {
+	return d.name
+}
+
+// Metadata returns the XDXF description (full_name/title, description,
+// lang_from, lang_to, format, ...).
+func (d *Dictionary) Metadata() map[string]string {
+	out := make(map[string]string, len(d.info))
+	for k, v := range d.info {
+		out[k] = v
+	}
+	return out
+}
+
+// Close releases the XDXF file.
+func (d *Dictionary) Close() error {
+	return d.file.Close()
+}
+
+func (d *Dictionary) Lookup(word string) []dict.Entry {
+	idxs := d.normIndex[normalize(word, d.caseFold)]
+	if len(idxs) == 0 {
+		return nil
+	}
+	out := make([]dict.Entry, 0, len(idxs))
+	seen := make(map[int]bool, len(idxs))
+	// The first <k> of an article is its headword. Headword hits come
+	// first; other keys resolve to their article and carry the matched
+	// form.
+	for _, alt := range []bool{false, true} {
+		for _, i := range idxs {
+			k := d.keys[i]
+			a := d.articles[k.Article]
+			if (k.Word != a.Keys[0]) != alt || seen[k.Article] {
+				continue
+			}
+			seen[k.Article] = true
+			def, ok := d.readArticle(a)
+			if !ok {
+				continue
+			}
+			e := dict.Entry{Word: a.Keys[0], Definition: def}
+			if alt {
+				e.Synonym = k.Word
+			}
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
+	if limit <= 0 {
+		limit = 20
+	}
+	pfx := normalize(prefix, d.caseFold)
+	idx := sort.Search(len(d.sortedN), func(i int) bool {
+		return d.sortedN[i] >= pfx
+	})
+	if idx == len(d.sortedN) {
+		return nil
+	}
+	out := make([]dict.Entry, 0, limit)
+	for i := idx; i < len(d.sortedN) && len(out) < limit; i++ {
+		if !strings.HasPrefix(d.sortedN[i], pfx) {
+			break
+		}
+		out = append(out, dict.Entry{Word: d.sortedW[i]})
+	}
+	return out
+}
+
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
+	return out
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
+}
+
+// Resource serves files referenced by <rref> and <img> from the name.files
+// directory next to name.xdxf. Nothing else next to the dictionary is
+// served.
+func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
+	clean := dict.CleanResourceName(name)
+	if clean == "" {
+		return nil, "", false
+	}
+	p := filepath.Join(d.resourceDir, filepath.FromSlash(clean))
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, "", false
+	}
+	return data, mime.TypeByExtension(filepath.Ext(clean)), true
+}
+
+func (d *Dictionary) readArticle(a article) (string, bool) {
+	buf := make([]byte, a.Size)
+	if n, err := d.file.ReadAt(buf, a.Offset); n != len(buf) {
+		log.Printf("xdxf: failed to read article in %q: %v", d.id, err)
+		return "", false
+	}
+	body := strings.TrimSpace(string(buf))
+	if d.info["format"] == "logical" {
+		// Line breaks in the logical format are layout only; ToHTML keeps
+		// them as <br/> for the visual format.
+		body = collapseSpaces(body)
+	}
+	def := ToHTML(body, d.id, d.abbrev)
+	return `<div id="gdarticlefrom-` + dict.ScopeID(d.id) + `" class="xdxf">` + def + `</div>`, true
+}
+
+func normalize(s string, caseFold bool) string {
+	if caseFold {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	return strings.TrimSpace(s)
+}
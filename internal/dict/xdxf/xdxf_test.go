@@ -0,0 +1,79 @@
+package xdxf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupAlternateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.xdxf")
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<xdxf lang_from="ENG" lang_to="ENG" format="visual">
+<full_name>Test</full_name>
+<ar><k>colo<opt>u</opt>r</k><k>hue</k>
+a tint</ar>
+<ar><k>color</k>
+American spelling</ar>
+</xdxf>
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load("t", "", path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	got := d.Lookup("color")
+	if len(got) != 2 {
+		t.Fatalf("Lookup(color) = %+v", got)
+	}
+	if got[0].Word != "color" || got[0].Synonym != "" || !strings.Contains(got[0].Definition, "American") {
+		t.Fatalf("headword hit = %+v", got[0])
+	}
+	if got[1].Word != "colour" || got[1].Synonym != "color" {
+		t.Fatalf("alternate hit = %+v", got[1])
+	}
+	if got := d.Lookup("hue"); len(got) != 1 || got[0].Word != "colour" || got[0].Synonym != "hue" {
+		t.Fatalf("Lookup(hue) = %+v", got)
+	}
+}
+
+func TestResourcesOnlyFromFilesDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.xdxf")
+	files := map[string]string{
+		"test.xdxf":            `<xdxf format="visual"><ar><k>word</k><rref>a.wav</rref></ar></xdxf>`,
+		"test.files/a.wav":     "RIFF",
+		"test.files/img/b.png": "PNG",
+		"other.txt":            "secret",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := Load("t", "", path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if data, _, ok := d.Resource("a.wav"); !ok || string(data) != "RIFF" {
+		t.Fatalf("Resource(a.wav) = %q, %v", data, ok)
+	}
+	if data, _, ok := d.Resource("img/b.png"); !ok || string(data) != "PNG" {
+		t.Fatalf("Resource(img/b.png) = %q, %v", data, ok)
+	}
+	for _, name := range []string{"other.txt", "test.xdxf", "test.xdxf.gdapi.xdxf.idx", "../other.txt", "../test.xdxf"} {
+		if _, _, ok := d.Resource(name); ok {
+			t.Fatalf("Resource(%q) served a file outside test.files", name)
+		}
+	}
+}
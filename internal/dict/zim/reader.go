@@ -0,0 +1,435 @@
+package zim
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sagerenn/mdict/internal/cache"
+	"github.com/ulikunitz/xz"
+)
+
+const zimMagic = 0x044D495A
+
+// Special mime type indices of directory entries.
+const (
+	mimeRedirect   = 0xffff
+	mimeLinkTarget = 0xfffe
+	mimeDeleted    = 0xfffd
+)
+
+// Cluster compression types (low nibble of the cluster info byte).
+const (
+	compNone1 = 0
+	compNone  = 1
+	compZlib  = 2
+	compBzip2 = 3
+	compXZ    = 4
+	compZstd  = 5
+	// clusterExtended marks clusters with 64-bit blob offsets.
+	clusterExtended = 0x10
+)
+
+// maxRedirects bounds redirect chains.
+const maxRedirects = 8
+
+// zimFile is an open ZIM archive. All integers are little-endian. The
+// header points to the URL-sorted directory pointer list, the mime type
+// list and the cluster pointer list.
+type zimFile struct {
+	file         *os.File
+	major        uint16
+	minor        uint16
+	entryCount   uint32
+	clusterCount uint32
+	urlPtrPos    int64
+	clusterPtr   int64
+	mimeListPos  int64
+	checksumPos  int64
+	size         int64
+	mimeTypes    []string
+	clusters     *cache.Cache
+}
+
+// dirent is a directory entry. Redirects carry the URL index of their
+// target instead of a cluster and blob number.
+type dirent struct {
+	mime      uint16
+	namespace byte
+	url       string
+	title     string
+	cluster   uint32
+	blob      uint32
+	redirect  uint32
+}
+
+func (e *dirent) isRedirect() bool {
+	return e.mime == mimeRedirect
+}
+
+// displayTitle is the title, which ZIM writers leave empty when it equals
+// the URL.
+func (e *dirent) displayTitle() string {
+	if e.title != "" {
+		return e.title
+	}
+	return e.url
+}
+
+func openZIM(path string) (*zimFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	z := &zimFile{file: f, clusters: cache.New(16, 0)}
+	if err := z.readHeader(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("zim: %s: %w", path, err)
+	}
+	return z, nil
+}
+
+func (z *zimFile) Close() error {
+	return z.file.Close()
+}
+
+func (z *zimFile) readHeader() error {
+	info, err := z.file.Stat()
+	if err != nil {
+		return err
+	}
+	z.size = info.Size()
+
+	var h [80]byte
+	if _, err := z.file.ReadAt(h[:], 0); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	if le.Uint32(h[0:]) != zimMagic {
+		return errors.New("not a ZIM file")
+	}
+	z.major = le.Uint16(h[4:])
+	z.minor = le.Uint16(h[6:])
+	if z.major != 5 && z.major != 6 {
+		return fmt.Errorf("unsupported ZIM version %d.%d", z.major, z.minor)
+	}
+	z.entryCount = le.Uint32(h[24:])
+	z.clusterCount = le.Uint32(h[28:])
+	z.urlPtrPos = int64(le.Uint64(h[32:]))
+	z.clusterPtr = int64(le.Uint64(h[48:]))
+	z.mimeListPos = int64(le.Uint64(h[56:]))
+	z.checksumPos = int64(le.Uint64(h[72:]))
+
+	// The mime type list is a sequence of NUL-terminated strings ending
+	// with an empty one.
+	r := bufio.NewReader(io.NewSectionReader(z.file, z.mimeListPos, z.size-z.mimeListPos))
+	for {
+		s, err := r.ReadString(0)
+		if err != nil {
+			return fmt.Errorf("read mime types: %w", err)
+		}
+		if len(s) == 1 {
+			break
+		}
+		z.mimeTypes = append(z.mimeTypes, s[:len(s)-1])
+	}
+	return nil
+}
+
+// newNamespaces reports whether the archive uses the 6.1+ scheme where all
+// content, articles and assets alike, lives in namespace 'C'.
+func (z *zimFile) newNamespaces() bool {
+	return z.major > 6 || (z.major == 6 && z.minor >= 1)
+}
+
+func (z *zimFile) mimeType(e *dirent) string {
+	if int(e.mime) < len(z.mimeTypes) {
+		return z.mimeTypes[e.mime]
+	}
+	return ""
+}
+
+// urlPointers reads the whole URL pointer list.
+func (z *zimFile) urlPointers() ([]uint64, error) {
+	ptrs := make([]uint64, z.entryCount)
+	r := bufio.NewReader(io.NewSectionReader(z.file, z.urlPtrPos, int64(z.entryCount)*8))
+	if err := binary.Read(r, binary.LittleEndian, ptrs); err != nil {
+		return nil, fmt.Errorf("zim: read URL pointers: %w", err)
+	}
+	return ptrs, nil
+}
+
+// scan calls fn for every directory entry in URL order. Entries are
+// usually stored in that order too, so they are read sequentially.
+func (z *zimFile) scan(fn func(i uint32, e *dirent) error) error {
+	ptrs, err := z.urlPointers()
+	if err != nil {
+		return err
+	}
+	var (
+		r   *bufio.Reader
+		pos int64 = -1
+	)
+	for i, p := range ptrs {
+		off := int64(p)
+		if off != pos {
+			r = bufio.NewReader(io.NewSectionReader(z.file, off, z.size-off))
+			pos = off
+		}
+		e, n, err := readDirent(r)
+		if err != nil {
+			return fmt.Errorf("zim: read entry %d: %w", i, err)
+		}
+		pos += int64(n)
+		if err := fn(uint32(i), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// direntAt reads the directory entry with URL index i.
+func (z *zimFile) direntAt(i uint32) (*dirent, error) {
+	if i >= z.entryCount {
+		return nil, fmt.Errorf("zim: entry %d out of range", i)
+	}
+	var p [8]byte
+	if _, err := z.file.ReadAt(p[:], z.urlPtrPos+int64(i)*8); err != nil {
+		return nil, err
+	}
+	off := int64(binary.LittleEndian.Uint64(p[:]))
+	e, _, err := readDirent(bufio.NewReaderSize(io.NewSectionReader(z.file, off, z.size-off), 512))
+	return e, err
+}
+
+// readDirent reads one directory entry and returns its encoded size.
+func readDirent(r *bufio.Reader) (*dirent, int, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, 0, err
+	}
+	n := len(h)
+	le := binary.LittleEndian
+	e := &dirent{mime: le.Uint16(h[0:]), namespace: h[3]}
+	paramLen := int(h[2])
+	switch e.mime {
+	case mimeRedirect:
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, 0, err
+		}
+		e.redirect = le.Uint32(b[:])
+		n += len(b)
+	case mimeLinkTarget, mimeDeleted:
+	default:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, 0, err
+		}
+		e.cluster = le.Uint32(b[0:])
+		e.blob = le.Uint32(b[4:])
+		n += len(b)
+	}
+	url, err := r.ReadString(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	title, err := r.ReadString(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	e.url = url[:len(url)-1]
+	e.title = title[:len(title)-1]
+	n += len(url) + len(title)
+	if paramLen > 0 {
+		if _, err := r.Discard(paramLen); err != nil {
+			return nil, 0, err
+		}
+		n += paramLen
+	}
+	return e, n, nil
+}
+
+// resolve follows redirects from URL index i to a content entry.
+func (z *zimFile) resolve(i uint32) (uint32, *dirent, error) {
+	for n := 0; n <= maxRedirects; n++ {
+		e, err := z.direntAt(i)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !e.isRedirect() {
+			return i, e, nil
+		}
+		i = e.redirect
+	}
+	return 0, nil, errors.New("zim: redirect loop")
+}
+
+// findURL binary searches the URL pointer list, which is sorted by
+// namespace and then URL.
+func (z *zimFile) findURL(ns byte, url string) (uint32, bool) {
+	lo, hi := uint32(0), z.entryCount
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		e, err := z.direntAt(mid)
+		if err != nil {
+			return 0, false
+		}
+		c := int(e.namespace) - int(ns)
+		if c == 0 {
+			c = strings.Compare(e.url, url)
+		}
+		switch {
+		case c == 0:
+			return mid, true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// blob returns blob b of cluster c.
+func (z *zimFile) blob(c, b uint32) ([]byte, error) {
+	if c >= z.clusterCount {
+		return nil, fmt.Errorf("zim: cluster %d out of range", c)
+	}
+	start, end, err := z.clusterRange(c)
+	if err != nil {
+		return nil, err
+	}
+	var info [1]byte
+	if _, err := z.file.ReadAt(info[:], start); err != nil {
+		return nil, err
+	}
+	width := 4
+	if info[0]&clusterExtended != 0 {
+		width = 8
+	}
+	switch comp := info[0] & 0x0f; comp {
+	case compNone1, compNone:
+		// Uncompressed clusters are read in place.
+		return blobAt(io.NewSectionReader(z.file, start+1, end-start-1), width, b)
+	default:
+		data, err := z.cluster(c, comp, start, end)
+		if err != nil {
+			return nil, err
+		}
+		return blobAt(bytes.NewReader(data), width, b)
+	}
+}
+
+// clusterRange returns the file range of cluster c; the last cluster ends
+// at the checksum.
+func (z *zimFile) clusterRange(c uint32) (int64, int64, error) {
+	var p [16]byte
+	n := 16
+	if c+1 == z.clusterCount {
+		n = 8
+	}
+	if _, err := z.file.ReadAt(p[:n], z.clusterPtr+int64(c)*8); err != nil {
+		return 0, 0, err
+	}
+	start := int64(binary.LittleEndian.Uint64(p[0:]))
+	end := z.checksumPos
+	if n == 16 {
+		end = int64(binary.LittleEndian.Uint64(p[8:]))
+	}
+	if end <= start || end > z.size {
+		end = z.size
+	}
+	return start, end, nil
+}
+
+// cluster decompresses cluster c, keeping recent clusters in memory.
+func (z *zimFile) cluster(c uint32, comp byte, start, end int64) ([]byte, error) {
+	key := strconv.FormatUint(uint64(c), 10)
+	if v, ok := z.clusters.Get(key); ok {
+		return v.([]byte), nil
+	}
+	src := bufio.NewReader(io.NewSectionReader(z.file, start+1, end-start-1))
+	var (
+		data []byte
+		err  error
+	)
+	switch comp {
+	case compZlib:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(src); err == nil {
+			data, err = io.ReadAll(zr)
+			_ = zr.Close()
+		}
+	case compBzip2:
+		data, err = io.ReadAll(bzip2.NewReader(src))
+	case compXZ:
+		var xr *xz.Reader
+		if xr, err = xz.NewReader(src); err == nil {
+			data, err = io.ReadAll(xr)
+		}
+	case compZstd:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(src, zstd.WithDecoderConcurrency(1)); err == nil {
+			data, err = io.ReadAll(zr)
+			zr.Close()
+		}
+	default:
+		err = fmt.Errorf("unsupported compression %d", comp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zim: cluster %d: %w", c, err)
+	}
+	z.clusters.Set(key, data)
+	return data, nil
+}
+
+// blobAt reads blob b from cluster data that starts with the blob offset
+// table. The first offset also gives the table size.
+func blobAt(r io.ReaderAt, width int, b uint32) ([]byte, error) {
+	readOff := func(i uint32) (int64, error) {
+		buf := make([]byte, width)
+		if _, err := r.ReadAt(buf, int64(i)*int64(width)); err != nil {
+			return 0, err
+		}
+		if width == 8 {
+			return int64(binary.LittleEndian.Uint64(buf)), nil
+		}
+		return int64(binary.LittleEndian.Uint32(buf)), nil
+	}
+	first, err := readOff(0)
+	if err != nil {
+		return nil, fmt.Errorf("zim: read blob table: %w", err)
+	}
+	if n := first/int64(width) - 1; int64(b) >= n {
+		return nil, fmt.Errorf("zim: blob %d out of range", b)
+	}
+	start, err := readOff(b)
+	if err != nil {
+		return nil, err
+	}
+	end, err := readOff(b + 1)
+	if err != nil {
+		return nil, err
+	}
+	if end < start {
+		return nil, errors.New("zim: invalid blob offsets")
+	}
+	buf := make([]byte, end-start)
+	if len(buf) == 0 {
+		return buf, nil
+	}
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,428 @@
+package zim
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+var (
+	bodyRe     = regexp.MustCompile(`(?is)<body[^>]*>(.*)</body>`)
+	headRe     = regexp.MustCompile(`(?is)<head[^>]*>(.*?)</head>`)
+	headKeepRe = regexp.MustCompile(`(?is)<link\b[^>]*\brel\s*=\s*["']?stylesheet[^>]*>|<style[^>]*>.*?</style>`)
+	styleRe    = regexp.MustCompile(`(?is)(<style[^>]*>)(.*?)(</style>)`)
+	anchorRe   = regexp.MustCompile(`(?i)(<a\b[^>]*?\bhref\s*=\s*)("|')([^"']*)("|')`)
+	linkAttrRe = regexp.MustCompile(`(?i)(\b(?:src|href)\s*=\s*)("|')([^"']*)("|')`)
+	cssURLRe   = regexp.MustCompile(`(?i)url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+)
+
+// Metadata entries in namespace M that are not text.
+var skipMetadata = map[string]bool{"counter": true}
+
+type Dictionary struct {
+	id        string
+	name      string
+	caseFold  bool
+	file      *zimFile
+	info      map[string]string
+	keys      []key
+	normIndex map[string][]int
+	sortedN   []string
+	sortedW   []string
+}
+
+func Load(id, name, path string, caseFold bool) (*Dictionary, error) {
+	zf, err := openZIM(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dictionary{id: id, caseFold: caseFold, file: zf}
+
+	if cached, ok, err := loadCache(path, caseFold); err == nil && ok {
+		d.info = cached.Info
+		d.keys = cached.Keys
+		d.normIndex = cached.NormToKeys
+		d.sortedN = cached.SortedNorm
+		d.sortedW = cached.SortedWord
+		d.name = dictName(name, d.info, id)
+		return d, nil
+	}
+
+	idx, err := buildIndex(zf, caseFold)
+	if err != nil {
+		_ = zf.Close()
+		return nil, err
+	}
+	idx.CaseFold = caseFold
+	_ = saveCache(path, idx)
+
+	d.info = idx.Info
+	d.keys = idx.Keys
+	d.normIndex = idx.NormToKeys
+	d.sortedN = idx.SortedNorm
+	d.sortedW = idx.SortedWord
+	d.name = dictName(name, d.info, id)
+	return d, nil
+}
+
+// buildIndex scans the directory: HTML entries of the article namespace
+// (and redirects to them) become keys, M/ entries become metadata.
+func buildIndex(zf *zimFile, caseFold bool) (*cacheIndex, error) {
+	articleNS := byte('A')
+	if zf.newNamespaces() {
+		articleNS = 'C'
+	}
+	type content struct {
+		title    string
+		url      string
+		html     bool
+		redirect bool
+		target   uint32
+	}
+	entries := make(map[uint32]content)
+	meta := make(map[string]*dirent)
+	err := zf.scan(func(i uint32, e *dirent) error {
+		switch e.namespace {
+		case articleNS:
+			entries[i] = content{
+				title:    e.displayTitle(),
+				url:      e.url,
+				html:     dict.MediaType(zf.mimeType(e)) == "text/html",
+				redirect: e.isRedirect(),
+				target:   e.redirect,
+			}
+		case 'M':
+			if !e.isRedirect() {
+				meta[e.url] = e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]string)
+	for k, e := range meta {
+		lower := strings.ToLower(k)
+		if skipMetadata[lower] || strings.HasPrefix(lower, "illustration_") || !strings.HasPrefix(zf.mimeType(e), "text/") {
+			continue
+		}
+		data, err := zf.blob(e.cluster, e.blob)
+		if err != nil {
+			log.Printf("zim: failed to read metadata %q: %v", k, err)
+			continue
+		}
+		if v := strings.TrimSpace(string(data)); v != "" {
+			info[lower] = v
+		}
+	}
+
+	// target follows redirects within the article namespace.
+	target := func(i uint32) (uint32, bool) {
+		for n := 0; n <= maxRedirects; n++ {
+			c, ok := entries[i]
+			if !ok {
+				return 0, false
+			}
+			if !c.redirect {
+				return i, c.html
+			}
+			i = c.target
+		}
+		return 0, false
+	}
+
+	ids := make([]uint32, 0, len(entries))
+	for i := range entries {
+		ids = append(ids, i)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	keys := make([]key, 0, len(entries))
+	normIndex := make(map[string][]int)
+	type item struct {
+		norm string
+		word string
+	}
+	items := make([]item, 0, len(entries))
+	for _, i := range ids {
+		t, ok := target(i)
+		if !ok {
+			continue
+		}
+		c := entries[i]
+		ki := len(keys)
+		keys = append(keys, key{Word: c.title, Entry: t, Redirect: t != i})
+		norm := normalize(c.title, caseFold)
+		normIndex[norm] = append(normIndex[norm], ki)
+		items = append(items, item{norm: norm, word: c.title})
+		// Article links name the URL ("Foo_bar"), which often differs
+		// from the title; it is looked up but not suggested.
+		if u := normalize(c.url, caseFold); u != norm {
+			normIndex[u] = append(normIndex[u], ki)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].norm == items[j].norm {
+			return items[i].word < items[j].word
+		}
+		return items[i].norm < items[j].norm
+	})
+	sortedN := make([]string, 0, len(items))
+	sortedW := make([]string, 0, len(items))
+	for _, it := range items {
+		sortedN = append(sortedN, it.norm)
+		sortedW = append(sortedW, it.word)
+	}
+	return &cacheIndex{
+		Info:       info,
+		Keys:       keys,
+		NormToKeys: normIndex,
+		SortedNorm: sortedN,
+		SortedWord: sortedW,
+	}, nil
+}
+
+// dictName prefers the configured name, then the ZIM title, then the id.
+func dictName(name string, info map[string]string, id string) string {
+	if name != "" {
+		return name
+	}
+	if t := info["title"]; t != "" {
+		return t
+	}
+	return id
+}
+
+func (d *Dictionary) ID() string {
+	return d.id
+}
+
+func (d *Dictionary) Name() string {
+	return d.name
+}
+
+// Metadata returns the M/ entries of the archive (title, description,
+// language, creator, publisher, date, ...).
+func (d *Dictionary) Metadata() map[string]string {
+	out := make(map[string]string, len(d.info))
+	for k, v := range d.info {
+		out[k] = v
+	}
+	return out
+}
+
+// Close releases the ZIM file.
+func (d *Dictionary) Close() error {
+	return d.file.Close()
+}
+
+func (d *Dictionary) Lookup(word string) []dict.Entry {
+	idxs := d.normIndex[normalize(word, d.caseFold)]
+	if len(idxs) == 0 {
+		return nil
+	}
+	out := make([]dict.Entry, 0, len(idxs))
+	seen := make(map[uint32]bool, len(idxs))
+	// Articles matched by title first; redirects resolve to their target
+	// and carry the matched title.
+	for _, redirect := range []bool{false, true} {
+		for _, i := range idxs {
+			k := d.keys[i]
+			if k.Redirect != redirect || seen[k.Entry] {
+				continue
+			}
+			seen[k.Entry] = true
+			e, err := d.file.direntAt(k.Entry)
+			if err != nil {
+				log.Printf("zim: failed to read entry %q in %q: %v", k.Word, d.id, err)
+				continue
+			}
+			data, err := d.file.blob(e.cluster, e.blob)
+			if err != nil {
+				log.Printf("zim: failed to read article %q in %q: %v", k.Word, d.id, err)
+				continue
+			}
+			entry := dict.Entry{Word: e.displayTitle(), Definition: d.render(e, string(data))}
+			if redirect {
+				entry.Synonym = k.Word
+			}
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func (d *Dictionary) Prefix(prefix string, limit int) []dict.Entry {
+	if limit <= 0 {
+		limit = 20
+	}
+	pfx := normalize(prefix, d.caseFold)
+	idx := sort.Search(len(d.sortedN), func(i int) bool {
+		return d.sortedN[i] >= pfx
+	})
+	if idx == len(d.sortedN) {
+		return nil
+	}
+	out := make([]dict.Entry, 0, limit)
+	for i := idx; i < len(d.sortedN) && len(out) < limit; i++ {
+		if !strings.HasPrefix(d.sortedN[i], pfx) {
+			break
+		}
+		out = append(out, dict.Entry{Word: d.sortedW[i]})
+	}
+	return out
+}
+
+// PrefixSorted returns the normalized forms and headwords starting with
+// prefix, in index order.
+func (d *Dictionary) PrefixSorted(prefix string, limit int) ([]string, []string) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.PrefixRange(d.sortedN, d.sortedW, normalize(prefix, d.caseFold), limit)
+}
+
+func (d *Dictionary) Search(query string, limit int) []dict.Entry {
+	out, _ := d.SearchContext(context.Background(), query, limit)
+	return out
+}
+
+// SearchContext is Search that stops scanning when ctx is done.
+func (d *Dictionary) SearchContext(ctx context.Context, query string, limit int) ([]dict.Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return dict.SearchSorted(ctx, d.sortedN, d.sortedW, normalize(query, d.caseFold), limit)
+}
+
+// Resource serves images, stylesheets and scripts of the archive. Names
+// are "<namespace>/<url>" as produced by article rendering; bare names are
+// looked up in the default asset namespace.
+func (d *Dictionary) Resource(name string) ([]byte, string, bool) {
+	clean := dict.CleanResourceName(name)
+	if clean == "" {
+		return nil, "", false
+	}
+	ns, u := d.splitName(clean)
+	i, ok := d.file.findURL(ns, u)
+	if !ok {
+		return nil, "", false
+	}
+	_, e, err := d.file.resolve(i)
+	if err != nil || e.mime >= mimeDeleted {
+		return nil, "", false
+	}
+	data, err := d.file.blob(e.cluster, e.blob)
+	if err != nil {
+		log.Printf("zim: failed to read resource %q in %q: %v", name, d.id, err)
+		return nil, "", false
+	}
+	ct := d.file.mimeType(e)
+	if dict.MediaType(ct) == "text/css" {
+		css := d.rewriteCSSLinks(string(data), string(e.namespace)+"/"+e.url)
+		data = []byte(dict.IsolateCSS(css, d.id, ""))
+	}
+	return data, ct, true
+}
+
+func (d *Dictionary) splitName(name string) (byte, string) {
+	if len(name) > 2 && name[1] == '/' {
+		return name[0], name[2:]
+	}
+	if d.file.newNamespaces() {
+		return 'C', name
+	}
+	return 'I', name
+}
+
+// render keeps the stylesheets of the page head and the body, with links
+// resolved against the article location.
+func (d *Dictionary) render(e *dirent, page string) string {
+	base := string(e.namespace) + "/" + e.url
+	body := page
+	if m := bodyRe.FindStringSubmatch(page); m != nil {
+		body = m[1]
+		if h := headRe.FindStringSubmatch(page); h != nil {
+			body = strings.Join(headKeepRe.FindAllString(h[1], -1), "") + body
+		}
+	}
+	body = d.rewriteLinks(body, base)
+	body = styleRe.ReplaceAllStringFunc(body, func(m string) string {
+		sub := styleRe.FindStringSubmatch(m)
+		return sub[1] + dict.IsolateCSS(d.rewriteCSSLinks(sub[2], base), d.id, "") + sub[3]
+	})
+	body = dict.RewriteResourceLinks(body, d.id)
+	return `<div id="gdarticlefrom-` + dict.ScopeID(d.id) + `" class="zim">` + body + `</div>`
+}
+
+// rewriteLinks resolves relative URLs: <a href> targets in the article
+// namespace become entry links, everything else a resource link.
+func (d *Dictionary) rewriteLinks(s, base string) string {
+	s = anchorRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := anchorRe.FindStringSubmatch(m)
+		target, frag, ok := resolveURL(base, sub[3])
+		if !ok || len(target) < 3 || target[1] != '/' || target[0] != base[0] {
+			return m
+		}
+		u := "entry://" + url.PathEscape(target[2:])
+		if frag != "" {
+			u += "#" + frag
+		}
+		return sub[1] + sub[2] + u + sub[4]
+	})
+	return linkAttrRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := linkAttrRe.FindStringSubmatch(m)
+		target, _, ok := resolveURL(base, sub[3])
+		if !ok {
+			return m
+		}
+		return sub[1] + sub[2] + dict.ResourceURL(d.id, target) + sub[4]
+	})
+}
+
+func (d *Dictionary) rewriteCSSLinks(css, base string) string {
+	return cssURLRe.ReplaceAllStringFunc(css, func(m string) string {
+		sub := cssURLRe.FindStringSubmatch(m)
+		target, _, ok := resolveURL(base, sub[2])
+		if !ok {
+			return m
+		}
+		return "url(" + sub[1] + dict.ResourceURL(d.id, target) + sub[3] + ")"
+	})
+}
+
+// resolveURL resolves a relative link against the "<namespace>/<url>" of
+// the page it appears on. Absolute URLs, anchors and links already
+// rewritten are left alone.
+func resolveURL(base, ref string) (string, string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "/") || dict.HasScheme(ref) {
+		return "", "", false
+	}
+	p, frag, _ := strings.Cut(ref, "#")
+	p, _, _ = strings.Cut(p, "?")
+	if decoded, err := url.PathUnescape(p); err == nil {
+		p = decoded
+	}
+	target := path.Join(path.Dir(base), p)
+	if target == "." || strings.HasPrefix(target, "../") {
+		return "", "", false
+	}
+	return target, frag, true
+}
+
+func normalize(s string, caseFold bool) string {
+	if caseFold {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	return strings.TrimSpace(s)
+}
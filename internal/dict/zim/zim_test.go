@@ -0,0 +1,344 @@
+package zim
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// testEntry is a directory entry of a generated archive. Redirects name
+// their target as "<namespace>/<url>".
+type testEntry struct {
+	ns       byte
+	url      string
+	title    string
+	mime     string
+	redirect string
+	cluster  uint32
+	blob     uint32
+}
+
+type testCluster struct {
+	comp     byte
+	extended bool
+	blobs    []string
+}
+
+func encodeCluster(t *testing.T, c testCluster) []byte {
+	t.Helper()
+	width := 4
+	if c.extended {
+		width = 8
+	}
+	var raw bytes.Buffer
+	off := (len(c.blobs) + 1) * width
+	for i := 0; i <= len(c.blobs); i++ {
+		if width == 8 {
+			_ = binary.Write(&raw, binary.LittleEndian, uint64(off))
+		} else {
+			_ = binary.Write(&raw, binary.LittleEndian, uint32(off))
+		}
+		if i < len(c.blobs) {
+			off += len(c.blobs[i])
+		}
+	}
+	for _, b := range c.blobs {
+		raw.WriteString(b)
+	}
+
+	var out bytes.Buffer
+	info := c.comp
+	if c.extended {
+		info |= clusterExtended
+	}
+	out.WriteByte(info)
+	switch c.comp {
+	case compNone:
+		out.Write(raw.Bytes())
+	case compZlib:
+		zw := zlib.NewWriter(&out)
+		_, _ = zw.Write(raw.Bytes())
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case compXZ:
+		xw, err := xz.NewWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = xw.Write(raw.Bytes())
+		if err := xw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case compZstd:
+		zw, err := zstd.NewWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = zw.Write(raw.Bytes())
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unsupported test compression %d", c.comp)
+	}
+	return out.Bytes()
+}
+
+// writeZIM builds an archive: header, mime list, URL and title pointer
+// lists, directory entries, cluster pointers, clusters and checksum.
+func writeZIM(t *testing.T, path string, major, minor uint16, entries []testEntry, clusters []testCluster) {
+	t.Helper()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ns != entries[j].ns {
+			return entries[i].ns < entries[j].ns
+		}
+		return entries[i].url < entries[j].url
+	})
+	index := make(map[string]uint32, len(entries))
+	mimeIndex := make(map[string]uint16)
+	var mimes []string
+	for i, e := range entries {
+		index[string(e.ns)+"/"+e.url] = uint32(i)
+		if e.redirect == "" {
+			if _, ok := mimeIndex[e.mime]; !ok {
+				mimeIndex[e.mime] = uint16(len(mimes))
+				mimes = append(mimes, e.mime)
+			}
+		}
+	}
+
+	var mimeList bytes.Buffer
+	for _, m := range mimes {
+		mimeList.WriteString(m + "\x00")
+	}
+	mimeList.WriteByte(0)
+
+	le := binary.LittleEndian
+	var dirents bytes.Buffer
+	offsets := make([]int, len(entries))
+	for i, e := range entries {
+		offsets[i] = dirents.Len()
+		var h [8]byte
+		h[3] = e.ns
+		if e.redirect != "" {
+			le.PutUint16(h[0:], mimeRedirect)
+			dirents.Write(h[:])
+			target, ok := index[e.redirect]
+			if !ok {
+				t.Fatalf("redirect target %q not found", e.redirect)
+			}
+			_ = binary.Write(&dirents, le, target)
+		} else {
+			le.PutUint16(h[0:], mimeIndex[e.mime])
+			dirents.Write(h[:])
+			_ = binary.Write(&dirents, le, e.cluster)
+			_ = binary.Write(&dirents, le, e.blob)
+		}
+		dirents.WriteString(e.url + "\x00" + e.title + "\x00")
+	}
+
+	mimePos := 80
+	urlPtrPos := mimePos + mimeList.Len()
+	titlePtrPos := urlPtrPos + 8*len(entries)
+	direntPos := titlePtrPos + 4*len(entries)
+	clusterPtrPos := direntPos + dirents.Len()
+	clusterPos := clusterPtrPos + 8*len(clusters)
+
+	var clusterData bytes.Buffer
+	clusterPtrs := make([]uint64, len(clusters))
+	for i, c := range clusters {
+		clusterPtrs[i] = uint64(clusterPos + clusterData.Len())
+		clusterData.Write(encodeCluster(t, c))
+	}
+	checksumPos := clusterPos + clusterData.Len()
+
+	var out bytes.Buffer
+	var h [80]byte
+	le.PutUint32(h[0:], zimMagic)
+	le.PutUint16(h[4:], major)
+	le.PutUint16(h[6:], minor)
+	le.PutUint32(h[24:], uint32(len(entries)))
+	le.PutUint32(h[28:], uint32(len(clusters)))
+	le.PutUint64(h[32:], uint64(urlPtrPos))
+	le.PutUint64(h[40:], uint64(titlePtrPos))
+	le.PutUint64(h[48:], uint64(clusterPtrPos))
+	le.PutUint64(h[56:], uint64(mimePos))
+	le.PutUint32(h[64:], 0xffffffff)
+	le.PutUint32(h[68:], 0xffffffff)
+	le.PutUint64(h[72:], uint64(checksumPos))
+	out.Write(h[:])
+	out.Write(mimeList.Bytes())
+	for _, o := range offsets {
+		_ = binary.Write(&out, le, uint64(direntPos+o))
+	}
+	for i := range entries {
+		_ = binary.Write(&out, le, uint32(i))
+	}
+	out.Write(dirents.Bytes())
+	_ = binary.Write(&out, le, clusterPtrs)
+	out.Write(clusterData.Bytes())
+	sum := md5.Sum(out.Bytes())
+	out.Write(sum[:])
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	applePage  = `<html><head><link rel="stylesheet" href="%sstyle.css"><script src="x.js"></script></head><body><p>a fruit, see <a href="Banana">banana</a></p></body></html>`
+	bananaPage = `<html><body><p>a long yellow fruit</p></body></html>`
+	styleSheet = `p { background: url(pic.png) }`
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		major, minor uint16
+		articleNS    byte
+		assetNS      byte
+		assetPrefix  string
+		clusters     []byte
+	}{
+		// 6.1+ keeps articles and assets in C; the extended cluster has
+		// 64-bit blob offsets.
+		{name: "6.1", major: 6, minor: 1, articleNS: 'C', assetNS: 'C', clusters: []byte{compNone, compZlib}},
+		{name: "5.0", major: 5, minor: 0, articleNS: 'A', assetNS: 'I', assetPrefix: "../I/", clusters: []byte{compZstd, compXZ}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.zim")
+			entries := []testEntry{
+				{ns: tt.articleNS, url: "Apple", title: "Apple", mime: "text/html", cluster: 0, blob: 1},
+				{ns: tt.articleNS, url: "Apples", title: "Apples", redirect: string(tt.articleNS) + "/Apple"},
+				{ns: tt.articleNS, url: "Banana_split", title: "Banana split", mime: "text/html", cluster: 1, blob: 0},
+				{ns: tt.articleNS, url: "Banana", redirect: string(tt.articleNS) + "/Banana_split"},
+				{ns: tt.assetNS, url: "style.css", mime: "text/css", cluster: 0, blob: 0},
+				{ns: 'M', url: "Title", mime: "text/plain", cluster: 1, blob: 1},
+				{ns: 'M', url: "Counter", mime: "text/plain", cluster: 1, blob: 2},
+			}
+			clusters := []testCluster{
+				{comp: tt.clusters[0], blobs: []string{styleSheet, strings.Replace(applePage, "%s", tt.assetPrefix, 1)}},
+				{comp: tt.clusters[1], extended: true, blobs: []string{bananaPage, "Test ZIM", "text/html=2"}},
+			}
+			writeZIM(t, path, tt.major, tt.minor, entries, clusters)
+
+			for _, pass := range []string{"build", "cached"} {
+				d, err := Load("t", "", path, true)
+				if err != nil {
+					t.Fatalf("%s: %v", pass, err)
+				}
+				if d.Name() != "Test ZIM" {
+					t.Fatalf("%s: name = %q", pass, d.Name())
+				}
+				if _, ok := d.Metadata()["counter"]; ok {
+					t.Fatalf("%s: counter listed in metadata", pass)
+				}
+
+				got := d.Lookup("apple")
+				if len(got) != 1 || got[0].Word != "Apple" || got[0].Synonym != "" ||
+					!strings.Contains(got[0].Definition, "a fruit") ||
+					!strings.Contains(got[0].Definition, `href="/entry?dict=t&q=Banana"`) ||
+					!strings.Contains(got[0].Definition, `href="/resource/`+string(tt.assetNS)+`/style.css?dict=t"`) ||
+					strings.Contains(got[0].Definition, "x.js") {
+					t.Fatalf("%s: lookup apple = %+v", pass, got)
+				}
+				// Redirects resolve to their target and keep the
+				// matched title as synonym.
+				got = d.Lookup("apples")
+				if len(got) != 1 || got[0].Word != "Apple" || got[0].Synonym != "Apples" {
+					t.Fatalf("%s: lookup apples = %+v", pass, got)
+				}
+				// Article links name the URL, which is looked up too.
+				got = d.Lookup("banana_split")
+				if len(got) != 1 || got[0].Word != "Banana split" || !strings.Contains(got[0].Definition, "yellow fruit") {
+					t.Fatalf("%s: lookup banana_split = %+v", pass, got)
+				}
+				got = d.Lookup("banana")
+				if len(got) != 1 || got[0].Word != "Banana split" || got[0].Synonym != "Banana" {
+					t.Fatalf("%s: lookup banana = %+v", pass, got)
+				}
+				if got := d.Lookup("style.css"); len(got) != 0 {
+					t.Fatalf("%s: asset listed as article: %+v", pass, got)
+				}
+
+				pre := d.Prefix("ban", 10)
+				if len(pre) != 2 || pre[0].Word != "Banana" || pre[1].Word != "Banana split" {
+					t.Fatalf("%s: prefix = %+v", pass, pre)
+				}
+
+				data, ct, ok := d.Resource(string(tt.assetNS) + "/style.css")
+				if !ok || ct != "text/css" || !strings.Contains(string(data), "background") ||
+					!strings.Contains(string(data), "/resource/"+string(tt.assetNS)+"/pic.png?dict=t") {
+					t.Fatalf("%s: resource = %q, %q, %v", pass, data, ct, ok)
+				}
+				// Bare names are looked up in the asset namespace.
+				if _, _, ok := d.Resource("style.css"); !ok {
+					t.Fatalf("%s: bare resource name not found", pass)
+				}
+				if _, _, ok := d.Resource(string(tt.assetNS) + "/missing.css"); ok {
+					t.Fatalf("%s: missing resource found", pass)
+				}
+				if err := d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBlobAt(t *testing.T) {
+	for _, width := range []int{4, 8} {
+		c := testCluster{comp: compNone, extended: width == 8, blobs: []string{"one", "", "three"}}
+		data := encodeCluster(t, c)[1:]
+		for i, want := range c.blobs {
+			got, err := blobAt(bytes.NewReader(data), width, uint32(i))
+			if err != nil || string(got) != want {
+				t.Fatalf("width %d: blob %d = %q, %v; want %q", width, i, got, err, want)
+			}
+		}
+		if _, err := blobAt(bytes.NewReader(data), width, 3); err == nil {
+			t.Fatalf("width %d: blob 3 out of range read", width)
+		}
+	}
+}
+
+func TestReadDirent(t *testing.T) {
+	le := binary.LittleEndian
+	var b bytes.Buffer
+	// Content entry with two bytes of extra parameters.
+	b.Write([]byte{2, 0, 2, 'C', 0, 0, 0, 0})
+	_ = binary.Write(&b, le, uint32(3))
+	_ = binary.Write(&b, le, uint32(4))
+	b.WriteString("Foo_bar\x00\x00xx")
+	// Redirect.
+	b.Write([]byte{0xff, 0xff, 0, 'C', 0, 0, 0, 0})
+	_ = binary.Write(&b, le, uint32(7))
+	b.WriteString("Foo\x00Foo title\x00")
+
+	r := bufio.NewReader(bytes.NewReader(b.Bytes()))
+	e, n, err := readDirent(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.mime != 2 || e.namespace != 'C' || e.cluster != 3 || e.blob != 4 || e.url != "Foo_bar" || e.displayTitle() != "Foo_bar" || n != 16+len("Foo_bar\x00\x00xx") {
+		t.Fatalf("content dirent = %+v, %d", e, n)
+	}
+	e, _, err = readDirent(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.isRedirect() || e.redirect != 7 || e.url != "Foo" || e.displayTitle() != "Foo title" {
+		t.Fatalf("redirect dirent = %+v", e)
+	}
+}
@@ -0,0 +1,188 @@
+package httpx
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/config"
+	"github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/reload"
+)
+
+type adminAPI struct {
+	admin *reload.Reloader
+	token string
+}
+
+type reorderRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// ServeHTTP serves the /admin API:
+//
+//	GET    /admin/dicts              status of every dictionary
+//	POST   /admin/dicts              load a dictionary (config entry as body)
+//	DELETE /admin/dicts/{id}         unload a configured dictionary
+//	POST   /admin/dicts/{id}/reload  reload from its files
+//	POST   /admin/dicts/{id}/rebuild delete its index caches and reload
+//	POST   /admin/dicts/{id}/enable  serve it again
+//	POST   /admin/dicts/{id}/disable leave it out of queries
+//	PUT    /admin/order              reorder ({"ids": [...]})
+//	POST   /admin/reload             re-read the config file
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !a.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gdapi admin"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return
+	}
+	parts, ok := adminPath(req)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "dicts":
+		switch req.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, a.admin.Status())
+		case http.MethodPost:
+			var dc config.DictConfig
+			if err := decodeBody(req, &dc); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			st, err := a.admin.Load(dc)
+			a.reply(w, http.StatusCreated, st, err)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case len(parts) == 2 && parts[0] == "dicts":
+		if req.Method != http.MethodDelete {
+			methodNotAllowed(w, "DELETE")
+			return
+		}
+		a.reply(w, http.StatusNoContent, nil, a.admin.Unload(parts[1]))
+	case len(parts) == 3 && parts[0] == "dicts" && (parts[2] == "reload" || parts[2] == "rebuild"):
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		var (
+			st  reload.DictStatus
+			err error
+		)
+		if parts[2] == "rebuild" {
+			st, err = a.admin.Rebuild(parts[1])
+		} else {
+			st, err = a.admin.ReloadDict(parts[1])
+		}
+		a.reply(w, http.StatusOK, st, err)
+	case len(parts) == 3 && parts[0] == "dicts" && (parts[2] == "enable" || parts[2] == "disable"):
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		st, err := a.admin.SetEnabled(parts[1], parts[2] == "enable")
+		a.reply(w, http.StatusOK, st, err)
+	case len(parts) == 1 && parts[0] == "order":
+		if req.Method != http.MethodPut {
+			methodNotAllowed(w, "PUT")
+			return
+		}
+		var body reorderRequest
+		if err := decodeBody(req, &body); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		if err := a.admin.Reorder(body.IDs); err != nil {
+			a.reply(w, 0, nil, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, a.admin.Status())
+	case len(parts) == 1 && parts[0] == "reload":
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		if err := a.admin.Reload(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, a.admin.Status())
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+func (a *adminAPI) authorized(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(a.token)) == 1
+}
+
+// reply writes payload with status, or the error with its status code.
+func (a *adminAPI) reply(w http.ResponseWriter, status int, payload any, err error) {
+	var (
+		loadErr    *reload.LoadError
+		persistErr *reload.PersistError
+	)
+	switch {
+	case err == nil:
+		if status == http.StatusNoContent {
+			w.WriteHeader(status)
+			return
+		}
+		writeJSON(w, status, payload)
+		return
+	case errors.Is(err, reload.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, reload.ErrExists), errors.Is(err, reload.ErrDiscovered), errors.Is(err, reload.ErrNotLoaded):
+		status = http.StatusConflict
+	case errors.As(err, &loadErr):
+		status = http.StatusUnprocessableEntity
+	case errors.As(err, &persistErr):
+		status = http.StatusInternalServerError
+	default:
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// adminPath returns the unescaped path segments after /admin/.
+func adminPath(req *http.Request) ([]string, bool) {
+	p := req.URL.EscapedPath()
+	if base := dict.URLBasePath(); base != "" {
+		p = strings.TrimPrefix(p, base)
+	}
+	p, ok := strings.CutPrefix(p, "/admin/")
+	if !ok {
+		return nil, false
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i := range parts {
+		s, err := url.PathUnescape(parts[i])
+		if err != nil || s == "" {
+			return nil, false
+		}
+		parts[i] = s
+	}
+	return parts, true
+}
+
+func decodeBody(req *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, req.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.New("invalid JSON body: " + err.Error())
+	}
+	return nil
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+}
@@ -0,0 +1,125 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sagerenn/mdict/internal/config"
+	"github.com/sagerenn/mdict/internal/dict/registry"
+	"github.com/sagerenn/mdict/internal/observability"
+	"github.com/sagerenn/mdict/internal/reload"
+	"github.com/sagerenn/mdict/internal/service"
+)
+
+func TestAdmin(t *testing.T) {
+	setURLBasePathForTest(t, "")
+	tmp := t.TempDir()
+	for name, data := range map[string]string{"a.tsv": "hello\tworld\n", "b.tsv": "foo\tbar\n"} {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfgPath := filepath.Join(tmp, "gdapi.json")
+	t.Setenv(config.AdminTokenEnv, "secret")
+	raw := `{"listen": ":9090", "dictionaries": [{"id": "a", "type": "tsv", "path": "` + filepath.ToSlash(filepath.Join(tmp, "a.tsv")) + `"}], "log": {"level": "error"}}`
+	if err := os.WriteFile(cfgPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := registry.New()
+	svc := service.New(reg)
+	log := observability.New("error")
+	admin := reload.New(cfgPath, reg, svc, log)
+	admin.Apply(cfg)
+	h := NewRouterWithAdmin(svc, log, "", admin, cfg.Admin.Token)
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodGet, "/admin/dicts", "wrong", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("bad token: got %d", rr.Code)
+	}
+	body := `{"id":"b","type":"tsv","path":"` + filepath.ToSlash(filepath.Join(tmp, "b.tsv")) + `"}`
+	if rr := do(http.MethodPost, "/admin/dicts", "secret", body); rr.Code != http.StatusCreated {
+		t.Fatalf("load: got %d %s", rr.Code, rr.Body)
+	}
+	if rr := do(http.MethodPost, "/admin/dicts", "secret", body); rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate load: got %d", rr.Code)
+	}
+	missing := `{"id":"c","type":"tsv","path":"` + filepath.ToSlash(filepath.Join(tmp, "missing.tsv")) + `"}`
+	if rr := do(http.MethodPost, "/admin/dicts", "secret", missing); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("missing file: got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/admin/order", "secret", `{"ids":["b"]}`); rr.Code != http.StatusOK {
+		t.Fatalf("reorder: got %d %s", rr.Code, rr.Body)
+	}
+	if rr := do(http.MethodPost, "/admin/dicts/a/rebuild", "secret", ""); rr.Code != http.StatusOK {
+		t.Fatalf("rebuild: got %d %s", rr.Code, rr.Body)
+	}
+
+	rr := do(http.MethodPost, "/admin/dicts/b/disable", "secret", "")
+	var st reload.DictStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &st); rr.Code != http.StatusOK || err != nil || st.Enabled {
+		t.Fatalf("disable: got %d %s", rr.Code, rr.Body)
+	}
+	if rr := do(http.MethodGet, "/lookup?q=foo", "", ""); strings.Contains(rr.Body.String(), "bar") {
+		t.Fatalf("disabled dictionary still answers: %s", rr.Body)
+	}
+	if rr := do(http.MethodPost, "/admin/dicts/b/enable", "secret", ""); rr.Code != http.StatusOK {
+		t.Fatalf("enable: got %d %s", rr.Code, rr.Body)
+	}
+	if rr := do(http.MethodGet, "/lookup?q=foo", "", ""); !strings.Contains(rr.Body.String(), "bar") {
+		t.Fatalf("enabled dictionary does not answer: %s", rr.Body)
+	}
+	if rr := do(http.MethodPost, "/admin/dicts/c/disable", "secret", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("disable unknown dictionary: got %d", rr.Code)
+	}
+
+	rr = do(http.MethodGet, "/admin/dicts", "secret", "")
+	var status reload.Status
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Dictionaries) != 2 || status.Dictionaries[0].ID != "b" || status.Dictionaries[1].Status != "loaded" {
+		t.Fatalf("status = %+v", status)
+	}
+
+	if rr := do(http.MethodDelete, "/admin/dicts/a", "secret", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("unload: got %d %s", rr.Code, rr.Body)
+	}
+	if rr := do(http.MethodGet, "/lookup?q=hello", "", ""); strings.Contains(rr.Body.String(), "world") {
+		t.Fatalf("unloaded dictionary still answers: %s", rr.Body)
+	}
+
+	saved, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Dictionaries) != 1 || saved.Dictionaries[0].ID != "b" || saved.Listen != ":9090" {
+		t.Fatalf("saved config = %+v", saved)
+	}
+	// Only the dictionaries are written back: no token, no defaults.
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); strings.Contains(s, "secret") || strings.Contains(s, "query") || strings.Index(s, "listen") > strings.Index(s, "log") {
+		t.Fatalf("saved config file:\n%s", s)
+	}
+}
@@ -6,11 +6,13 @@ import (
 	"html"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sagerenn/mdict/internal/dict"
 	"github.com/sagerenn/mdict/internal/observability"
+	"github.com/sagerenn/mdict/internal/reload"
 	"github.com/sagerenn/mdict/internal/service"
 )
 
@@ -25,20 +27,33 @@ type healthResponse struct {
 }
 
 type dictResponse struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 type lookupResponse struct {
 	Query   string                  `json:"query"`
 	Results []service.ResultEntries `json:"results"`
 	Count   int                     `json:"count"`
+	// Partial is set when a dictionary failed or timed out; its result
+	// carries the error.
+	Partial bool `json:"partial,omitempty"`
 }
 
 type wordsResponse struct {
 	Query   string                `json:"query"`
 	Results []service.ResultWords `json:"results"`
 	Count   int                   `json:"count"`
+	Partial bool                  `json:"partial,omitempty"`
+}
+
+type suggestResponse struct {
+	Query       string               `json:"query"`
+	Suggestions []service.Suggestion `json:"suggestions"`
+	Count       int                  `json:"count"`
+	Partial     bool                 `json:"partial,omitempty"`
+	Errors      []service.DictError  `json:"errors,omitempty"`
 }
 
 type errorResponse struct {
@@ -46,12 +61,20 @@ type errorResponse struct {
 }
 
 func NewRouter(svc *service.Service, log *observability.Logger, basePath string) http.Handler {
+	return NewRouterWithAdmin(svc, log, basePath, nil, "")
+}
+
+// NewRouterWithAdmin also serves the /admin API backed by admin. Requests
+// must carry token as a bearer token; with an empty token or a nil admin
+// the API is not mounted.
+func NewRouterWithAdmin(svc *service.Service, log *observability.Logger, basePath string, admin *reload.Reloader, token string) http.Handler {
 	basePath = normalizeBasePath(basePath)
 	dict.SetURLBasePath(basePath)
 	r := &Router{svc: svc, basePath: basePath}
 	mux := http.NewServeMux()
 	r.handleRoute(mux, "/health", r.handleHealth)
 	r.handleRoute(mux, "/dicts", r.handleDicts)
+	r.handleRoute(mux, "/groups", r.handleGroups)
 	r.handleRoute(mux, "/lookup", r.handleLookup)
 	r.handleRoute(mux, "/prefix", r.handlePrefix)
 	r.handleRoute(mux, "/search", r.handleSearch)
@@ -59,6 +82,9 @@ func NewRouter(svc *service.Service, log *observability.Logger, basePath string)
 	r.handleRoute(mux, "/resource", r.handleResource)
 	r.handleRoute(mux, "/resource/", r.handleResource)
 	r.handle(mux, "/debug/vars", expvar.Handler())
+	if admin != nil && token != "" {
+		r.handle(mux, "/admin/", &adminAPI{admin: admin, token: token})
+	}
 
 	h := observability.RequestIDMiddleware(mux)
 	h = observability.RecoveryMiddleware(log)(h)
@@ -88,11 +114,23 @@ func (r *Router) handleDicts(w http.ResponseWriter, _ *http.Request) {
 	dicts := r.svc.List()
 	resp := make([]dictResponse, 0, len(dicts))
 	for _, d := range dicts {
-		resp = append(resp, dictResponse{ID: d.ID(), Name: d.Name()})
+		item := dictResponse{ID: d.ID(), Name: d.Name()}
+		if mp, ok := d.(dict.MetadataProvider); ok {
+			item.Metadata = mp.Metadata()
+		}
+		resp = append(resp, item)
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (r *Router) handleGroups(w http.ResponseWriter, _ *http.Request) {
+	groups := r.svc.Groups()
+	if groups == nil {
+		groups = []service.Group{}
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
 func (r *Router) handleLookup(w http.ResponseWriter, req *http.Request) {
 	query := strings.TrimSpace(req.URL.Query().Get("q"))
 	if query == "" {
@@ -100,9 +138,13 @@ func (r *Router) handleLookup(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	limit := observability.ParseLimit(req.URL.Query().Get("limit"), 20)
-	dictIDs := splitIDs(req.URL.Query().Get("dict"))
-	results := r.svc.Lookup(query, dictIDs, limit)
-	resp := lookupResponse{Query: query, Results: results, Count: len(results)}
+	dictIDs, err := r.svc.Select(req.URL.Query().Get("group"), splitIDs(req.URL.Query().Get("dict")))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	results := r.svc.Lookup(req.Context(), query, dictIDs, limit)
+	resp := lookupResponse{Query: query, Results: results, Count: len(results), Partial: partial(results)}
 	writeJSON(w, http.StatusOK, resp)
 }
 
@@ -113,9 +155,22 @@ func (r *Router) handlePrefix(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	limit := observability.ParseLimit(req.URL.Query().Get("limit"), 20)
-	dictIDs := splitIDs(req.URL.Query().Get("dict"))
-	results := r.svc.Prefix(query, dictIDs, limit)
-	resp := wordsResponse{Query: query, Results: results, Count: len(results)}
+	dictIDs, err := r.svc.Select(req.URL.Query().Get("group"), splitIDs(req.URL.Query().Get("dict")))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if merged, _ := strconv.ParseBool(req.URL.Query().Get("merged")); merged {
+		res := r.svc.Suggest(req.Context(), query, dictIDs, limit)
+		resp := suggestResponse{Query: query, Suggestions: res.Words, Count: len(res.Words), Partial: len(res.Errors) > 0, Errors: res.Errors}
+		if resp.Suggestions == nil {
+			resp.Suggestions = []service.Suggestion{}
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	results := r.svc.Prefix(req.Context(), query, dictIDs, limit)
+	resp := wordsResponse{Query: query, Results: results, Count: len(results), Partial: partial(results)}
 	writeJSON(w, http.StatusOK, resp)
 }
 
@@ -126,12 +181,26 @@ func (r *Router) handleSearch(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	limit := observability.ParseLimit(req.URL.Query().Get("limit"), 20)
-	dictIDs := splitIDs(req.URL.Query().Get("dict"))
-	results := r.svc.Search(query, dictIDs, limit)
-	resp := wordsResponse{Query: query, Results: results, Count: len(results)}
+	dictIDs, err := r.svc.Select(req.URL.Query().Get("group"), splitIDs(req.URL.Query().Get("dict")))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	results := r.svc.Search(req.Context(), query, dictIDs, limit)
+	resp := wordsResponse{Query: query, Results: results, Count: len(results), Partial: partial(results)}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// partial reports whether a dictionary failed or timed out.
+func partial[T interface{ Failed() bool }](results []T) bool {
+	for _, res := range results {
+		if res.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Router) handleEntry(w http.ResponseWriter, req *http.Request) {
 	query := strings.TrimSpace(req.URL.Query().Get("q"))
 	if query == "" {
@@ -139,8 +208,12 @@ func (r *Router) handleEntry(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	limit := observability.ParseLimit(req.URL.Query().Get("limit"), 20)
-	dictIDs := splitIDs(req.URL.Query().Get("dict"))
-	results := r.svc.Lookup(query, dictIDs, limit)
+	dictIDs, err := r.svc.Select(req.URL.Query().Get("group"), splitIDs(req.URL.Query().Get("dict")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	results := r.svc.Lookup(req.Context(), query, dictIDs, limit)
 
 	var b strings.Builder
 	b.WriteString("<!doctype html><html><head><meta charset=\"utf-8\"><title>")
@@ -156,6 +229,11 @@ func (r *Router) handleEntry(w http.ResponseWriter, req *http.Request) {
 			b.WriteString("<h2>")
 			b.WriteString(html.EscapeString(res.DictName))
 			b.WriteString("</h2>")
+			if res.Error != "" {
+				b.WriteString("<p class=\"error\">")
+				b.WriteString(html.EscapeString(res.Error))
+				b.WriteString("</p>")
+			}
 			for _, entry := range res.Entries {
 				b.WriteString("<div class=\"entry\">")
 				if entry.Word != "" {
@@ -250,13 +328,10 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 	_ = enc.Encode(payload)
 }
 
+// splitIDs returns nil when s names no dictionary, which selects all.
 func splitIDs(s string) []string {
-	if s == "" {
-		return nil
-	}
-	parts := strings.Split(s, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
 		p = strings.TrimSpace(p)
 		if p != "" {
 			out = append(out, p)
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sagerenn/mdict/internal/dict"
 	"github.com/sagerenn/mdict/internal/dict/filedict"
@@ -43,7 +44,7 @@ func setupRouterWithBasePath(t *testing.T, basePath string) http.Handler {
 		t.Fatal(err)
 	}
 
-	d, err := filedict.Load("test", "Test", path, "tsv", "\t", true)
+	d, err := filedict.Load("test", "Test", path, "tsv", "\t", nil, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -173,3 +174,166 @@ func setURLBasePathForTest(t *testing.T, basePath string) {
 		dict.SetURLBasePath(prev)
 	})
 }
+
+func TestLookupGroup(t *testing.T) {
+	tmp := t.TempDir()
+	reg := registry.New()
+	for _, id := range []string{"a", "b", "c"} {
+		path := filepath.Join(tmp, id+".tsv")
+		if err := os.WriteFile(path, []byte("hello\t"+id+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d, err := filedict.Load(id, "", path, "tsv", "\t", nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reg.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := service.New(reg)
+	svc.SetGroups([]service.Group{{ID: "cb", Name: "C then B", Dicts: []string{"c", "b"}}, {ID: "none", Dicts: []string{"x"}}})
+	h := NewRouter(svc, observability.New("error"), "")
+
+	lookup := func(target string) (int, []string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp lookupResp
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		var ids []string
+		for _, res := range resp.Results {
+			ids = append(ids, res.DictID)
+		}
+		return rr.Code, ids
+	}
+
+	if code, ids := lookup("/lookup?q=hello&group=cb"); code != http.StatusOK || strings.Join(ids, ",") != "c,b" {
+		t.Fatalf("group cb: %d %v", code, ids)
+	}
+	if code, ids := lookup("/lookup?q=hello&group=cb&dict=b,a"); code != http.StatusOK || strings.Join(ids, ",") != "b" {
+		t.Fatalf("group cb with dict=b,a: %d %v", code, ids)
+	}
+	if code, ids := lookup("/lookup?q=hello&group=none"); code != http.StatusOK || len(ids) != 0 {
+		t.Fatalf("group none: %d %v", code, ids)
+	}
+	if code, _ := lookup("/lookup?q=hello&group=missing"); code != http.StatusNotFound {
+		t.Fatalf("unknown group: %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/groups", nil))
+	if !strings.Contains(rr.Body.String(), `"id":"cb","name":"C then B","dicts":["c","b"]`) {
+		t.Fatalf("/groups = %s", rr.Body)
+	}
+}
+
+// stuckDict never answers a lookup until release is closed.
+type stuckDict struct {
+	release chan struct{}
+}
+
+func (d stuckDict) ID() string   { return "stuck" }
+func (d stuckDict) Name() string { return "Stuck" }
+func (d stuckDict) Lookup(string) []dict.Entry {
+	<-d.release
+	return nil
+}
+func (d stuckDict) Prefix(string, int) []dict.Entry { return nil }
+func (d stuckDict) Search(string, int) []dict.Entry { return nil }
+
+func TestLookupPartial(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "ok.tsv")
+	if err := os.WriteFile(path, []byte("hello\tworld\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := filedict.Load("ok", "OK", path, "tsv", "\t", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck := stuckDict{release: make(chan struct{})}
+	defer close(stuck.release)
+	reg := registry.New()
+	if err := reg.AddAll([]dict.Dictionary{stuck, ok}); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewWithOptions(reg, service.Options{Concurrency: 2, DictTimeout: 50 * time.Millisecond})
+	h := NewRouter(svc, observability.New("error"), "")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/lookup?q=hello", nil))
+	var resp struct {
+		Count   int  `json:"count"`
+		Partial bool `json:"partial"`
+		Results []struct {
+			DictID   string `json:"dict_id"`
+			Error    string `json:"error"`
+			TimedOut bool   `json:"timed_out"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Partial || resp.Count != 2 || len(resp.Results) != 2 {
+		t.Fatalf("unexpected response: %s", rr.Body)
+	}
+	if r := resp.Results[0]; r.DictID != "stuck" || !r.TimedOut || r.Error != "timeout" {
+		t.Fatalf("stuck result = %+v", r)
+	}
+	if r := resp.Results[1]; r.DictID != "ok" || r.Error != "" {
+		t.Fatalf("ok result = %+v", r)
+	}
+}
+
+func TestPrefixMerged(t *testing.T) {
+	tmp := t.TempDir()
+	reg := registry.New()
+	for _, d := range []struct{ id, data string }{
+		{"a", "Apple\tx\napplet\tx\napply\tx\n"},
+		{"b", "apple\ty\napp\ty\n"},
+		{"c", "apply\tz\napplesauce\tz\n"},
+	} {
+		path := filepath.Join(tmp, d.id+".tsv")
+		if err := os.WriteFile(path, []byte(d.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fd, err := filedict.Load(d.id, "", path, "tsv", "\t", nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reg.Add(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewRouter(service.New(reg), observability.New("error"), "")
+
+	suggest := func(target string) string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp struct {
+			Count       int                  `json:"count"`
+			Suggestions []service.Suggestion `json:"suggestions"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Count != len(resp.Suggestions) {
+			t.Fatalf("count %d for %d suggestions", resp.Count, len(resp.Suggestions))
+		}
+		var out []string
+		for _, s := range resp.Suggestions {
+			out = append(out, s.Word+"="+strings.Join(s.Dicts, ","))
+		}
+		return strings.Join(out, " ")
+	}
+
+	if got, want := suggest("/prefix?q=app&merged=1"), "app=b Apple=a,b apply=a,c applet=a applesauce=c"; got != want {
+		t.Fatalf("merged = %q, want %q", got, want)
+	}
+	if got, want := suggest("/prefix?q=app&merged=1&limit=3"), "app=b Apple=a,b applesauce=c"; got != want {
+		t.Fatalf("merged limit 3 = %q, want %q", got, want)
+	}
+	if got, want := suggest("/prefix?q=app&merged=1&dict=c,a"), "apply=c,a applesauce=c Apple=a applet=a"; got != want {
+		t.Fatalf("merged dict=c,a = %q, want %q", got, want)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"time"
 )
 
-const currentVersion = 1
+const currentVersion = 2
 
 type Index struct {
 	Version     int
@@ -16,17 +16,23 @@ type Index struct {
 	SourceSize  int64
 	SourceMtime int64
 	CaseFold    bool
+	// Options fingerprints the loader settings (delimiter, schema) the
+	// index was built with.
+	Options string
 
 	Words    []string
 	Entries  map[string][]string
 	Original map[string]string
+	// Headwords[key][i] is the headword of Entries[key][i] when key is one
+	// of its aliases, and "" when key is the headword itself.
+	Headwords map[string][]string
 }
 
 func indexPath(sourcePath string) string {
 	return sourcePath + ".gdapi.idx"
 }
 
-func Load(sourcePath string, caseFold bool) (*Index, bool, error) {
+func Load(sourcePath string, caseFold bool, options string) (*Index, bool, error) {
 	info, err := os.Stat(sourcePath)
 	if err != nil {
 		return nil, false, err
@@ -49,7 +55,7 @@ func Load(sourcePath string, caseFold bool) (*Index, bool, error) {
 	if idx.Version != currentVersion {
 		return nil, false, nil
 	}
-	if idx.CaseFold != caseFold {
+	if idx.CaseFold != caseFold || idx.Options != options {
 		return nil, false, nil
 	}
 	if idx.SourceSize != info.Size() || idx.SourceMtime != info.ModTime().UnixNano() {
@@ -61,21 +67,17 @@ func Load(sourcePath string, caseFold bool) (*Index, bool, error) {
 	return &idx, true, nil
 }
 
-func Save(sourcePath string, caseFold bool, words []string, entries map[string][]string, original map[string]string) error {
+// Save writes idx next to sourcePath, filling in the version and source
+// fields.
+func Save(sourcePath string, idx *Index) error {
 	info, err := os.Stat(sourcePath)
 	if err != nil {
 		return err
 	}
-	idx := Index{
-		Version:     currentVersion,
-		SourcePath:  sourcePath,
-		SourceSize:  info.Size(),
-		SourceMtime: info.ModTime().UnixNano(),
-		CaseFold:    caseFold,
-		Words:       words,
-		Entries:     entries,
-		Original:    original,
-	}
+	idx.Version = currentVersion
+	idx.SourcePath = sourcePath
+	idx.SourceSize = info.Size()
+	idx.SourceMtime = info.ModTime().UnixNano()
 	idxPath := indexPath(sourcePath)
 	tmp := idxPath + "." + time.Now().Format("20060102150405") + ".tmp"
 	f, err := os.Create(tmp)
@@ -83,7 +85,7 @@ func Save(sourcePath string, caseFold bool, words []string, entries map[string][
 		return err
 	}
 	enc := gob.NewEncoder(f)
-	if err := enc.Encode(&idx); err != nil {
+	if err := enc.Encode(idx); err != nil {
 		_ = f.Close()
 		_ = os.Remove(tmp)
 		return err
@@ -0,0 +1,319 @@
+package reload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sagerenn/mdict/internal/config"
+	"github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/dict/loader"
+)
+
+var (
+	ErrNotFound = errors.New("unknown dictionary id")
+	ErrExists   = errors.New("dictionary id already in use")
+	// ErrDiscovered is returned when unloading a dictionary found in
+	// dictionary_dirs; it comes back on the next scan while its files stay.
+	ErrDiscovered = errors.New("dictionary comes from dictionary_dirs; remove its files instead")
+	// ErrNotLoaded is returned when enabling or disabling a dictionary
+	// that failed to load.
+	ErrNotLoaded = errors.New("dictionary is not loaded")
+)
+
+// LoadError wraps the error of a dictionary that failed to load.
+type LoadError struct {
+	Err error
+}
+
+func (e *LoadError) Error() string { return e.Err.Error() }
+func (e *LoadError) Unwrap() error { return e.Err }
+
+// PersistError is returned when a change was applied but the config file
+// could not be written.
+type PersistError struct {
+	Err error
+}
+
+func (e *PersistError) Error() string { return "save config: " + e.Err.Error() }
+func (e *PersistError) Unwrap() error { return e.Err }
+
+// DictStatus is the state of a configured or discovered dictionary.
+// Status is "loaded", "failed" (never loaded) or "stale" (the last reload
+// failed and the previous version is still served).
+type DictStatus struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Type     string     `json:"type,omitempty"`
+	Path     string     `json:"path"`
+	Source   string     `json:"source"`
+	Status   string     `json:"status"`
+	Enabled  bool       `json:"enabled"`
+	Error    string     `json:"error,omitempty"`
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+}
+
+// Skipped is a file in dictionary_dirs that was not loaded.
+type Skipped struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Status is the result of the last load: every dictionary in registry
+// order (failed ones last), plus errors not tied to a dictionary (scan
+// failures, duplicate ids) and skipped files.
+type Status struct {
+	Dictionaries []DictStatus `json:"dictionaries"`
+	Errors       []string     `json:"errors,omitempty"`
+	Skipped      []Skipped    `json:"skipped,omitempty"`
+}
+
+func (r *Reloader) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := Status{Dictionaries: make([]DictStatus, 0, len(r.loaded))}
+	seen := make(map[string]bool, len(r.loaded))
+	for _, d := range r.reg.All() {
+		if l, ok := r.loaded[d.ID()]; ok && l.dict == d {
+			out.Dictionaries = append(out.Dictionaries, r.status(l))
+			seen[d.ID()] = true
+		}
+	}
+	for _, id := range r.order {
+		if l, ok := r.loaded[id]; ok && !seen[id] {
+			out.Dictionaries = append(out.Dictionaries, r.status(l))
+		}
+	}
+	for _, e := range r.errs {
+		out.Errors = append(out.Errors, e.Error())
+	}
+	for _, s := range r.skipped {
+		out.Skipped = append(out.Skipped, Skipped{Path: s.Path, Reason: s.Reason})
+	}
+	return out
+}
+
+func (r *Reloader) status(l loaded) DictStatus {
+	st := DictStatus{
+		ID:     l.cfg.ID,
+		Name:   l.cfg.Name,
+		Type:   l.cfg.Type,
+		Path:   l.cfg.Path,
+		Source: l.source,
+		Status: "loaded",
+	}
+	if l.dict != nil {
+		st.Name = l.dict.Name()
+		st.Enabled = r.reg.Enabled(l.cfg.ID)
+		at := l.loadedAt
+		st.LoadedAt = &at
+	}
+	if l.err != nil {
+		st.Error = l.err.Error()
+		st.Status = "stale"
+		if l.dict == nil {
+			st.Status = "failed"
+		}
+	}
+	return st
+}
+
+// Load loads a new dictionary, registers it after the others and adds it
+// to the config file.
+func (r *Reloader) Load(dc config.DictConfig) (DictStatus, error) {
+	dc.ID = strings.TrimSpace(dc.ID)
+	dc.Path = strings.TrimSpace(dc.Path)
+	if dc.ID == "" || dc.Path == "" {
+		return DictStatus{}, &LoadError{Err: errors.New("id and path are required")}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.loaded[dc.ID]; ok {
+		return DictStatus{}, fmt.Errorf("%w: %s", ErrExists, dc.ID)
+	}
+	st := statSources(dc)
+	d, err := loader.Load(dc)
+	if err != nil {
+		return DictStatus{}, &LoadError{Err: err}
+	}
+	if err := r.reg.Add(d); err != nil {
+		_ = closeDict(d)
+		return DictStatus{}, fmt.Errorf("%w: %s", ErrExists, dc.ID)
+	}
+	l := loaded{cfg: dc, source: SourceConfig, stamps: st, dict: d, loadedAt: time.Now()}
+	r.loaded[dc.ID] = l
+	r.order = append(r.order, dc.ID)
+	r.svc.Invalidate()
+
+	r.cfg.Dictionaries = append(r.cfg.Dictionaries, dc)
+	return r.status(l), r.save()
+}
+
+// Unload removes a configured dictionary from the registry and the config
+// file.
+func (r *Reloader) Unload(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.loaded[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if l.source == SourceDir {
+		return fmt.Errorf("%w: %s", ErrDiscovered, id)
+	}
+	if l.dict != nil {
+		_ = r.reg.Remove(id)
+	}
+	delete(r.loaded, id)
+	for i, o := range r.order {
+		if o == id {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.svc.Invalidate()
+
+	dicts := make([]config.DictConfig, 0, len(r.cfg.Dictionaries))
+	for _, dc := range r.cfg.Dictionaries {
+		if dc.ID != id {
+			dicts = append(dicts, dc)
+		}
+	}
+	r.cfg.Dictionaries = dicts
+	return r.save()
+}
+
+// ReloadDict loads a dictionary again from its files and replaces it in
+// the registry. On failure the previous version keeps serving.
+func (r *Reloader) ReloadDict(id string) (DictStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked(id, false)
+}
+
+// Rebuild deletes the index caches of a dictionary and reloads it, which
+// rebuilds them from the source files.
+func (r *Reloader) Rebuild(id string) (DictStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked(id, true)
+}
+
+func (r *Reloader) reloadLocked(id string, rebuild bool) (DictStatus, error) {
+	l, ok := r.loaded[id]
+	if !ok {
+		return DictStatus{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if rebuild {
+		if err := loader.RemoveCaches(l.cfg); err != nil {
+			return r.status(l), err
+		}
+	}
+	st := statSources(l.cfg)
+	d, err := loader.Load(l.cfg)
+	if err != nil {
+		l.err = err
+		l.stamps = st
+		r.loaded[id] = l
+		return r.status(l), &LoadError{Err: err}
+	}
+	if l.dict != nil {
+		err = r.reg.Replace(d)
+	} else {
+		err = r.reg.Add(d)
+	}
+	if err != nil {
+		_ = closeDict(d)
+		return r.status(l), err
+	}
+	l.dict, l.stamps, l.loadedAt, l.err = d, st, time.Now(), nil
+	r.loaded[id] = l
+	r.svc.Invalidate()
+	return r.status(l), nil
+}
+
+// SetEnabled enables or disables a loaded dictionary. Disabled
+// dictionaries stay loaded but are left out of queries and /dicts. The
+// state is kept across reloads but not saved to the config file.
+func (r *Reloader) SetEnabled(id string, enabled bool) (DictStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.loaded[id]
+	if !ok {
+		return DictStatus{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if l.dict == nil {
+		return r.status(l), fmt.Errorf("%w: %s", ErrNotLoaded, id)
+	}
+	if err := r.reg.SetEnabled(id, enabled); err != nil {
+		return r.status(l), err
+	}
+	r.svc.Invalidate()
+	return r.status(l), nil
+}
+
+// Reorder moves the given dictionaries to the front in the given order and
+// saves the order of the configured ones. Discovered dictionaries always
+// follow the configured ones after a reload.
+func (r *Reloader) Reorder(ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := r.loaded[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+	}
+	if err := r.reg.Reorder(registered(ids, r.loaded)); err != nil {
+		return err
+	}
+	r.svc.Invalidate()
+
+	rank := make(map[string]int, len(ids))
+	for i, id := range ids {
+		rank[id] = i
+	}
+	var front, rest []config.DictConfig
+	for _, dc := range r.cfg.Dictionaries {
+		if _, ok := rank[dc.ID]; ok {
+			front = append(front, dc)
+		} else {
+			rest = append(rest, dc)
+		}
+	}
+	sort.SliceStable(front, func(i, j int) bool { return rank[front[i].ID] < rank[front[j].ID] })
+	r.cfg.Dictionaries = append(front, rest...)
+	return r.save()
+}
+
+// registered keeps the ids that have a dictionary in the registry.
+func registered(ids []string, loaded map[string]loaded) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if loaded[id].dict != nil {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func closeDict(d dict.Dictionary) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// save writes the dictionaries to the config file and records its new
+// state, so polling does not see the change as an edit. r.mu must be held.
+func (r *Reloader) save() error {
+	if err := config.SaveDictionaries(r.path, r.cfg.Dictionaries); err != nil {
+		return &PersistError{Err: err}
+	}
+	r.cfgStamp = statFile(r.path)
+	return nil
+}
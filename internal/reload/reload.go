@@ -0,0 +1,302 @@
+// Package reload keeps the registry in sync with the config file: it loads
+// new and changed dictionaries in the background, swaps them into the
+// registry in one step and retires the ones that are gone.
+package reload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sagerenn/mdict/internal/config"
+	"github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/dict/loader"
+	"github.com/sagerenn/mdict/internal/dict/registry"
+	"github.com/sagerenn/mdict/internal/observability"
+	"github.com/sagerenn/mdict/internal/service"
+)
+
+type Reloader struct {
+	path string
+	reg  *registry.Registry
+	svc  *service.Service
+	log  *observability.Logger
+
+	trigger chan struct{}
+
+	mu         sync.Mutex // serializes Apply and the admin operations
+	cfg        config.Config
+	cfgStamp   stamp
+	loaded     map[string]loaded
+	order      []string
+	discovered map[string]bool
+	errs       []error
+	skipped    []loader.Skipped
+}
+
+// loaded is a configured or discovered dictionary with the config and
+// source file state it was last loaded from. dict is nil if it never
+// loaded; err is the error of the last attempt.
+type loaded struct {
+	cfg      config.DictConfig
+	source   string
+	stamps   []stamp
+	dict     dict.Dictionary
+	loadedAt time.Time
+	err      error
+}
+
+// Sources of a dictionary config.
+const (
+	SourceConfig = "config"
+	SourceDir    = "dir"
+)
+
+type stamp struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+func statFile(path string) stamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{path: path}
+	}
+	return stamp{path: path, size: info.Size(), mtime: info.ModTime()}
+}
+
+// statSources stats every file dc is loaded from. Companion files that
+// appear or disappear change the result as well.
+func statSources(dc config.DictConfig) []stamp {
+	paths := loader.SourceFiles(dc)
+	out := make([]stamp, len(paths))
+	for i, p := range paths {
+		out[i] = statFile(p)
+	}
+	return out
+}
+
+// New returns a Reloader for the config file at path, which the caller has
+// just read.
+func New(path string, reg *registry.Registry, svc *service.Service, log *observability.Logger) *Reloader {
+	return &Reloader{
+		path:     path,
+		reg:      reg,
+		svc:      svc,
+		log:      log,
+		trigger:  make(chan struct{}, 1),
+		cfgStamp: statFile(path),
+		loaded:   make(map[string]loaded),
+	}
+}
+
+// Trigger asks Run to reload; it never blocks, and triggers that arrive
+// while a reload is pending are merged.
+func (r *Reloader) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run reloads on Trigger and, when interval is positive, whenever polling
+// finds a change, until ctx is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.trigger:
+			r.log.Info("reloading dictionaries", "reason", "signal")
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+			r.log.Info("reloading dictionaries", "reason", "change detected")
+		}
+		if err := r.Reload(); err != nil {
+			r.log.Error("reload failed", "error", err)
+		}
+	}
+}
+
+// Reload re-reads the config file and applies it.
+func (r *Reloader) Reload() error {
+	st := statFile(r.path)
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		return err
+	}
+	r.Apply(cfg)
+	r.mu.Lock()
+	r.cfgStamp = st
+	r.mu.Unlock()
+	return nil
+}
+
+// Apply loads the dictionaries of cfg and swaps them into the registry.
+// Dictionaries whose config and source file are unchanged are kept as they
+// are; a changed dictionary that fails to load keeps its previous version.
+func (r *Reloader) Apply(cfg config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found := loader.Discover(cfg.DictionaryDirs, cfg.Dictionaries)
+	errs := append([]error(nil), found.Errs...)
+	for _, e := range found.Errs {
+		r.log.Error("dictionary load error", "error", e)
+	}
+	for _, s := range found.Skipped {
+		r.log.Debug("dictionary file skipped", "path", s.Path, "reason", s.Reason)
+	}
+	discovered := make(map[string]bool, len(found.Dicts))
+	for _, d := range found.Dicts {
+		discovered[d.Path] = true
+	}
+	all := make([]config.DictConfig, 0, len(cfg.Dictionaries)+len(found.Dicts))
+	all = append(all, cfg.Dictionaries...)
+	all = append(all, found.Dicts...)
+
+	var (
+		next  = make(map[string]loaded, len(all))
+		order = make([]string, 0, len(all))
+		dicts = make([]dict.Dictionary, 0, len(all))
+		kept  = make(map[dict.Dictionary]bool)
+		stats struct{ loaded, kept, failed int }
+	)
+	for i, dc := range all {
+		source := SourceConfig
+		if i >= len(cfg.Dictionaries) {
+			source = SourceDir
+		}
+		if _, dup := next[dc.ID]; dup {
+			err := errors.New("duplicate dictionary id: " + dc.ID)
+			r.log.Error("dictionary load error", "error", err)
+			errs = append(errs, err)
+			stats.failed++
+			continue
+		}
+		order = append(order, dc.ID)
+		st := statSources(dc)
+		prev, had := r.loaded[dc.ID]
+		if had && prev.dict != nil && prev.err == nil && slices.Equal(prev.stamps, st) && reflect.DeepEqual(prev.cfg, dc) {
+			prev.source = source
+			next[dc.ID] = prev
+			dicts = append(dicts, prev.dict)
+			kept[prev.dict] = true
+			stats.kept++
+			continue
+		}
+		d, err := loader.Load(dc)
+		if err != nil {
+			r.log.Error("dictionary load error", "error", err)
+			stats.failed++
+			// The stamps are updated so polling retries when the files
+			// change again, not on every tick. A previous version keeps
+			// serving.
+			l := loaded{cfg: dc, source: source, stamps: st, err: err}
+			if had && prev.dict != nil {
+				l.dict = prev.dict
+				l.loadedAt = prev.loadedAt
+				dicts = append(dicts, prev.dict)
+				kept[prev.dict] = true
+			}
+			next[dc.ID] = l
+			continue
+		}
+		next[dc.ID] = loaded{cfg: dc, source: source, stamps: st, dict: d, loadedAt: time.Now()}
+		dicts = append(dicts, d)
+		stats.loaded++
+	}
+
+	r.svc.SetGroups(groups(cfg.Groups, next, r.log))
+
+	old, err := r.reg.Swap(dicts)
+	if err != nil {
+		// Swap only fails on nil or duplicate dictionaries, which are
+		// filtered above.
+		r.log.Error("registry swap failed", "error", err)
+		return
+	}
+	r.svc.Invalidate()
+
+	// The registry closes the retired dictionaries.
+	retired := 0
+	for _, d := range old {
+		if !kept[d] {
+			retired++
+		}
+	}
+
+	r.cfg = cfg
+	r.loaded = next
+	r.order = order
+	r.discovered = discovered
+	r.errs = errs
+	r.skipped = found.Skipped
+	r.log.Info("dictionaries loaded", "loaded", stats.loaded, "kept", stats.kept, "failed", stats.failed, "retired", retired)
+}
+
+// groups converts the configured groups, logging references to unknown
+// dictionaries and duplicate group ids.
+func groups(cfg []config.GroupConfig, known map[string]loaded, log *observability.Logger) []service.Group {
+	out := make([]service.Group, 0, len(cfg))
+	seen := make(map[string]bool, len(cfg))
+	for _, g := range cfg {
+		if g.ID == "" || seen[g.ID] {
+			log.Error("invalid dictionary group", "group", g.ID, "error", "missing or duplicate id")
+			continue
+		}
+		seen[g.ID] = true
+		for _, id := range g.Dictionaries {
+			if _, ok := known[id]; !ok {
+				log.Warn("dictionary group references unknown dictionary", "group", g.ID, "dict", id)
+			}
+		}
+		name := g.Name
+		if name == "" {
+			name = g.ID
+		}
+		out = append(out, service.Group{ID: g.ID, Name: name, Dicts: append([]string(nil), g.Dictionaries...)})
+	}
+	return out
+}
+
+// changed reports whether the config file, a file a dictionary is loaded
+// from or the set of files in dictionary_dirs differs from the last Apply.
+func (r *Reloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if statFile(r.path) != r.cfgStamp {
+		return true
+	}
+	for _, l := range r.loaded {
+		if !slices.Equal(statSources(l.cfg), l.stamps) {
+			return true
+		}
+	}
+	if len(r.cfg.DictionaryDirs) == 0 {
+		return false
+	}
+	found := loader.Discover(r.cfg.DictionaryDirs, r.cfg.Dictionaries)
+	if len(found.Dicts) != len(r.discovered) {
+		return true
+	}
+	for _, d := range found.Dicts {
+		if !r.discovered[d.Path] {
+			return true
+		}
+	}
+	return false
+}
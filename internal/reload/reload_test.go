@@ -0,0 +1,126 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sagerenn/mdict/internal/config"
+	"github.com/sagerenn/mdict/internal/dict/registry"
+	"github.com/sagerenn/mdict/internal/observability"
+	"github.com/sagerenn/mdict/internal/service"
+)
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	then := time.Now().Add(-time.Hour)
+	a := write("a.tsv", "cat\tmeow\n", then)
+	b := write("b.tsv", "dog\twoof\n", then)
+
+	reg := registry.New()
+	svc := service.New(reg)
+	r := New(filepath.Join(dir, "gdapi.json"), reg, svc, observability.New("error"))
+	r.Apply(config.Config{Dictionaries: []config.DictConfig{
+		{ID: "a", Type: "tsv", Path: a},
+		{ID: "b", Type: "tsv", Path: b},
+	}})
+	oldA, _ := reg.Get("a")
+	oldB, _ := reg.Get("b")
+	if got := svc.Lookup(context.Background(), "cat", nil, 0); len(got) != 1 || got[0].Entries[0].Definition != "meow" {
+		t.Fatalf("Lookup(cat) = %+v", got)
+	}
+
+	write("a.tsv", "cat\tpurr\n", time.Now())
+	r.Apply(config.Config{Dictionaries: []config.DictConfig{
+		{ID: "a", Type: "tsv", Path: a},
+		{ID: "c", Type: "tsv", Path: b},
+	}})
+	if d, _ := reg.Get("a"); d == oldA {
+		t.Error("changed dictionary a was not reloaded")
+	}
+	if _, ok := reg.Get("b"); ok {
+		t.Error("removed dictionary b is still registered")
+	}
+	if d, _ := reg.Get("c"); d == nil || d == oldB {
+		t.Error("dictionary c was not loaded")
+	}
+	if got := svc.Lookup(context.Background(), "cat", nil, 0); len(got) != 1 || got[0].Entries[0].Definition != "purr" {
+		t.Fatalf("Lookup(cat) after reload = %+v", got)
+	}
+
+	r.Apply(config.Config{Dictionaries: []config.DictConfig{
+		{ID: "a", Type: "tsv", Path: a},
+		{ID: "c", Type: "tsv", Path: b},
+	}})
+	newA, _ := reg.Get("a")
+	r.Apply(config.Config{Dictionaries: []config.DictConfig{
+		{ID: "a", Type: "tsv", Path: a},
+		{ID: "c", Type: "tsv", Path: filepath.Join(dir, "missing.tsv")},
+	}})
+	if d, _ := reg.Get("a"); d != newA {
+		t.Error("unchanged dictionary a was reloaded")
+	}
+	if _, ok := reg.Get("c"); !ok {
+		t.Error("dictionary c was dropped after a failed reload")
+	}
+}
+
+func TestChangedCompanionFiles(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	write := func(name, data string) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("test.index", "cat\tA\tE\n")
+	write("test.dict", "cat\n meow")
+	write("gdapi.json", "{}")
+
+	reg := registry.New()
+	r := New(filepath.Join(dir, "gdapi.json"), reg, service.New(reg), observability.New("error"))
+	r.Apply(config.Config{Dictionaries: []config.DictConfig{
+		{ID: "d", Type: "dictd", Path: filepath.Join(dir, "test.index")},
+	}})
+	if _, ok := reg.Get("d"); !ok {
+		t.Fatal("dictionary d was not loaded")
+	}
+	if r.changed() {
+		t.Fatal("changed before any file was modified")
+	}
+
+	// The data file is not the configured path.
+	write("test.dict", "cat\n purr")
+	if err := os.Chtimes(filepath.Join(dir, "test.dict"), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !r.changed() {
+		t.Fatal("modified .dict file was not detected")
+	}
+	r.Apply(r.cfg)
+	if r.changed() {
+		t.Fatal("changed after reload")
+	}
+
+	// A compressed data file takes precedence once it appears.
+	write("test.dict.dz", "")
+	if !r.changed() {
+		t.Fatal("new .dict.dz file was not detected")
+	}
+}
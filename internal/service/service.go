@@ -1,9 +1,13 @@
 package service
 
 import (
-	"sort"
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sagerenn/mdict/internal/cache"
@@ -12,30 +16,127 @@ import (
 )
 
 type Service struct {
-	reg   *registry.Registry
-	cache *cache.Cache
+	reg    *registry.Registry
+	cache  *cache.Cache
+	opts   Options
+	mu     sync.RWMutex
+	groups []Group
+	// gen and the registry version are part of every cache key, so results
+	// computed from an older set of dictionaries are never served.
+	gen atomic.Uint64
 }
 
+// Group is a named, ordered set of dictionaries.
+type Group struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Dicts []string `json:"dicts"`
+}
+
+// ErrUnknownGroup is returned by Select for a group that is not configured.
+var ErrUnknownGroup = errors.New("unknown group")
+
+// Options bound how a request fans out over dictionaries.
+type Options struct {
+	// Concurrency is the number of dictionaries queried at once per
+	// request; 0 means 8.
+	Concurrency int
+	// DictTimeout bounds each dictionary's part of a request, including
+	// the wait for a free slot; 0 means only the request context applies.
+	DictTimeout time.Duration
+}
+
+// ResultEntries and ResultWords hold the answer of one dictionary. When
+// the dictionary failed or ran out of time, Error says why and TimedOut
+// marks a deadline.
 type ResultEntries struct {
 	DictID   string       `json:"dict_id"`
 	DictName string       `json:"dict_name"`
 	Entries  []dict.Entry `json:"entries"`
+	Error    string       `json:"error,omitempty"`
+	TimedOut bool         `json:"timed_out,omitempty"`
 }
 
 type ResultWords struct {
 	DictID   string   `json:"dict_id"`
 	DictName string   `json:"dict_name"`
 	Words    []string `json:"words"`
+	Error    string   `json:"error,omitempty"`
+	TimedOut bool     `json:"timed_out,omitempty"`
 }
 
+// Failed reports whether the dictionary failed or timed out.
+func (r ResultEntries) Failed() bool { return r.Error != "" }
+
+func (r ResultWords) Failed() bool { return r.Error != "" }
+
 func New(reg *registry.Registry) *Service {
+	return NewWithOptions(reg, Options{})
+}
+
+func NewWithOptions(reg *registry.Registry, opts Options) *Service {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
 	return &Service{
 		reg:   reg,
 		cache: cache.New(1024, 5*time.Minute),
+		opts:  opts,
 	}
 }
 
-func (s *Service) Lookup(word string, dictIDs []string, limit int) []ResultEntries {
+// SetGroups replaces the configured groups.
+func (s *Service) SetGroups(groups []Group) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = append([]Group(nil), groups...)
+}
+
+func (s *Service) Groups() []Group {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Group(nil), s.groups...)
+}
+
+// Select returns the dictionary ids to query. Without a group it returns
+// dictIDs unchanged (nil means every dictionary). With a group it returns
+// the group's dictionaries in group order, limited to dictIDs when given;
+// the result is never nil, so an empty selection matches nothing.
+func (s *Service) Select(group string, dictIDs []string) ([]string, error) {
+	if group == "" {
+		return dictIDs, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, g := range s.groups {
+		if g.ID != group {
+			continue
+		}
+		var only map[string]bool
+		if dictIDs != nil {
+			only = make(map[string]bool, len(dictIDs))
+			for _, id := range dictIDs {
+				only[id] = true
+			}
+		}
+		out := make([]string, 0, len(g.Dicts))
+		for _, id := range g.Dicts {
+			if only == nil || only[id] {
+				out = append(out, id)
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+}
+
+// Invalidate drops cached results; call it after the registry changes.
+func (s *Service) Invalidate() {
+	s.gen.Add(1)
+	s.cache.Clear()
+}
+
+func (s *Service) Lookup(ctx context.Context, word string, dictIDs []string, limit int) []ResultEntries {
 	if limit <= 0 {
 		limit = 20
 	}
@@ -43,16 +144,27 @@ func (s *Service) Lookup(word string, dictIDs []string, limit int) []ResultEntri
 	if word == "" {
 		return nil
 	}
-	cacheKey := makeKey("lookup", word, dictIDs, limit)
+	cacheKey := s.makeKey("lookup", word, dictIDs, limit)
 	if v, ok := s.cache.Get(cacheKey); ok {
 		if res, ok := v.([]ResultEntries); ok {
 			return res
 		}
 	}
 	dicts := s.resolveDicts(dictIDs)
+	outcomes := fanOut(ctx, s.opts, dicts, func(ctx context.Context, d dict.Dictionary) ([]dict.Entry, error) {
+		return dict.WithContext(d).LookupContext(ctx, word)
+	})
 	results := make([]ResultEntries, 0, len(dicts))
-	for _, d := range dicts {
-		entries := d.Lookup(word)
+	complete := true
+	for i, d := range dicts {
+		o := outcomes[i]
+		if o.err != nil {
+			complete = false
+			msg, timedOut := errorMarker(o.err)
+			results = append(results, ResultEntries{DictID: d.ID(), DictName: d.Name(), Entries: []dict.Entry{}, Error: msg, TimedOut: timedOut})
+			continue
+		}
+		entries := o.value
 		if len(entries) > limit {
 			entries = entries[:limit]
 		}
@@ -65,11 +177,13 @@ func (s *Service) Lookup(word string, dictIDs []string, limit int) []ResultEntri
 			Entries:  entries,
 		})
 	}
-	s.cache.Set(cacheKey, results)
+	if complete {
+		s.cache.Set(cacheKey, results)
+	}
 	return results
 }
 
-func (s *Service) Prefix(prefix string, dictIDs []string, limit int) []ResultWords {
+func (s *Service) Prefix(ctx context.Context, prefix string, dictIDs []string, limit int) []ResultWords {
 	if limit <= 0 {
 		limit = 20
 	}
@@ -77,34 +191,12 @@ func (s *Service) Prefix(prefix string, dictIDs []string, limit int) []ResultWor
 	if prefix == "" {
 		return nil
 	}
-	cacheKey := makeKey("prefix", prefix, dictIDs, limit)
-	if v, ok := s.cache.Get(cacheKey); ok {
-		if res, ok := v.([]ResultWords); ok {
-			return res
-		}
-	}
-	dicts := s.resolveDicts(dictIDs)
-	results := make([]ResultWords, 0, len(dicts))
-	for _, d := range dicts {
-		entries := d.Prefix(prefix, limit)
-		if len(entries) == 0 {
-			continue
-		}
-		words := make([]string, 0, len(entries))
-		for _, e := range entries {
-			words = append(words, e.Word)
-		}
-		results = append(results, ResultWords{
-			DictID:   d.ID(),
-			DictName: d.Name(),
-			Words:    words,
-		})
-	}
-	s.cache.Set(cacheKey, results)
-	return results
+	return s.words(ctx, "prefix", prefix, dictIDs, limit, func(ctx context.Context, d dict.Dictionary) ([]dict.Entry, error) {
+		return dict.WithContext(d).PrefixContext(ctx, prefix, limit)
+	})
 }
 
-func (s *Service) Search(query string, dictIDs []string, limit int) []ResultWords {
+func (s *Service) Search(ctx context.Context, query string, dictIDs []string, limit int) []ResultWords {
 	if limit <= 0 {
 		limit = 20
 	}
@@ -112,21 +204,37 @@ func (s *Service) Search(query string, dictIDs []string, limit int) []ResultWord
 	if query == "" {
 		return nil
 	}
-	cacheKey := makeKey("search", query, dictIDs, limit)
+	return s.words(ctx, "search", query, dictIDs, limit, func(ctx context.Context, d dict.Dictionary) ([]dict.Entry, error) {
+		return dict.WithContext(d).SearchContext(ctx, query, limit)
+	})
+}
+
+// words runs a Prefix or Search query and collects the words per
+// dictionary.
+func (s *Service) words(ctx context.Context, op, q string, dictIDs []string, limit int, query func(context.Context, dict.Dictionary) ([]dict.Entry, error)) []ResultWords {
+	cacheKey := s.makeKey(op, q, dictIDs, limit)
 	if v, ok := s.cache.Get(cacheKey); ok {
 		if res, ok := v.([]ResultWords); ok {
 			return res
 		}
 	}
 	dicts := s.resolveDicts(dictIDs)
+	outcomes := fanOut(ctx, s.opts, dicts, query)
 	results := make([]ResultWords, 0, len(dicts))
-	for _, d := range dicts {
-		entries := d.Search(query, limit)
-		if len(entries) == 0 {
+	complete := true
+	for i, d := range dicts {
+		o := outcomes[i]
+		if o.err != nil {
+			complete = false
+			msg, timedOut := errorMarker(o.err)
+			results = append(results, ResultWords{DictID: d.ID(), DictName: d.Name(), Words: []string{}, Error: msg, TimedOut: timedOut})
+			continue
+		}
+		if len(o.value) == 0 {
 			continue
 		}
-		words := make([]string, 0, len(entries))
-		for _, e := range entries {
+		words := make([]string, 0, len(o.value))
+		for _, e := range o.value {
 			words = append(words, e.Word)
 		}
 		results = append(results, ResultWords{
@@ -135,12 +243,70 @@ func (s *Service) Search(query string, dictIDs []string, limit int) []ResultWord
 			Words:    words,
 		})
 	}
-	s.cache.Set(cacheKey, results)
+	if complete {
+		s.cache.Set(cacheKey, results)
+	}
 	return results
 }
 
+type outcome[T any] struct {
+	value T
+	err   error
+}
+
+// fanOut runs query on every dictionary, at most opts.Concurrency at a
+// time, each under opts.DictTimeout. A call abandoned at its deadline keeps
+// its slot until it returns, so backends that ignore the context cannot
+// push the number of running calls past the limit. Outcomes are in the
+// order of dicts.
+func fanOut[T any](ctx context.Context, opts Options, dicts []dict.Dictionary, query func(context.Context, dict.Dictionary) (T, error)) []outcome[T] {
+	out := make([]outcome[T], len(dicts))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, d := range dicts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dctx := ctx
+			if opts.DictTimeout > 0 {
+				var cancel context.CancelFunc
+				dctx, cancel = context.WithTimeout(ctx, opts.DictTimeout)
+				defer cancel()
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-dctx.Done():
+				out[i].err = dctx.Err()
+				return
+			}
+			var running sync.WaitGroup
+			defer func() {
+				go func() {
+					running.Wait()
+					<-sem
+				}()
+			}()
+			out[i].value, out[i].err = query(dict.TrackDetached(dctx, &running), d)
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func errorMarker(err error) (string, bool) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout", true
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled", false
+	}
+	return err.Error(), false
+}
+
+// resolveDicts returns the dictionaries for ids in the order given; nil
+// ids means every enabled dictionary in registry order.
 func (s *Service) resolveDicts(ids []string) []dict.Dictionary {
-	if len(ids) == 0 {
+	if ids == nil {
 		return s.reg.List()
 	}
 	out := make([]dict.Dictionary, 0, len(ids))
@@ -170,16 +336,17 @@ func (s *Service) Resource(dictID, name string) ([]byte, string, bool) {
 	return nil, "", false
 }
 
-func makeKey(op, q string, dictIDs []string, limit int) string {
-	if len(dictIDs) > 0 {
+func (s *Service) makeKey(op, q string, dictIDs []string, limit int) string {
+	op = strconv.FormatUint(s.gen.Load(), 10) + "." + strconv.FormatUint(s.reg.Version(), 10) + "|" + op
+	if dictIDs != nil {
+		// Results follow the order of dictIDs, so it is part of the key.
 		ids := make([]string, 0, len(dictIDs))
 		for _, id := range dictIDs {
 			if id != "" {
 				ids = append(ids, id)
 			}
 		}
-		sort.Strings(ids)
-		return op + "|" + q + "|" + strings.Join(ids, ",") + "|" + strconv.Itoa(limit)
+		return op + "|" + q + "|=" + strings.Join(ids, ",") + "|" + strconv.Itoa(limit)
 	}
-	return op + "|" + q + "||" + strconv.Itoa(limit)
+	return op + "|" + q + "|*|" + strconv.Itoa(limit)
 }
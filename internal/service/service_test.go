@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sagerenn/mdict/internal/dict"
+	"github.com/sagerenn/mdict/internal/dict/filedict"
+	"github.com/sagerenn/mdict/internal/dict/registry"
+)
+
+// slowDict blocks every lookup until release is closed and tracks how many
+// lookups run at once.
+type slowDict struct {
+	id      string
+	release chan struct{}
+	running *atomic.Int32
+	peak    *atomic.Int32
+}
+
+func (d slowDict) ID() string   { return d.id }
+func (d slowDict) Name() string { return d.id }
+func (d slowDict) Lookup(string) []dict.Entry {
+	n := d.running.Add(1)
+	for {
+		p := d.peak.Load()
+		if n <= p || d.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	<-d.release
+	d.running.Add(-1)
+	return nil
+}
+func (d slowDict) Prefix(string, int) []dict.Entry { return nil }
+func (d slowDict) Search(string, int) []dict.Entry { return nil }
+
+func TestConcurrencyCappedAfterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var running, peak atomic.Int32
+	reg := registry.New()
+	for i := 0; i < 6; i++ {
+		d := slowDict{id: "d" + strconv.Itoa(i), release: release, running: &running, peak: &peak}
+		if err := reg.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := NewWithOptions(reg, Options{Concurrency: 2, DictTimeout: 30 * time.Millisecond})
+
+	results := svc.Lookup(context.Background(), "word", nil, 0)
+	if len(results) != 6 {
+		t.Fatalf("got %d results, want 6", len(results))
+	}
+	for _, r := range results {
+		if !r.TimedOut {
+			t.Fatalf("result %+v did not time out", r)
+		}
+	}
+	// The lookups abandoned at the deadline still hold their slots, so the
+	// other dictionaries timed out waiting instead of piling on.
+	if r, p := running.Load(), peak.Load(); r != 2 || p != 2 {
+		t.Fatalf("%d lookups running, peak %d; want 2", r, p)
+	}
+	close(release)
+}
+
+func TestSuggestCaseSensitive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cs.tsv")
+	if err := os.WriteFile(path, []byte("aZ\tx\naZb\tx\nab\tx\nAbc\tx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The case-sensitive index is ordered aZ, aZb, ab; folded it is ab,
+	// az, azb.
+	d, err := filedict.Load("cs", "", path, "tsv", "\t", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := registry.New()
+	if err := reg.Add(d); err != nil {
+		t.Fatal(err)
+	}
+	res := New(reg).Suggest(context.Background(), "a", nil, 2)
+	var got []string
+	for _, w := range res.Words {
+		got = append(got, w.Word)
+	}
+	if strings.Join(got, ",") != "ab,aZ" {
+		t.Fatalf("suggestions = %v, want ab,aZ", got)
+	}
+}
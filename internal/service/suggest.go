@@ -0,0 +1,233 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sagerenn/mdict/internal/dict"
+)
+
+// Suggestion is a merged prefix suggestion: a word and the dictionaries
+// that contain it, in priority order.
+type Suggestion struct {
+	Word  string   `json:"word"`
+	Dicts []string `json:"dicts"`
+}
+
+// DictError marks a dictionary that failed or timed out.
+type DictError struct {
+	DictID   string `json:"dict_id"`
+	DictName string `json:"dict_name"`
+	Error    string `json:"error"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+}
+
+// Suggestions is the result of Suggest. Dictionaries in Errors contributed
+// no words.
+type Suggestions struct {
+	Words  []Suggestion
+	Errors []DictError
+}
+
+// Suggest merges the prefix matches of the dictionaries into one list.
+// Each dictionary contributes its first limit matches in case-folded
+// order; they are k-way merged and deduplicated by case-folded normalized
+// form, keeping the first limit distinct words. These are ranked by exact
+// match first, then by the priority of the first dictionary containing the
+// word (registry, group or dict order), then by frequency, and finally
+// alphabetically. Frequency is the number of dictionaries containing the
+// word, not a corpus frequency.
+func (s *Service) Suggest(ctx context.Context, prefix string, dictIDs []string, limit int) Suggestions {
+	if limit <= 0 {
+		limit = 20
+	}
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return Suggestions{}
+	}
+	cacheKey := s.makeKey("suggest", prefix, dictIDs, limit)
+	if v, ok := s.cache.Get(cacheKey); ok {
+		if res, ok := v.(Suggestions); ok {
+			return res
+		}
+	}
+	dicts := s.resolveDicts(dictIDs)
+	outcomes := fanOut(ctx, s.opts, dicts, func(ctx context.Context, d dict.Dictionary) ([]candidate, error) {
+		return prefixCandidates(ctx, d, prefix, limit)
+	})
+	var (
+		res     Suggestions
+		cursors = make(mergeHeap, 0, len(dicts))
+	)
+	for i, d := range dicts {
+		o := outcomes[i]
+		if o.err != nil {
+			msg, timedOut := errorMarker(o.err)
+			res.Errors = append(res.Errors, DictError{DictID: d.ID(), DictName: d.Name(), Error: msg, TimedOut: timedOut})
+			continue
+		}
+		if len(o.value) > 0 {
+			cursors = append(cursors, cursor{dict: i, items: o.value})
+		}
+	}
+	res.Words = rank(mergeCandidates(cursors, limit), dicts, foldKey(prefix))
+	if res.Errors == nil {
+		s.cache.Set(cacheKey, res)
+	}
+	return res
+}
+
+// candidate is a headword with its case-folded normalized form.
+type candidate struct {
+	key  string
+	word string
+}
+
+// prefixCandidates returns up to limit prefix matches of d ordered by key.
+// Backends with a sorted index are read directly; others go through
+// Prefix and their order is taken as is.
+func prefixCandidates(ctx context.Context, d dict.Dictionary, prefix string, limit int) (out []candidate, err error) {
+	var norm, words []string
+	if sp, ok := d.(dict.SortedPrefixer); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				out, err = nil, fmt.Errorf("panic: %v", p)
+			}
+		}()
+		norm, words = sp.PrefixSorted(prefix, limit)
+		if !folded(norm) {
+			// A case-sensitive index is not in folded order, so its first
+			// limit matches need not be the first limit folded keys. The
+			// whole prefix range is read and cut after sorting.
+			norm, words = sp.PrefixSorted(prefix, math.MaxInt)
+		}
+	} else {
+		entries, err := dict.WithContext(d).PrefixContext(ctx, prefix, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			norm = append(norm, e.Word)
+			words = append(words, e.Word)
+		}
+	}
+	out = make([]candidate, len(norm))
+	for i := range norm {
+		out[i] = candidate{key: foldKey(norm[i]), word: words[i]}
+	}
+	if !sort.SliceIsSorted(out, func(i, j int) bool { return out[i].key < out[j].key }) {
+		sort.SliceStable(out, func(i, j int) bool { return out[i].key < out[j].key })
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func foldKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// folded reports whether every normalized form is already case-folded.
+func folded(norm []string) bool {
+	for _, n := range norm {
+		if foldKey(n) != n {
+			return false
+		}
+	}
+	return true
+}
+
+// cursor walks the candidates of the dictionary at index dict.
+type cursor struct {
+	dict  int
+	items []candidate
+}
+
+// mergeHeap orders cursors by their next key, then by dictionary priority.
+type mergeHeap []cursor
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].items[0].key != h[j].items[0].key {
+		return h[i].items[0].key < h[j].items[0].key
+	}
+	return h[i].dict < h[j].dict
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(cursor)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// merged is a distinct key with the dictionaries containing it, in
+// priority order; word is the headword of the first one.
+type merged struct {
+	key   string
+	word  string
+	dicts []int
+}
+
+// mergeCandidates k-way merges the cursors and returns the first limit
+// distinct keys in order. Equal keys come out in dictionary order, so the
+// highest priority dictionary provides the displayed word.
+func mergeCandidates(h mergeHeap, limit int) []merged {
+	heap.Init(&h)
+	var out []merged
+	for h.Len() > 0 {
+		c := &h[0]
+		it := c.items[0]
+		if n := len(out); n == 0 || out[n-1].key != it.key {
+			if n == limit {
+				break
+			}
+			out = append(out, merged{key: it.key, word: it.word})
+		}
+		m := &out[len(out)-1]
+		if n := len(m.dicts); n == 0 || m.dicts[n-1] != c.dict {
+			m.dicts = append(m.dicts, c.dict)
+		}
+		c.items = c.items[1:]
+		if len(c.items) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return out
+}
+
+// rank orders the merged words by exact match, priority of their first
+// dictionary and frequency, the number of dictionaries containing the
+// word; ties keep alphabetical order.
+func rank(words []merged, dicts []dict.Dictionary, exact string) []Suggestion {
+	sort.SliceStable(words, func(i, j int) bool {
+		a, b := words[i], words[j]
+		if ea, eb := a.key == exact, b.key == exact; ea != eb {
+			return ea
+		}
+		if a.dicts[0] != b.dicts[0] {
+			return a.dicts[0] < b.dicts[0]
+		}
+		return len(a.dicts) > len(b.dicts)
+	})
+	out := make([]Suggestion, len(words))
+	for i, w := range words {
+		ids := make([]string, len(w.dicts))
+		for j, d := range w.dicts {
+			ids[j] = dicts[d].ID()
+		}
+		out[i] = Suggestion{Word: w.word, Dicts: ids}
+	}
+	return out
+}